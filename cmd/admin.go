@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/crypto"
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Maintenance commands for a self-hosted data dir",
+}
+
+var rotateKEKCmd = &cobra.Command{
+	Use:   "rotate-kek",
+	Short: "Re-wrap all stored share keys under a new key-encryption key",
+	Long: `Unwraps every share key with the current KEK and wraps it again with a new
+one, in a single transaction. Legacy shares whose keys were stored in plaintext
+are wrapped as well.
+
+The current KEK is read from the usual sources (--kek-file, DURINS_DOOR_KEK,
+DURINS_DOOR_KEK_FILE, DURINS_DOOR_KEK_PASSPHRASE). The new one comes from
+--new-kek-file, DURINS_DOOR_NEW_KEK, or --new-passphrase.
+
+Stop the server first. Resumable uploads still in progress must be restarted.
+
+Examples:
+  durins-door admin gen-kek > new.kek
+  durins-door admin rotate-kek --kek-file old.kek --new-kek-file new.kek`,
+	Args: cobra.NoArgs,
+	RunE: runRotateKEK,
+}
+
+var genKEKCmd = &cobra.Command{
+	Use:   "gen-kek",
+	Short: "Print a new random key-encryption key (hex)",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kek, err := crypto.GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(kek))
+		return nil
+	},
+}
+
+var (
+	flagNewKEKFile    string
+	flagNewPassphrase bool
+)
+
+func init() {
+	adminCmd.PersistentFlags().StringVar(&flagKEKFile, "kek-file", "", "File containing the key-encryption key (hex or base64)")
+	rotateKEKCmd.Flags().StringVar(&flagNewKEKFile, "new-kek-file", "", "File containing the new key-encryption key")
+	rotateKEKCmd.Flags().BoolVar(&flagNewPassphrase, "new-passphrase", false, "Derive the new KEK from a passphrase (DURINS_DOOR_NEW_KEK_PASSPHRASE or prompt)")
+
+	adminCmd.AddCommand(rotateKEKCmd, genKEKCmd)
+	rootCmd.AddCommand(adminCmd)
+}
+
+func runRotateKEK(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	st, err := openStore(ctx)
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+
+	configured, err := st.KEKConfigured(ctx)
+	if err != nil {
+		return err
+	}
+	if err := setupKEK(ctx, st, configured); err != nil {
+		return fmt.Errorf("current KEK: %w", err)
+	}
+
+	// settings to write alongside the new KEK: a passphrase needs its salt,
+	// and switching away from a passphrase drops the old one.
+	settings := map[string]string{"kek_salt": ""}
+	var newKEK []byte
+	switch {
+	case flagNewKEKFile != "":
+		newKEK, err = readKEKFile(flagNewKEKFile)
+	case os.Getenv("DURINS_DOOR_NEW_KEK") != "":
+		newKEK, err = crypto.ParseKEK(os.Getenv("DURINS_DOOR_NEW_KEK"))
+	case flagNewPassphrase:
+		var passphrase string
+		passphrase, err = newKEKPassphrase()
+		if err != nil {
+			return err
+		}
+		var salt []byte
+		newKEK, salt, err = crypto.DeriveKey(passphrase)
+		settings["kek_salt"] = hex.EncodeToString(salt)
+	default:
+		return fmt.Errorf("no new KEK given: use --new-kek-file, DURINS_DOOR_NEW_KEK or --new-passphrase")
+	}
+	if err != nil {
+		return fmt.Errorf("new KEK: %w", err)
+	}
+
+	n, err := st.RotateKEK(ctx, newKEK, settings)
+	if err != nil {
+		return fmt.Errorf("rotate KEK: %w", err)
+	}
+	fmt.Printf("✅ Re-wrapped %d share key(s) under the new KEK.\n", n)
+	fmt.Println("   Configure the new KEK before restarting the server.")
+	return nil
+}
+
+// newKEKPassphrase reads the new passphrase from DURINS_DOOR_NEW_KEK_PASSPHRASE
+// or prompts for it twice.
+func newKEKPassphrase() (string, error) {
+	if p := os.Getenv("DURINS_DOOR_NEW_KEK_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	p, err := promptPw("New KEK passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := promptPw("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if p == "" || p != confirm {
+		return "", fmt.Errorf("passphrases are empty or do not match")
+	}
+	return p, nil
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <archive>",
+	Short: "Write a backup of all shares and their files to a tar archive",
+	Long: `Writes a consistent snapshot of the share database, the encrypted file of
+every share and a manifest with checksums to a tar archive ("-" for stdout).
+The server can keep running. Share keys stay wrapped under the KEK, which is
+not part of the archive: keep it, or the shares cannot be opened after a
+restore.
+
+With --encrypt the archive is encrypted under a passphrase (read from
+DURINS_DOOR_BACKUP_PASSPHRASE or prompted for), which also hides filenames
+and password hashes.
+
+Only the SQLite database can be exported; back up Postgres with pg_dump.
+
+Examples:
+  durins-door admin export backup.tar
+  durins-door admin export --encrypt - | ssh newhost 'cat > backup.tar'`,
+	Args: cobra.ExactArgs(1),
+	RunE: runExport,
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Restore a backup archive, merging it into this store",
+	Long: `Verifies an archive written by "admin export" ("-" for stdin) against its
+manifest and adds its shares, files, download logs and change history to this
+store. Shares that already exist are skipped, so an archive can be merged
+into a store in use or imported twice.
+
+Share keys are re-wrapped under this store's KEK. If the archive was made
+with a different KEK, pass the old one with --source-kek-file.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runImport,
+}
+
+var (
+	flagExportEncrypt bool
+	flagSourceKEKFile string
+)
+
+func init() {
+	exportCmd.Flags().BoolVar(&flagExportEncrypt, "encrypt", false, "Encrypt the archive with a passphrase")
+	importCmd.Flags().StringVar(&flagSourceKEKFile, "source-kek-file", "", "File containing the KEK the archive was made with, if different")
+	addBlobStoreFlags(exportCmd)
+	addBlobStoreFlags(importCmd)
+	adminCmd.AddCommand(exportCmd, importCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	var passphrase string
+	if flagExportEncrypt {
+		var err error
+		if passphrase, err = backupPassphrase(true); err != nil {
+			return err
+		}
+	}
+
+	st, err := openStore(ctx)
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+	if err := configureBlobStore(st); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so a failed export never
+	// leaves something that looks like a backup.
+	path := args[0]
+	var out io.Writer = os.Stdout
+	var f *os.File
+	if path != "-" {
+		f, err = os.OpenFile(path+".part", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		out = f
+	}
+
+	manifest, err := st.Export(ctx, out, passphrase)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(f.Name(), path); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "✅ Exported %d share(s) and %d file(s).\n", manifest.Shares, len(manifest.Files)-1)
+	if n := len(manifest.Missing); n > 0 {
+		fmt.Fprintf(os.Stderr, "⚠  %d share(s) had no file and will be skipped on import; see \"durins-door fsck\".\n", n)
+	}
+	return nil
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	st, err := openStore(ctx)
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+	if err := configureBlobStore(st); err != nil {
+		return err
+	}
+	if err := setupKEK(ctx, st, true); err != nil {
+		return err
+	}
+	var srcKEK []byte
+	if flagSourceKEKFile != "" {
+		if srcKEK, err = readKEKFile(flagSourceKEKFile); err != nil {
+			return fmt.Errorf("source KEK: %w", err)
+		}
+	}
+
+	dir, err := os.MkdirTemp(st.DataDir(), "import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := share.ExtractArchive(in, func() (string, error) {
+		return backupPassphrase(false)
+	}, dir)
+	if err != nil {
+		return fmt.Errorf("verify archive: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Archive verified: %d share(s) from %s.\n", manifest.Shares, manifest.CreatedAt.Format("2006-01-02 15:04 MST"))
+
+	res, err := st.Import(ctx, dir, srcKEK)
+	if errors.Is(err, share.ErrArchiveKEK) {
+		return fmt.Errorf("import: %w; pass the old KEK with --source-kek-file", err)
+	}
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	fmt.Printf("✅ Imported %d share(s) with %d download(s) logged and %d change(s).\n", res.Imported, res.Events, res.Changes)
+	if res.Skipped > 0 {
+		fmt.Printf("   %d share(s) were already present and left unchanged.\n", res.Skipped)
+	}
+	if res.Missing > 0 {
+		fmt.Printf("   %d share(s) had no file in the archive and were skipped.\n", res.Missing)
+	}
+	return nil
+}
+
+// backupPassphrase reads the archive passphrase from
+// DURINS_DOOR_BACKUP_PASSPHRASE or prompts for it, twice when confirm is
+// set.
+func backupPassphrase(confirm bool) (string, error) {
+	if p := os.Getenv("DURINS_DOOR_BACKUP_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	p, err := promptPw("Backup passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if !confirm {
+		return p, nil
+	}
+	again, err := promptPw("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if p == "" || p != again {
+		return "", fmt.Errorf("passphrases are empty or do not match")
+	}
+	return p, nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/blob"
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+// Blob storage flags, shared by the commands that write or serve files.
+// Each falls back to an environment variable so "share" and "server"
+// agree without repeating flags.
+var (
+	flagBlobStore  string
+	flagS3Endpoint string
+	flagS3Bucket   string
+	flagS3Region   string
+	flagS3Prefix   string
+)
+
+func addBlobStoreFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&flagBlobStore, "blob-store", "", `Where encrypted files are kept: "local" or "s3" (env DURINS_DOOR_BLOB_STORE)`)
+	cmd.Flags().StringVar(&flagS3Endpoint, "s3-endpoint", "", "S3-compatible endpoint URL, e.g. http://localhost:9000 (env DURINS_DOOR_S3_ENDPOINT)")
+	cmd.Flags().StringVar(&flagS3Bucket, "s3-bucket", "", "S3 bucket (env DURINS_DOOR_S3_BUCKET)")
+	cmd.Flags().StringVar(&flagS3Region, "s3-region", "", "S3 region (env DURINS_DOOR_S3_REGION, default us-east-1)")
+	cmd.Flags().StringVar(&flagS3Prefix, "s3-prefix", "", "Key prefix inside the bucket (env DURINS_DOOR_S3_PREFIX)")
+}
+
+// configureBlobStore installs the configured blob store on st. The default
+// is the local data dir, which NewStore already set up.
+func configureBlobStore(st *share.Store) error {
+	kind := flagOrEnv(flagBlobStore, "DURINS_DOOR_BLOB_STORE")
+	switch kind {
+	case "", "local":
+		return nil
+	case "s3":
+		s3, err := blob.NewS3(blob.S3Config{
+			Endpoint:     flagOrEnv(flagS3Endpoint, "DURINS_DOOR_S3_ENDPOINT"),
+			Bucket:       flagOrEnv(flagS3Bucket, "DURINS_DOOR_S3_BUCKET"),
+			Region:       flagOrEnv(flagS3Region, "DURINS_DOOR_S3_REGION", "AWS_REGION"),
+			Prefix:       flagOrEnv(flagS3Prefix, "DURINS_DOOR_S3_PREFIX"),
+			AccessKey:    flagOrEnv("", "DURINS_DOOR_S3_ACCESS_KEY", "AWS_ACCESS_KEY_ID"),
+			SecretKey:    flagOrEnv("", "DURINS_DOOR_S3_SECRET_KEY", "AWS_SECRET_ACCESS_KEY"),
+			SessionToken: flagOrEnv("", "AWS_SESSION_TOKEN"),
+		})
+		if err != nil {
+			return err
+		}
+		st.SetBlobStore(s3)
+		return nil
+	default:
+		return fmt.Errorf("unknown blob store %q (want local or s3)", kind)
+	}
+}
+
+// flagOrEnv returns the flag value if set, else the first non-empty
+// environment variable.
+func flagOrEnv(flag string, envs ...string) string {
+	if flag != "" {
+		return flag
+	}
+	for _, env := range envs {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+// flagDatabaseURL selects the metadata database. Empty means the SQLite file
+// in the data dir; a postgres:// URL shares the web app's Supabase database.
+var flagDatabaseURL string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&flagDatabaseURL, "database-url", "",
+		"Postgres URL for share metadata (env DURINS_DOOR_DATABASE_URL; default SQLite in the data dir)")
+}
+
+// openStore opens the share store on the configured metadata database.
+func openStore(ctx context.Context) (*share.Store, error) {
+	url := flagOrEnv(flagDatabaseURL, "DURINS_DOOR_DATABASE_URL")
+	if url == "" {
+		return share.NewStore(dataDir())
+	}
+	if !strings.HasPrefix(url, "postgres://") && !strings.HasPrefix(url, "postgresql://") {
+		return nil, errors.New("unsupported database URL (want postgres://...)")
+	}
+	meta, err := share.NewPostgres(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	st, err := share.New(meta, dataDir())
+	if err != nil {
+		meta.Close()
+		return nil, err
+	}
+	return st, nil
+}
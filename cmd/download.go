@@ -1,33 +1,44 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/term"
 
+	"github.com/unisoniq/durins-door/internal/apiclient"
 	"github.com/unisoniq/durins-door/internal/webcrypto"
 )
 
-var downloadOutput string
+var (
+	downloadOutput string
+	downloadCode   string
+)
 
 var downloadCmd = &cobra.Command{
 	Use:   "download <url>",
 	Short: "Download and decrypt a shared file from a remote server",
 	Long: `Downloads the encrypted blob from a Durin's Door server and decrypts it
-using the key embedded in the URL fragment.`,
+using the key embedded in the URL fragment.
+
+A share with a password or a TOTP code asks for them; --code gives the
+code up front, from an authenticator app or oathtool.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runDownload,
 }
 
 func init() {
 	downloadCmd.Flags().StringVarP(&downloadOutput, "output", "o", "", "Output file path (default: original filename)")
+	downloadCmd.Flags().StringVar(&downloadCode, "code", "", "Current TOTP code, for shares that need one (default: ask)")
 	rootCmd.AddCommand(downloadCmd)
 }
 
@@ -42,10 +53,24 @@ func runDownload(cmd *cobra.Command, args []string) error {
 
 	if keyB64 == "" {
 		return fmt.Errorf("no encryption key found in URL fragment\n" +
-			"The key must be in the URL fragment: https://…/share/<id>#<key>")
+			"The key must be in the URL fragment: https://…/d/<id>#key=<key>")
 	}
 
+	// Talk to the server the link points at unless --server-url was given.
+	// Downloads need no token, so none is sent to a server other than the
+	// configured one.
+	foreign := false
+	if !cmd.Flags().Changed("server-url") {
+		if u, err := url.Parse(rawURL); err == nil && u.Scheme != "" && u.Host != "" {
+			linkServer := u.Scheme + "://" + u.Host
+			foreign = linkServer != strings.TrimRight(flagServerURL, "/")
+			flagServerURL = linkServer
+		}
+	}
 	client := newAPIClient()
+	if foreign {
+		client.AdminToken = ""
+	}
 
 	fmt.Fprintln(os.Stderr, "Fetching share metadata...")
 	share, err := client.GetShare(shareID)
@@ -53,16 +78,15 @@ func runDownload(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("fetching share: %w", err)
 	}
 
-	// Password check (client-side bcrypt verification)
-	if share.PasswordHash != nil && *share.PasswordHash != "" {
-		password, err := promptPw("Password: ")
-		if err != nil {
-			return fmt.Errorf("reading password: %w", err)
-		}
-		if err := bcrypt.CompareHashAndPassword([]byte(*share.PasswordHash), []byte(password)); err != nil {
-			return fmt.Errorf("incorrect password")
-		}
-		fmt.Fprintln(os.Stderr, "Password accepted.")
+	// The server checks no password before the embargo lifts.
+	if share.AvailableFrom != nil && time.Now().Before(*share.AvailableFrom) {
+		return fmt.Errorf("this share is not available until %s (in %s)",
+			share.AvailableFrom.Local().Format(time.RFC1123), time.Until(*share.AvailableFrom).Round(time.Second))
+	}
+
+	ticket, password, err := unlockShare(client, share, downloadCode)
+	if err != nil {
+		return err
 	}
 
 	// Check download limits
@@ -73,7 +97,7 @@ func runDownload(cmd *cobra.Command, args []string) error {
 
 	// Download encrypted blob
 	fmt.Fprintln(os.Stderr, "Downloading...")
-	blob, err := client.DownloadFile(shareID)
+	blob, err := client.DownloadFile(shareID, ticket)
 	if err != nil {
 		return fmt.Errorf("downloading: %w", err)
 	}
@@ -81,7 +105,11 @@ func runDownload(cmd *cobra.Command, args []string) error {
 
 	// Decrypt
 	fmt.Fprintln(os.Stderr, "Decrypting...")
-	plaintext, err := webcrypto.Decrypt(blob, keyB64)
+	key, err := webcrypto.DecodeKey(keyB64)
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	plaintext, err := decryptBlob(blob, key, password)
 	if err != nil {
 		return fmt.Errorf("decryption failed: %w", err)
 	}
@@ -104,12 +132,89 @@ func runDownload(cmd *cobra.Command, args []string) error {
 	}
 	fmt.Fprintf(os.Stderr, "\nSaved to %s\n", outPath)
 
-	// Increment download counter (best-effort)
+	// The web app's API counts downloads here (best-effort); a self-hosted
+	// server has already counted it when the file finished sending.
 	_ = client.IncrementDownloads(shareID)
 
 	return nil
 }
 
+// unlockShare asks for the password of a password-protected share, and
+// for the TOTP code of a share that needs one unless code is given, and
+// returns the password with a download ticket. The server exchanges a
+// correct password and code for the ticket; the web app's API cannot, and
+// sends the hash to check here instead, so the ticket is then empty.
+func unlockShare(client *apiclient.Client, share *apiclient.Share, code string) (ticket, password string, err error) {
+	hasPassword := share.PasswordProtected || share.PasswordHash != nil
+	if !hasPassword && !share.TOTPRequired {
+		return "", "", nil
+	}
+	if hasPassword {
+		password, err = promptPw("Password: ")
+		if err != nil {
+			return "", "", fmt.Errorf("reading password: %w", err)
+		}
+	}
+	if share.TOTPRequired && code == "" {
+		code, err = promptPw("TOTP code: ")
+		if err != nil {
+			return "", "", fmt.Errorf("reading code: %w", err)
+		}
+	}
+	ticket, err = client.VerifyPassword(share.ID, password, code)
+	switch {
+	case errors.Is(err, apiclient.ErrVerifyUnsupported) && share.PasswordHash != nil:
+		if err := bcrypt.CompareHashAndPassword([]byte(*share.PasswordHash), []byte(password)); err != nil {
+			return "", "", fmt.Errorf("incorrect password")
+		}
+	case err != nil && share.TOTPRequired:
+		return "", "", fmt.Errorf("checking password and code: %w", err)
+	case err != nil:
+		return "", "", fmt.Errorf("checking password: %w", err)
+	}
+	switch {
+	case hasPassword && share.TOTPRequired:
+		fmt.Fprintln(os.Stderr, "Password and code accepted.")
+	case share.TOTPRequired:
+		fmt.Fprintln(os.Stderr, "Code accepted.")
+	default:
+		fmt.Fprintln(os.Stderr, "Password accepted.")
+	}
+	return ticket, password, nil
+}
+
+// decryptBlob decrypts a downloaded blob with key. A blob sealed with
+// "--seal" also needs the share's password: password if the user has
+// given it already, otherwise they are asked. A wrong one may be retried,
+// since a limited download cannot be fetched again.
+func decryptBlob(blob, key []byte, password string) ([]byte, error) {
+	if webcrypto.IsStream(blob) {
+		var out bytes.Buffer
+		if err := webcrypto.DecryptStream(&out, bytes.NewReader(blob), key); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+	if !webcrypto.IsSealed(blob) {
+		return webcrypto.DecryptRaw(blob, key)
+	}
+	for attempt := 1; ; attempt++ {
+		if password == "" {
+			var err error
+			password, err = promptPw("This file is sealed with a password. Password: ")
+			if err != nil {
+				return nil, fmt.Errorf("reading password: %w", err)
+			}
+		}
+		plaintext, err := webcrypto.Unseal(blob, key, password)
+		if err == nil || attempt == 3 {
+			return plaintext, err
+		}
+		fmt.Fprintln(os.Stderr, "That password does not open the file.")
+		password = ""
+	}
+}
+
 func parseShareURL(raw string) (id, keyB64 string, err error) {
 	if !strings.Contains(raw, "://") && !strings.HasPrefix(raw, "/") {
 		return raw, "", nil
@@ -120,7 +225,7 @@ func parseShareURL(raw string) (id, keyB64 string, err error) {
 		return "", "", fmt.Errorf("invalid URL: %w", err)
 	}
 
-	keyB64 = u.Fragment
+	keyB64 = fragmentKey(u.Fragment)
 
 	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
 	for i, p := range parts {
@@ -139,6 +244,18 @@ func parseShareURL(raw string) (id, keyB64 string, err error) {
 	return "", "", fmt.Errorf("cannot extract share ID from URL: %s", raw)
 }
 
+// fragmentKey extracts the key from a URL fragment. Links from the browser
+// and "durins-door upload" use "#key=<base64url>"; a bare "#<key>" is also
+// accepted.
+func fragmentKey(fragment string) string {
+	for _, part := range strings.Split(fragment, "&") {
+		if k, v, ok := strings.Cut(part, "="); ok && k == "key" {
+			return v
+		}
+	}
+	return fragment
+}
+
 func promptPw(prompt string) (string, error) {
 	fmt.Fprint(os.Stderr, prompt)
 	pw, err := term.ReadPassword(int(syscall.Stdin))
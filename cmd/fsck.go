@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check share records against the stored encrypted files",
+	Long: `Compares every share, including those in the trash, with the blob store and
+reports:
+
+  orphan_blob     a file no share refers to (left by a crashed upload or a
+                  failed delete; files modified in the last hour are skipped)
+  missing_blob    a share whose file is gone
+  size_mismatch   a file whose chunks do not add up to the share's recorded
+                  size
+  bad_key         a share key that cannot be unwrapped with the KEK
+  bad_header      a file whose first chunk does not decrypt under its key
+
+Key and header checks need the KEK. Client-encrypted files can only be
+size-checked.
+
+With --repair, after confirmation, orphan and broken files are moved under
+"quarantine/" in the blob store (or deleted with --delete) and shares whose
+file is missing or broken are removed. Size mismatches are never repaired,
+since the file may still decrypt; inspect them by hand. The command exits
+non-zero while problems remain.`,
+	Args: cobra.NoArgs,
+	RunE: runFsck,
+}
+
+var (
+	flagFsckJSON   bool
+	flagFsckRepair bool
+	flagFsckDelete bool
+	flagFsckYes    bool
+)
+
+func init() {
+	fsckCmd.Flags().BoolVar(&flagFsckJSON, "json", false, "Print the report as JSON")
+	fsckCmd.Flags().BoolVar(&flagFsckRepair, "repair", false, "Quarantine bad files and remove broken shares")
+	fsckCmd.Flags().BoolVar(&flagFsckDelete, "delete", false, "With --repair, delete bad files instead of quarantining them")
+	fsckCmd.Flags().BoolVarP(&flagFsckYes, "yes", "y", false, "With --repair, do not ask for confirmation")
+	fsckCmd.Flags().StringVar(&flagKEKFile, "kek-file", "", "File containing the key-encryption key (hex or base64)")
+	addBlobStoreFlags(fsckCmd)
+	rootCmd.AddCommand(fsckCmd)
+}
+
+func runFsck(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	if flagFsckDelete && !flagFsckRepair {
+		return fmt.Errorf("--delete only applies with --repair")
+	}
+
+	st, err := openStore(ctx)
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+	if err := configureBlobStore(st); err != nil {
+		return err
+	}
+	if err := setupKEK(ctx, st, false); err != nil {
+		return err
+	}
+
+	report, err := st.Fsck(ctx)
+	if err != nil {
+		return fmt.Errorf("fsck: %w", err)
+	}
+	if flagFsckJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else if err := printFsckReport(report); err != nil {
+		return err
+	}
+
+	n := len(report.Problems)
+	if n == 0 {
+		return nil
+	}
+	if !flagFsckRepair {
+		return fmt.Errorf("%d problem(s) found; run with --repair to fix them", n)
+	}
+
+	var fixable []share.Problem
+	for _, p := range report.Problems {
+		if p.Repairable() {
+			fixable = append(fixable, p)
+		}
+	}
+	manual := n - len(fixable)
+	if len(fixable) == 0 {
+		return fmt.Errorf("%d size mismatch(es) found; --repair leaves them for you to inspect", manual)
+	}
+
+	action := "moved under quarantine/"
+	if flagFsckDelete {
+		action = "deleted"
+	}
+	fmt.Fprintf(os.Stderr, "\nBad files will be %s and shares with missing or bad files removed.\n", action)
+	if !flagFsckYes && !promptConfirm(fmt.Sprintf("Repair %d problem(s)? [y/N]: ", len(fixable))) {
+		return fmt.Errorf("aborted; %d problem(s) left", n)
+	}
+
+	failed := 0
+	for _, p := range fixable {
+		if err := st.Repair(ctx, p, flagFsckDelete); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %s %s: %v\n", p.Kind, p.BlobKey, err)
+			failed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "✅ Repaired %d problem(s).\n", len(fixable)-failed)
+	if failed > 0 {
+		return fmt.Errorf("%d problem(s) could not be repaired", failed)
+	}
+	if manual > 0 {
+		return fmt.Errorf("%d size mismatch(es) left for you to inspect", manual)
+	}
+	return nil
+}
+
+func printFsckReport(report *share.FsckReport) error {
+	fmt.Printf("Checked %d share(s) and %d file(s).\n", report.Shares, report.Blobs)
+	if !report.HeadersChecked {
+		fmt.Println("⚠  No KEK configured: share keys and file headers were not checked.")
+	}
+	if len(report.Problems) == 0 {
+		fmt.Println("✅ No problems found.")
+		return nil
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROBLEM\tSHARE\tFILE\tDETAIL")
+	for _, p := range report.Problems {
+		id := "-"
+		if p.ShareID != "" {
+			id = p.ShareID[:min(16, len(p.ShareID))]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Kind, id, p.BlobKey, p.Detail)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <share-id>",
+	Short: "Show the download log and changes of a share",
+	Long: `Lists every recorded download of a share: when it happened, a hash of the
+client's IP address, its user agent, how many bytes were sent and whether the
+transfer completed. Changes made with update (or PATCH /api/shares/{id}) are
+listed after the downloads.
+
+The log is kept after a share is revoked or burned; pass the full ID to read
+it then. The server drops download entries older than --event-retention.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	st, err := openStore(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+
+	id, err := resolveShareID(cmd.Context(), st, args[0])
+	if err != nil {
+		return err
+	}
+	events, err := st.DownloadEvents(cmd.Context(), id)
+	if err != nil {
+		return fmt.Errorf("read download log: %w", err)
+	}
+	changes, err := st.ShareChanges(cmd.Context(), id)
+	if err != nil {
+		return fmt.Errorf("read share changes: %w", err)
+	}
+
+	if len(events) == 0 {
+		fmt.Println("No downloads recorded.")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tCLIENT\tSENT\tRESULT\tUSER AGENT")
+		for _, ev := range events {
+			result := "✅ completed"
+			if !ev.Completed {
+				result = "⚠️  aborted"
+			}
+			client := ev.IPHash
+			if client == "" {
+				client = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				ev.Time.Format(time.RFC822),
+				client,
+				humanSizeCmd(ev.Bytes),
+				result,
+				truncate(ev.UserAgent, 60),
+			)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(changes) > 0 {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tBY\tCHANGE")
+		for _, c := range changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Time.Format(time.RFC822), c.Actor, c.Detail)
+		}
+		return w.Flush()
+	}
+	return nil
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/unisoniq/durins-door/internal/crypto"
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+// flagKEKFile points at a file holding the key-encryption key.
+var flagKEKFile string
+
+// errNoKEK explains how to configure a KEK when none is found.
+var errNoKEK = errors.New("no key-encryption key (KEK) configured\n" +
+	"Set one of:\n" +
+	"  DURINS_DOOR_KEK=<64 hex chars>        (generate with: durins-door admin gen-kek)\n" +
+	"  DURINS_DOOR_KEK_FILE=<path> or --kek-file <path>\n" +
+	"  DURINS_DOOR_KEK_PASSPHRASE=<passphrase>")
+
+// loadKEK returns the server master key used to wrap share keys, or nil if
+// none is configured. Sources, in order: --kek-file, DURINS_DOOR_KEK,
+// DURINS_DOOR_KEK_FILE, DURINS_DOOR_KEK_PASSPHRASE. A passphrase is stretched
+// with Argon2id; its salt lives in the store's settings table.
+func loadKEK(ctx context.Context, st *share.Store) ([]byte, error) {
+	path := flagKEKFile
+	if path == "" && os.Getenv("DURINS_DOOR_KEK") != "" {
+		kek, err := crypto.ParseKEK(os.Getenv("DURINS_DOOR_KEK"))
+		if err != nil {
+			return nil, fmt.Errorf("DURINS_DOOR_KEK: %w", err)
+		}
+		return kek, nil
+	}
+	if path == "" {
+		path = os.Getenv("DURINS_DOOR_KEK_FILE")
+	}
+	if path != "" {
+		return readKEKFile(path)
+	}
+
+	if passphrase := os.Getenv("DURINS_DOOR_KEK_PASSPHRASE"); passphrase != "" {
+		saltHex, err := st.Setting(ctx, "kek_salt")
+		if err != nil {
+			return nil, err
+		}
+		if saltHex == "" {
+			kek, salt, err := crypto.DeriveKey(passphrase)
+			if err != nil {
+				return nil, err
+			}
+			if err := st.SetSetting(ctx, "kek_salt", hex.EncodeToString(salt)); err != nil {
+				return nil, err
+			}
+			return kek, nil
+		}
+		salt, err := hex.DecodeString(saltHex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kek_salt setting: %w", err)
+		}
+		return crypto.DeriveKeyWithSalt(passphrase, salt)
+	}
+
+	return nil, nil
+}
+
+// setupKEK loads the KEK and installs it on st. When required is false a
+// missing KEK is not an error, but a wrong one still is.
+func setupKEK(ctx context.Context, st *share.Store, required bool) error {
+	kek, err := loadKEK(ctx, st)
+	if err != nil {
+		return err
+	}
+	if kek == nil {
+		if required {
+			return errNoKEK
+		}
+		return nil
+	}
+	if err := st.SetKEK(ctx, kek); err != nil {
+		if errors.Is(err, share.ErrWrongKEK) {
+			return fmt.Errorf("%w — refusing to start with a different KEK than the one this data dir was set up with", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func readKEKFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read KEK file: %w", err)
+	}
+	kek, err := crypto.ParseKEK(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("KEK file %s: %w", path, err)
+	}
+	return kek, nil
+}
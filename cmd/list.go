@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -13,33 +14,73 @@ import (
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all active shares",
-	RunE:  runList,
+	Long: `Lists the shares that are not in the trash, newest first.
+
+--tag shows only shares carrying every given tag, --search matches the start
+of the ID or any part of the filename, note or tags, and --status is one of
+active, embargoed, expired or exhausted.
+
+--sort orders by created, expires, size, name or downloads; prefix the key
+with "-" for descending order.
+
+Examples:
+  durins-door list --tag clients
+  durins-door list --search invoice --status active
+  durins-door list --sort=-size --limit 10`,
+	RunE: runList,
 }
 
+var (
+	flagListTags   []string
+	flagListSearch string
+	flagListStatus string
+	flagListSort   string
+	flagListLimit  int
+)
+
 func init() {
+	listCmd.Flags().StringSliceVar(&flagListTags, "tag", nil, "Only shares with this tag (repeatable or comma-separated)")
+	listCmd.Flags().StringVar(&flagListSearch, "search", "", "Only shares whose ID, filename, note or tags match")
+	listCmd.Flags().StringVar(&flagListStatus, "status", "", "Only shares with this status: active, embargoed, expired or exhausted")
+	listCmd.Flags().StringVar(&flagListSort, "sort", share.DefaultSort, "Sort key, with - for descending")
+	listCmd.Flags().IntVar(&flagListLimit, "limit", 0, "Show at most this many shares (0 = all)")
 	rootCmd.AddCommand(listCmd)
 }
 
 func runList(cmd *cobra.Command, args []string) error {
-	st, err := share.NewStore(dataDir())
+	filter, err := share.ParseListFilter(flagListTags, flagListSearch, flagListStatus)
+	if err != nil {
+		return err
+	}
+	sort, err := share.ParseSort(flagListSort)
+	if err != nil {
+		return err
+	}
+
+	st, err := openStore(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("open store: %w", err)
 	}
 	defer st.Close()
 
-	shares, err := st.List(cmd.Context())
+	page, err := st.List(cmd.Context(), share.ListQuery{ListFilter: filter, Sort: sort, Limit: flagListLimit})
 	if err != nil {
 		return fmt.Errorf("list shares: %w", err)
 	}
+	shares := page.Shares
 
 	if len(shares) == 0 {
-		fmt.Println("No active shares.")
+		if !filter.IsZero() {
+			fmt.Println("No shares match.")
+		} else {
+			fmt.Println("No active shares.")
+		}
 		return nil
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "ID\tFILE\tSIZE\tDOWNLOADS\tEXPIRES\tSTATUS")
-	fmt.Fprintln(w, "──────────────────\t────────────────\t────────\t──────────\t─────────────────────\t──────")
+	fmt.Fprintln(w, "ID\tFILE\tSIZE\tDOWNLOADS\tEXPIRES\tSTATUS\tTAGS\tNOTE")
+	fmt.Fprintln(w, "──────────────────\t────────────────\t────────\t──────────\t─────────────────────\t──────\t────\t────")
 
 	for _, sh := range shares {
 		status := "✅ active"
@@ -47,20 +88,37 @@ func runList(cmd *cobra.Command, args []string) error {
 			status = "⏰ expired"
 		} else if sh.IsExhausted() {
 			status = "🚫 exhausted"
+		} else if sh.IsEmbargoed() {
+			status = "🌙 opens " + sh.AvailableFrom.Format(time.RFC822)
+		} else if sh.Burn {
+			status = "🔥 burn"
 		}
 		downloads := fmt.Sprintf("%d", sh.Downloads)
 		if sh.MaxDownloads > 0 {
 			downloads = fmt.Sprintf("%d / %d", sh.Downloads, sh.MaxDownloads)
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
 			sh.ID[:16],
 			sh.Filename,
 			humanSizeCmd(sh.Size),
 			downloads,
-			sh.ExpiresAt.Format(time.RFC822),
+			expiryString(sh.ExpiresAt),
 			status,
+			strings.Join(sh.Tags, ","),
+			truncate(sh.Note, 40),
 		)
 	}
 	w.Flush()
+	if page.Next != "" {
+		fmt.Printf("\nMore shares match; raise --limit to see them.\n")
+	}
 	return nil
 }
+
+// expiryString formats a share's expiry, "never" for shares without one.
+func expiryString(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC822)
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Show or change the SQLite schema version",
+	Long: `Lists the schema migrations built into this binary and whether each has been
+applied to the data dir's database (--status, the default), applies all pending
+ones (--up), or reverts the most recent one (--down).
+
+Every command applies pending migrations when it opens the database, and
+refuses to run against a database migrated by a newer binary. Reverting a
+migration drops whatever it added, including data; stop the server and back
+up shares.db first. --down asks before reverting unless --yes is given, and
+refuses to revert 0001_initial, which drops every share, without --force.
+
+The Postgres schema (--database-url) is managed by supabase/migrations.`,
+	Args: cobra.NoArgs,
+	RunE: runMigrate,
+}
+
+var (
+	flagMigrateStatus bool
+	flagMigrateUp     bool
+	flagMigrateDown   bool
+	flagMigrateYes    bool
+	flagMigrateForce  bool
+)
+
+func init() {
+	migrateCmd.Flags().BoolVar(&flagMigrateStatus, "status", false, "List migrations and whether they are applied (default)")
+	migrateCmd.Flags().BoolVar(&flagMigrateUp, "up", false, "Apply all pending migrations")
+	migrateCmd.Flags().BoolVar(&flagMigrateDown, "down", false, "Revert the most recently applied migration")
+	migrateCmd.Flags().BoolVarP(&flagMigrateYes, "yes", "y", false, "With --down, do not ask for confirmation")
+	migrateCmd.Flags().BoolVar(&flagMigrateForce, "force", false, "With --down, allow reverting 0001_initial")
+	migrateCmd.MarkFlagsMutuallyExclusive("status", "up", "down")
+	adminCmd.AddCommand(migrateCmd)
+}
+
+func runMigrate(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	if flagOrEnv(flagDatabaseURL, "DURINS_DOOR_DATABASE_URL") != "" {
+		return errors.New("the Postgres schema is managed by supabase/migrations; admin migrate only handles SQLite")
+	}
+
+	path := share.SQLitePath(dataDir())
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no database at %s", path)
+	}
+	db, err := share.OpenSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case flagMigrateUp:
+		done, err := db.MigrateUp(ctx)
+		for _, m := range done {
+			fmt.Printf("✅ Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("Schema is up to date.")
+		}
+		return nil
+
+	case flagMigrateDown:
+		if err := confirmMigrateDown(ctx, db); err != nil {
+			return err
+		}
+		m, err := db.MigrateDown(ctx)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Println("No migrations are applied.")
+			return nil
+		}
+		fmt.Printf("↩️  Reverted %04d_%s\n", m.Version, m.Name)
+		return nil
+	}
+
+	migrations, err := db.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, m := range migrations {
+		applied := "pending"
+		switch {
+		case m.Unknown:
+			applied = m.AppliedAt.Format(time.RFC822) + " (unknown to this binary)"
+		case m.Applied():
+			applied = m.AppliedAt.Format(time.RFC822)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, applied)
+	}
+	return w.Flush()
+}
+
+// confirmMigrateDown checks that the user wants the most recently applied
+// migration reverted. Reverting 0001_initial drops every table, so it also
+// needs --force.
+func confirmMigrateDown(ctx context.Context, db *share.SQLite) error {
+	migrations, err := db.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	var last *share.Migration
+	for i := range migrations {
+		if migrations[i].Applied() {
+			last = &migrations[i]
+		}
+	}
+	if last == nil || last.Unknown {
+		return nil // MigrateDown reports both
+	}
+	name := fmt.Sprintf("%04d_%s", last.Version, last.Name)
+	if last.Version == 1 && !flagMigrateForce {
+		return fmt.Errorf("reverting %s drops every share; pass --force to do it anyway", name)
+	}
+	if !flagMigrateYes && !promptConfirm(fmt.Sprintf("Revert %s? Whatever it added is dropped, including data. [y/N]: ", name)) {
+		return fmt.Errorf("aborted; %s left applied", name)
+	}
+	return nil
+}
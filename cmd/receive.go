@@ -11,7 +11,6 @@ import (
 
 	"github.com/unisoniq/durins-door/internal/handshake"
 	"github.com/unisoniq/durins-door/internal/progress"
-	"github.com/unisoniq/durins-door/internal/webcrypto"
 	"github.com/unisoniq/durins-door/internal/wordlist"
 )
 
@@ -107,18 +106,28 @@ func runReceive(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("fetching share: %w", err)
 	}
 	fmt.Fprintf(os.Stderr, "Receiving: %s (%s)\n", share.Filename, formatSizeCmd(share.FileSize))
+	if share.AvailableFrom != nil && time.Now().Before(*share.AvailableFrom) {
+		fmt.Fprintf(os.Stderr, "The sender embargoed this file until %s; waiting (Ctrl+C to give up)...\n",
+			share.AvailableFrom.Local().Format(time.RFC1123))
+		time.Sleep(time.Until(*share.AvailableFrom) + time.Second)
+	}
 
-	// 9. Download encrypted blob
-	blob, err := client.DownloadFile(*withShare.ShareID)
+	// 9. Download encrypted blob, asking for the password if the sender
+	// set one
+	ticket, password, err := unlockShare(client, share, "")
+	if err != nil {
+		return err
+	}
+	blob, err := client.DownloadFile(*withShare.ShareID, ticket)
 	if err != nil {
 		return fmt.Errorf("downloading: %w", err)
 	}
 	progress.PrintDone()
 
-	// 10. Decrypt with ECDH-derived key
-	plaintext, err := webcrypto.DecryptRaw(blob, sharedSecret)
+	// 10. Decrypt with ECDH-derived key (and the password, if sealed)
+	plaintext, err := decryptBlob(blob, sharedSecret, password)
 	if err != nil {
-		return fmt.Errorf("decryption failed — shared secret mismatch: %w", err)
+		return fmt.Errorf("decryption failed — shared secret or password mismatch: %w", err)
 	}
 
 	// 11. Write output
@@ -141,7 +150,8 @@ func runReceive(_ *cobra.Command, _ []string) error {
 	}
 	fmt.Fprintf(os.Stderr, "File saved: %s\n", outPath)
 
-	// Best-effort download counter bump
+	// Best-effort download counter bump for the web app's API; a
+	// self-hosted server counts when the file finishes sending.
 	_ = client.IncrementDownloads(*withShare.ShareID)
 
 	return nil
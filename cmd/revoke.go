@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/spf13/cobra"
@@ -9,47 +10,36 @@ import (
 
 var revokeCmd = &cobra.Command{
 	Use:   "revoke <share-id>",
-	Short: "Revoke a share and delete its encrypted file",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runRevoke,
+	Short: "Revoke a share, moving it to the trash",
+	Long: `Revokes a share: its links stop working at once. The share and its
+encrypted file go to the trash, where "durins-door trash restore" can bring
+them back until the server's --trash-retention runs out or the trash is
+emptied.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRevoke,
 }
 
 func init() {
+	addBlobStoreFlags(revokeCmd)
 	rootCmd.AddCommand(revokeCmd)
 }
 
 func runRevoke(cmd *cobra.Command, args []string) error {
 	id := args[0]
 
-	st, err := share.NewStore(dataDir())
+	st, err := openStore(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("open store: %w", err)
 	}
 	defer st.Close()
+	if err := configureBlobStore(st); err != nil {
+		return err
+	}
 
-	// Try full ID or prefix match
-	shares, err := st.List(cmd.Context())
+	resolvedID, err := resolveShareID(cmd.Context(), st, id)
 	if err != nil {
 		return err
 	}
-	resolvedID := id
-	if len(id) < 32 {
-		// prefix search
-		var matches []string
-		for _, sh := range shares {
-			if len(sh.ID) >= len(id) && sh.ID[:len(id)] == id {
-				matches = append(matches, sh.ID)
-			}
-		}
-		switch len(matches) {
-		case 0:
-			return fmt.Errorf("no share found with ID prefix %q", id)
-		case 1:
-			resolvedID = matches[0]
-		default:
-			return fmt.Errorf("ambiguous ID prefix %q — matches: %v", id, matches)
-		}
-	}
 
 	sh, err := st.Get(cmd.Context(), resolvedID)
 	if err != nil {
@@ -63,6 +53,43 @@ func runRevoke(cmd *cobra.Command, args []string) error {
 	if err := st.Revoke(cmd.Context(), resolvedID); err != nil {
 		return fmt.Errorf("revoke: %w", err)
 	}
-	fmt.Println("✅ Share revoked and file deleted.")
+	fmt.Println("✅ Share revoked and moved to the trash.")
+	fmt.Printf("   Undo with: durins-door trash restore %s\n", sh.ID)
 	return nil
 }
+
+// resolveShareID expands a share ID prefix (as printed by list) to the full
+// ID of the one share it matches. A full-length ID is returned unchanged.
+func resolveShareID(ctx context.Context, st *share.Store, id string) (string, error) {
+	if len(id) >= 32 {
+		return id, nil
+	}
+	page, err := st.List(ctx, share.ListQuery{})
+	if err != nil {
+		return "", err
+	}
+	return matchShareID(page.Shares, id)
+}
+
+// matchShareID returns the ID of the one share in shares that starts with
+// prefix.
+func matchShareID(shares []*share.Share, prefix string) (string, error) {
+	id := prefix
+	if len(id) >= 32 {
+		return id, nil
+	}
+	var matches []string
+	for _, sh := range shares {
+		if len(sh.ID) >= len(id) && sh.ID[:len(id)] == id {
+			matches = append(matches, sh.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no share found with ID prefix %q", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous ID prefix %q — matches: %v", id, matches)
+	}
+}
@@ -54,7 +54,8 @@ Usage:
   durins-door send <file> --to <CODE>   # Send a file to a waiting receiver
   durins-door receive                   # Wait for a peer to send you a file
   durins-door list                      # List active shares
-  durins-door revoke <id>               # Revoke a share
+  durins-door revoke <id>               # Revoke a share (restorable from the trash)
+  durins-door trash restore <id>        # Undo a revoke
   durins-door server                    # Start standalone server`,
 	SilenceUsage: true,
 	Version:      Version,
@@ -18,8 +18,10 @@ import (
 var (
 	sendTo           string
 	sendPassword     string
+	sendSeal         bool
 	sendExpires      string
 	sendMaxDownloads int
+	sendAvailableAt  string
 )
 
 var sendCmd = &cobra.Command{
@@ -27,7 +29,12 @@ var sendCmd = &cobra.Command{
 	Short: "Send a file to a waiting receiver (handshake mode)",
 	Long: `Connects to a receiver's handshake session via pairing code.
 Both parties compute an ECDH shared secret. A Tolkien verification phrase
-lets both parties confirm no MITM tampered with the exchange.`,
+lets both parties confirm no MITM tampered with the exchange.
+
+--password makes the server ask the receiver for the password before it
+hands out the file. With --seal the password is also mixed into the ECDH
+key, so the file cannot be decrypted without it even by someone who
+compromised the exchange.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runSend,
 }
@@ -35,15 +42,20 @@ lets both parties confirm no MITM tampered with the exchange.`,
 func init() {
 	sendCmd.Flags().StringVar(&sendTo, "to", "", "Pairing code from the receiver (required)")
 	_ = sendCmd.MarkFlagRequired("to")
-	sendCmd.Flags().StringVar(&sendPassword, "password", "", "Add a password layer on top of the ECDH key")
+	sendCmd.Flags().StringVar(&sendPassword, "password", "", "Require a password before the server releases the file")
+	sendCmd.Flags().BoolVar(&sendSeal, "seal", false, "Also mix the password into the ECDH key (needs --password)")
 	sendCmd.Flags().StringVar(&sendExpires, "expires", "", `Share expiry, e.g. "24h" or "7d"`)
 	sendCmd.Flags().IntVar(&sendMaxDownloads, "max-downloads", 0, "Max download count (0 = unlimited)")
+	sendCmd.Flags().StringVar(&sendAvailableAt, "available-at", "", `Embargo until this time (RFC 3339, or a delay like "2h" or "1d")`)
 	rootCmd.AddCommand(sendCmd)
 }
 
 func runSend(_ *cobra.Command, args []string) error {
 	filePath := args[0]
 	code := strings.ToUpper(strings.TrimSpace(sendTo))
+	if sendSeal && sendPassword == "" {
+		return fmt.Errorf("--seal needs --password")
+	}
 
 	client := newAPIClient()
 
@@ -88,10 +100,15 @@ func runSend(_ *cobra.Command, args []string) error {
 	}
 	filename := filepath.Base(filePath)
 
-	// 7. Encrypt with ECDH-derived key
+	// 7. Encrypt with ECDH-derived key, mixed with the password if sealing
 	fmt.Fprintf(os.Stderr, "Encrypting and uploading: %s (%s)\n",
 		filename, formatSizeCmd(int64(len(plaintext))))
-	blob, err := webcrypto.EncryptWithKey(plaintext, sharedSecret)
+	var blob []byte
+	if sendSeal {
+		blob, err = webcrypto.Seal(plaintext, sharedSecret, sendPassword)
+	} else {
+		blob, err = webcrypto.EncryptWithKey(plaintext, sharedSecret)
+	}
 	if err != nil {
 		return fmt.Errorf("encrypting: %w", err)
 	}
@@ -105,25 +122,36 @@ func runSend(_ *cobra.Command, args []string) error {
 		}
 		expiresAt = t.UTC().Format(time.RFC3339)
 	}
+	availableFrom, err := formatAvailableAt(sendAvailableAt)
+	if err != nil {
+		return err
+	}
 
 	// 9. Upload via API
 	share, err := client.Upload(apiclient.UploadInput{
-		Filename:     filename,
-		FileData:     bytes.NewReader(blob),
-		FileSize:     int64(len(blob)),
-		Password:     sendPassword,
-		ExpiresAt:    expiresAt,
-		MaxDownloads: sendMaxDownloads,
+		Filename:      filename,
+		FileData:      bytes.NewReader(blob),
+		FileSize:      int64(len(blob)),
+		Password:      sendPassword,
+		ExpiresAt:     expiresAt,
+		MaxDownloads:  sendMaxDownloads,
+		AvailableFrom: availableFrom,
+		Raw:           true,
 	})
 	if err != nil {
 		return fmt.Errorf("uploading: %w", err)
 	}
+	warnIfNotEmbargoed(share, availableFrom)
 
 	// 10. Link share to handshake
 	if err := client.SetHandshakeShareID(hs.ID, share.ID); err != nil {
 		return fmt.Errorf("notifying receiver: %w", err)
 	}
 
+	if sendPassword != "" {
+		fmt.Fprintln(os.Stderr, "File sent! Give the receiver the password; they will be asked for it.")
+		return nil
+	}
 	fmt.Fprintln(os.Stderr, "File sent! The receiver will download and decrypt it automatically.")
 	return nil
 }
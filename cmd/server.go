@@ -3,10 +3,13 @@ package cmd
 import (
 	"fmt"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/blob"
 	"github.com/unisoniq/durins-door/internal/server"
 	"github.com/unisoniq/durins-door/internal/share"
 	"github.com/unisoniq/durins-door/internal/tunnel"
@@ -23,28 +26,71 @@ var (
 	flagServerToken   string
 	flagServerTunnel  bool
 	flagServerNoTunnel bool
+	flagServerMaxUpload    string
+	flagServerUploadLimits map[string]string
+	flagServerEventRetention time.Duration
+	flagServerMaxExpiry      time.Duration
+	flagServerTrashRetention time.Duration
+	flagServerPrivate        bool
 )
 
 func init() {
 	serverCmd.Flags().IntVar(&flagServerPort, "port", 8888, "HTTP server port")
-	serverCmd.Flags().StringVar(&flagServerToken, "token", "", "Admin bearer token (auto-generated if empty)")
+	serverCmd.Flags().StringVar(&flagServerToken, "token", "", "Admin bearer token with every scope (auto-generated if empty)")
 	serverCmd.Flags().BoolVar(&flagServerTunnel, "tunnel", true, "Auto-create public tunnel (default: true)")
 	serverCmd.Flags().BoolVar(&flagServerNoTunnel, "no-tunnel", false, "Disable automatic tunnel")
+	serverCmd.Flags().StringVar(&flagServerMaxUpload, "max-upload-size", "50MB", `Default upload size limit, e.g. "500MB" or "2GB" (0 = unlimited)`)
+	serverCmd.Flags().StringToStringVar(&flagServerUploadLimits, "upload-limit", nil, `Per-token upload size limit, by token or API token name, e.g. "ci=10GB" (repeatable)`)
+	serverCmd.Flags().DurationVar(&flagServerMaxExpiry, "max-expiry", 0, "Longest expiry a share may be given on upload or update (0 = no limit)")
+	serverCmd.Flags().DurationVar(&flagServerTrashRetention, "trash-retention", server.DefaultTrashRetention, "How long revoked shares can be restored before they are deleted")
+	serverCmd.Flags().DurationVar(&flagServerEventRetention, "event-retention", server.DefaultEventRetention, "How long to keep the download log (0 = don't log downloads)")
+	serverCmd.Flags().BoolVar(&flagServerPrivate, "private", false, "Require an API token for downloads and handshakes too, not just the share or handshake ID")
+	serverCmd.Flags().StringVar(&flagKEKFile, "kek-file", "", "File containing the key-encryption key (hex or base64)")
+	addBlobStoreFlags(serverCmd)
 	rootCmd.AddCommand(serverCmd)
 }
 
 func runServer(cmd *cobra.Command, args []string) error {
-	st, err := share.NewStore(dataDir())
+	st, err := openStore(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("open store: %w", err)
 	}
 	defer st.Close()
 
+	// Share keys are wrapped under the KEK; refuse to run without it.
+	if err := setupKEK(cmd.Context(), st, true); err != nil {
+		return err
+	}
+	if err := configureBlobStore(st); err != nil {
+		return err
+	}
+	// Record the expiry limit, which "durins-door update" enforces too.
+	maxExpiry := ""
+	if flagServerMaxExpiry > 0 {
+		maxExpiry = flagServerMaxExpiry.String()
+	}
+	if err := st.SetSetting(cmd.Context(), share.MaxExpirySetting, maxExpiry); err != nil {
+		return fmt.Errorf("record --max-expiry: %w", err)
+	}
+
 	adminToken := flagServerToken
 	if adminToken == "" {
 		adminToken = randomID()
 	}
 
+	maxUpload, err := parseSize(flagServerMaxUpload)
+	if err != nil {
+		return fmt.Errorf("parsing --max-upload-size: %w", err)
+	}
+	uploadLimits := make(map[string]int64, len(flagServerUploadLimits))
+	for token, size := range flagServerUploadLimits {
+		n, err := parseSize(size)
+		if err != nil {
+			return fmt.Errorf("parsing --upload-limit for token: %w", err)
+		}
+		uploadLimits[token] = n
+	}
+
 	ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
@@ -53,6 +99,13 @@ func runServer(cmd *cobra.Command, args []string) error {
 		AdminToken: adminToken,
 		Port:       flagServerPort,
 		WebFS:      webFS,
+		Private:    flagServerPrivate,
+
+		MaxUploadSize:     maxUpload,
+		TokenUploadLimits: uploadLimits,
+		MaxExpiry:         flagServerMaxExpiry,
+		TrashRetention:    flagServerTrashRetention,
+		EventRetention:    flagServerEventRetention,
 	})
 
 	// Start server in background
@@ -64,6 +117,7 @@ func runServer(cmd *cobra.Command, args []string) error {
 	printBanner()
 	fmt.Printf("  📡 Local:  http://localhost:%d\n", flagServerPort)
 	fmt.Printf("  🛡  Admin:  http://localhost:%d/admin?token=%s\n", flagServerPort, adminToken)
+	fmt.Printf("  📦 Files:  %s\n", blob.Describe(st.Blobs()))
 
 	// Auto-tunnel
 	useTunnel := flagServerTunnel && !flagServerNoTunnel
@@ -91,3 +145,27 @@ func runServer(cmd *cobra.Command, args []string) error {
 	}
 	return nil
 }
+
+// parseSize parses a human-readable byte size such as "512KB", "500MB" or
+// "2GB" (binary units). A bare number is taken as bytes.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
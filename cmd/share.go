@@ -11,14 +11,17 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/blob"
 	"github.com/unisoniq/durins-door/internal/crypto"
 	"github.com/unisoniq/durins-door/internal/server"
 	"github.com/unisoniq/durins-door/internal/share"
 	"github.com/unisoniq/durins-door/internal/tunnel"
+	"github.com/unisoniq/durins-door/internal/webcrypto"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -30,20 +33,44 @@ var shareCmd = &cobra.Command{
 Examples:
   durins-door share myfile.zip
   durins-door share myfile.zip --expires 24h --max-downloads 3
-  durins-door share secret.pdf --password "mellon" --key "customsecret"`,
+  durins-door share secret.pdf --password "mellon" --key "customsecret"
+  durins-door share secret.pdf --zero-knowledge
+  durins-door share report.pdf --tag clients,q3 --note "for Acme"
+  durins-door share secret.pdf --password "mellon" --seal
+  durins-door share secret.pdf --totp --allow-ip 203.0.113.0/24
+
+With --zero-knowledge the key is never stored: it is printed only as the
+link's #key= fragment and the download page decrypts in the browser.
+
+--password alone is checked by the server before it releases the file.
+--seal also mixes the password into the key of a zero-knowledge share, so
+the link and the stored file together cannot be decrypted without it.
+Sealed links open with "durins-door download" only.
+
+--totp also asks for the current code of an authenticator app holding the
+secret printed here, and --allow-ip only lets the given addresses or CIDR
+ranges download at all.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runShare,
 }
 
 var (
-	flagKey          string
-	flagExpires      time.Duration
-	flagPassword     string
-	flagMaxDownloads int
-	flagPort         int
-	flagTunnel       bool
-	flagNoTunnel     bool
-	flagRegisterOnly bool
+	flagKey           string
+	flagExpires       time.Duration
+	flagPassword      string
+	flagMaxDownloads  int
+	flagPort          int
+	flagTunnel        bool
+	flagNoTunnel      bool
+	flagRegisterOnly  bool
+	flagZeroKnowledge bool
+	flagSeal          bool
+	flagBurn          bool
+	flagAvailableAt   string
+	flagTags          []string
+	flagNote          string
+	flagTOTP          bool
+	flagAllowIPs      []string
 )
 
 func init() {
@@ -51,10 +78,22 @@ func init() {
 	shareCmd.Flags().DurationVar(&flagExpires, "expires", time.Hour, "Expiry duration (e.g. 1h, 24h)")
 	shareCmd.Flags().StringVar(&flagPassword, "password", "", "Require a password to download")
 	shareCmd.Flags().IntVar(&flagMaxDownloads, "max-downloads", 0, "Maximum number of downloads (0 = unlimited)")
+	shareCmd.Flags().BoolVar(&flagBurn, "burn", false, "Burn after reading: delete the file after its first download")
 	shareCmd.Flags().IntVar(&flagPort, "port", 0, "HTTP server port (0 = auto)")
 	shareCmd.Flags().BoolVar(&flagTunnel, "tunnel", true, "Auto-create public tunnel via Cloudflare/ngrok (default: true)")
 	shareCmd.Flags().BoolVar(&flagNoTunnel, "no-tunnel", false, "Disable automatic tunnel")
 	shareCmd.Flags().BoolVar(&flagRegisterOnly, "register-only", false, "Encrypt and register the share but don't start a server")
+	shareCmd.Flags().BoolVar(&flagZeroKnowledge, "zero-knowledge", false, "Keep the key only in the link (#key=…); the browser decrypts")
+	shareCmd.Flags().BoolVar(&flagSeal, "seal", false, "Also encrypt with --password; implies --zero-knowledge")
+	shareCmd.MarkFlagsMutuallyExclusive("burn", "max-downloads")
+	shareCmd.MarkFlagsMutuallyExclusive("seal", "key")
+	shareCmd.Flags().StringVar(&flagAvailableAt, "available-at", "", `Embargo until this time (RFC 3339, or a delay like "2h" or "1d")`)
+	shareCmd.Flags().StringSliceVar(&flagTags, "tag", nil, "Tag the share (repeatable or comma-separated)")
+	shareCmd.Flags().StringVar(&flagNote, "note", "", "Free-text note shown in list and the admin dashboard")
+	shareCmd.Flags().BoolVar(&flagTOTP, "totp", false, "Require a TOTP code from an authenticator app to download")
+	shareCmd.Flags().StringSliceVar(&flagAllowIPs, "allow-ip", nil, "Only allow downloads from this IP or CIDR range (repeatable or comma-separated)")
+	shareCmd.Flags().StringVar(&flagKEKFile, "kek-file", "", "File containing the key-encryption key (hex or base64)")
+	addBlobStoreFlags(shareCmd)
 
 	rootCmd.AddCommand(shareCmd)
 }
@@ -71,12 +110,53 @@ func runShare(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("%s is a directory — please zip it first", filePath)
 	}
 
+	if flagSeal {
+		if flagPassword == "" {
+			return fmt.Errorf("--seal needs --password")
+		}
+		// A key held by the server could decrypt without the password.
+		flagZeroKnowledge = true
+	}
+	if flagZeroKnowledge && flagKey != "" {
+		return fmt.Errorf("--key cannot be used with --zero-knowledge")
+	}
+	var availableFrom time.Time
+	if flagAvailableAt != "" {
+		availableFrom, err = parseAvailableAt(flagAvailableAt)
+		if err != nil {
+			return fmt.Errorf("parsing --available-at: %w", err)
+		}
+		if !availableFrom.Before(time.Now().Add(flagExpires)) {
+			return fmt.Errorf("--available-at must be before the share expires (--expires %s)", flagExpires)
+		}
+	}
+	tags, err := share.NormalizeTags(flagTags)
+	if err != nil {
+		return err
+	}
+	note, err := share.NormalizeNote(flagNote)
+	if err != nil {
+		return err
+	}
+	allowedIPs, err := share.NormalizeAllowedIPs(flagAllowIPs)
+	if err != nil {
+		return err
+	}
+	var totpSecret string
+	if flagTOTP {
+		if totpSecret, err = share.NewTOTPSecret(); err != nil {
+			return fmt.Errorf("creating TOTP secret: %w", err)
+		}
+	}
+
 	// Derive or generate encryption key
 	var key []byte
 	var keyHex string
 	var salt []byte
 	var saltHex string
-	if flagKey != "" {
+	if flagZeroKnowledge {
+		// The key is generated by webcrypto.Encrypt below and never stored.
+	} else if flagKey != "" {
 		key, salt, err = crypto.DeriveKey(flagKey)
 		if err != nil {
 			return fmt.Errorf("derive key: %w", err)
@@ -92,21 +172,48 @@ func runShare(cmd *cobra.Command, args []string) error {
 	}
 
 	// Open the store
-	st, err := share.NewStore(dataDir())
+	st, err := openStore(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("open store: %w", err)
 	}
 	defer st.Close()
+	if (totpSecret != "" || len(allowedIPs) > 0) && !st.SupportsAccessPolicies() {
+		return share.ErrPoliciesUnsupported
+	}
 
-	// Encrypt the file into the data dir
-	shareID := randomID()
-	encPath := filepath.Join(st.DataDir(), "files", shareID+".enc")
-	if err := os.MkdirAll(filepath.Dir(encPath), 0700); err != nil {
-		return fmt.Errorf("create files dir: %w", err)
+	// The share key is wrapped under the KEK. Zero-knowledge shares have no
+	// key to wrap, but the embedded server still needs the KEK (if any) to
+	// serve other shares.
+	if err := setupKEK(cmd.Context(), st, !flagZeroKnowledge); err != nil {
+		return err
+	}
+
+	if err := configureBlobStore(st); err != nil {
+		return err
 	}
 
+	// Encrypt the file into the blob store
+	shareID := randomID()
+	blobKey := blob.Key(shareID)
+
+	// fragment is appended to printed links; it is the only copy of a
+	// zero-knowledge share's key.
+	var fragment string
 	fmt.Printf("🔐 Encrypting %s...\n", filepath.Base(filePath))
-	if err := encryptFile(filePath, encPath, key, salt); err != nil {
+	if flagZeroKnowledge {
+		sealWith := ""
+		if flagSeal {
+			sealWith = flagPassword
+		}
+		keyB64, err := encryptFileWeb(cmd.Context(), st.Blobs(), filePath, blobKey, sealWith)
+		if err != nil {
+			return fmt.Errorf("encrypt: %w", err)
+		}
+		fragment = "#key=" + keyB64
+		if flagSeal {
+			fragment += "&" + webcrypto.SealFragment
+		}
+	} else if err := encryptFile(cmd.Context(), st.Blobs(), filePath, blobKey, key, salt); err != nil {
 		return fmt.Errorf("encrypt: %w", err)
 	}
 
@@ -125,17 +232,27 @@ func runShare(cmd *cobra.Command, args []string) error {
 
 	// Create share record
 	sh := &share.Share{
-		ID:            shareID,
-		Filename:      filepath.Base(filePath),
-		EncryptedPath: encPath,
-		KeyHex:        keyHex,
-		SaltHex:       saltHex,
-		CreatedAt:     time.Now(),
-		ExpiresAt:     time.Now().Add(flagExpires),
-		MaxDownloads:  flagMaxDownloads,
-		PasswordHash:  passwordHash,
-		AdminToken:    adminToken,
-		Size:          fi.Size(),
+		ID:              shareID,
+		Filename:        filepath.Base(filePath),
+		BlobKey:         blobKey,
+		KeyHex:          keyHex,
+		SaltHex:         saltHex,
+		CreatedAt:       time.Now(),
+		ExpiresAt:       time.Now().Add(flagExpires),
+		MaxDownloads:    flagMaxDownloads,
+		PasswordHash:    passwordHash,
+		AdminToken:      adminToken,
+		Size:            fi.Size(),
+		ClientEncrypted: flagZeroKnowledge,
+		Burn:            flagBurn,
+		AvailableFrom:   availableFrom,
+		Tags:            tags,
+		Note:            note,
+		TOTPSecret:      totpSecret,
+		AllowedIPs:      allowedIPs,
+	}
+	if flagBurn {
+		sh.MaxDownloads = 1
 	}
 
 	if err := st.Create(cmd.Context(), sh); err != nil {
@@ -153,7 +270,7 @@ func runShare(cmd *cobra.Command, args []string) error {
 	}
 
 	// Print share info
-	localURL := fmt.Sprintf("http://localhost:%d/d/%s", port, shareID)
+	localURL := fmt.Sprintf("http://localhost:%d/d/%s%s", port, shareID, fragment)
 	localAdmin := fmt.Sprintf("http://localhost:%d/admin?token=%s", port, adminToken)
 	_, _ = localURL, localAdmin
 
@@ -161,15 +278,39 @@ func runShare(cmd *cobra.Command, args []string) error {
 	printBanner()
 	fmt.Printf("  📁 File:        %s (%s)\n", fi.Name(), humanSizeCmd(fi.Size()))
 	fmt.Printf("  ⏱  Expires:     %s\n", flagExpires)
-	if flagMaxDownloads > 0 {
+	if flagBurn {
+		fmt.Printf("  🔥 Burn:        deleted after the first download\n")
+	} else if flagMaxDownloads > 0 {
 		fmt.Printf("  ⬇  Downloads:   max %d\n", flagMaxDownloads)
 	}
-	if flagPassword != "" {
+	if !availableFrom.IsZero() {
+		fmt.Printf("  🌙 Opens:       %s\n", availableFrom.Format(time.RFC822))
+	}
+	if flagSeal {
+		fmt.Printf("  🔑 Password:    set, and sealed into the key\n")
+	} else if flagPassword != "" {
 		fmt.Printf("  🔑 Password:    set\n")
 	}
+	if len(tags) > 0 {
+		fmt.Printf("  🏷  Tags:        %s\n", strings.Join(tags, ", "))
+	}
+	if len(allowedIPs) > 0 {
+		fmt.Printf("  🌐 Allowed from: %s\n", strings.Join(allowedIPs, ", "))
+	}
+	if totpSecret != "" {
+		fmt.Printf("  ⏱  TOTP secret: %s\n", totpSecret)
+		fmt.Printf("     %s\n", share.TOTPURI(totpSecret, sh.Filename))
+		fmt.Println("     Add it to an authenticator app now: downloads need its current code.")
+	}
 	fmt.Println()
-	fmt.Printf("  🔗 Share path:  /d/%s\n", shareID)
-	fmt.Printf("  🛡  Admin token: %s\n", adminToken)
+	fmt.Printf("  🔗 Share path:  /d/%s%s\n", shareID, fragment)
+	if flagRegisterOnly {
+		// The running server has its own admin token; this one only
+		// manages this share.
+		fmt.Printf("  🛡  Owner token: %s\n", adminToken)
+	} else {
+		fmt.Printf("  🛡  Admin token: %s\n", adminToken)
+	}
 	fmt.Println()
 
 	if flagRegisterOnly {
@@ -188,6 +329,10 @@ func runShare(cmd *cobra.Command, args []string) error {
 			AdminToken: adminToken,
 			Port:       port,
 			WebFS:      webFS,
+
+			MaxUploadSize:  server.DefaultMaxUploadSize,
+			TrashRetention: server.DefaultTrashRetention,
+			EventRetention: server.DefaultEventRetention,
 		})
 		go srv.Start(ctx)
 		time.Sleep(500 * time.Millisecond) // let server bind
@@ -197,7 +342,7 @@ func runShare(cmd *cobra.Command, args []string) error {
 			fmt.Printf("  ⚠  Tunnel failed: %v\n", err)
 			fmt.Printf("  📡 Falling back to local: %s\n", localURL)
 		} else {
-			publicURL := fmt.Sprintf("%s/d/%s", tun.PublicURL, shareID)
+			publicURL := fmt.Sprintf("%s/d/%s%s", tun.PublicURL, shareID, fragment)
 			publicAdmin := fmt.Sprintf("%s/admin?token=%s", tun.PublicURL, adminToken)
 			fmt.Println()
 			fmt.Printf("  🌍 Public URL:  %s\n", publicURL)
@@ -216,7 +361,7 @@ func runShare(cmd *cobra.Command, args []string) error {
 		if tun != nil {
 			tun.Stop()
 		}
-		if err := st.Revoke(context.Background(), shareID); err != nil {
+		if err := st.Delete(context.Background(), shareID); err != nil {
 			log.Printf("cleanup warning: %v", err)
 		}
 		fmt.Println("✅ Share revoked and encrypted file deleted.")
@@ -238,6 +383,10 @@ func runShare(cmd *cobra.Command, args []string) error {
 		AdminToken: adminToken,
 		Port:       port,
 		WebFS:      webFS,
+
+		MaxUploadSize:  server.DefaultMaxUploadSize,
+		TrashRetention: server.DefaultTrashRetention,
+		EventRetention: server.DefaultEventRetention,
 	})
 
 	if err := srv.Start(ctx); err != nil {
@@ -246,38 +395,71 @@ func runShare(cmd *cobra.Command, args []string) error {
 
 	// Cleanup on exit
 	fmt.Println("\n✨ Server stopped. Cleaning up...")
-	if err := st.Revoke(context.Background(), shareID); err != nil {
+	if err := st.Delete(context.Background(), shareID); err != nil {
 		log.Printf("cleanup warning: %v", err)
 	}
 	fmt.Println("✅ Share revoked and encrypted file deleted.")
 	return nil
 }
 
-// encryptFile encrypts src into dst using key. If salt is non-nil (passphrase-
-// derived key), the 16-byte salt is written as a header before the ciphertext
-// so that it can be recovered for future key re-derivation.
-func encryptFile(src, dst string, key, salt []byte) error {
+// encryptFile encrypts src into the blob dst using key. If salt is non-nil
+// (passphrase-derived key), the 16-byte salt is written as a header before
+// the ciphertext so that it can be recovered for future key re-derivation.
+func encryptFile(ctx context.Context, blobs blob.Store, src, dst string, key, salt []byte) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("open source: %w", err)
 	}
 	defer in.Close()
 
-	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	defer func() {
+		pr.Close()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		// Prepend the Argon2id salt so a recipient with the passphrase can
+		// re-derive the key without needing out-of-band salt storage.
+		if len(salt) > 0 {
+			if _, err := pw.Write(salt); err != nil {
+				pw.CloseWithError(fmt.Errorf("write salt header: %w", err))
+				return
+			}
+		}
+		pw.CloseWithError(crypto.EncryptStream(pw, in, key))
+	}()
+
+	return blobs.Put(ctx, dst, pr, -1)
+}
+
+// encryptFileWeb encrypts src into the blob dst in a format the download
+// page decrypts in the browser, and returns the base64url key. A non-empty
+// sealWith seals the blob with that password (webcrypto.Seal), which holds
+// the whole file in memory and is limited to maxSealSize.
+func encryptFileWeb(ctx context.Context, blobs blob.Store, src, dst, sealWith string) (string, error) {
+	in, err := os.Open(src)
 	if err != nil {
-		return fmt.Errorf("open dest: %w", err)
+		return "", fmt.Errorf("open source: %w", err)
 	}
-	defer out.Close()
-
-	// Prepend the Argon2id salt so a recipient with the passphrase can
-	// re-derive the key without needing out-of-band salt storage.
-	if len(salt) > 0 {
-		if _, err := out.Write(salt); err != nil {
-			return fmt.Errorf("write salt header: %w", err)
-		}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat source: %w", err)
 	}
-
-	return crypto.EncryptStream(out, in, key)
+	if sealWith != "" && fi.Size() > maxSealSize {
+		return "", fmt.Errorf("--seal encrypts the whole file in memory and is limited to %s; %s is %s",
+			humanSizeCmd(maxSealSize), filepath.Base(src), humanSizeCmd(fi.Size()))
+	}
+	res, err := encryptUpload(in, fi.Size(), sealWith, sealWith != "")
+	if err != nil {
+		return "", err
+	}
+	if err := blobs.Put(ctx, dst, res.Data, res.Size); err != nil {
+		return "", fmt.Errorf("write dest: %w", err)
+	}
+	return res.KeyB64, nil
 }
 
 func randomID() string {
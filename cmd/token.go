@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Create, list or revoke scoped API tokens",
+	Long: `API tokens let clients use the server without its admin token. Each has a
+name, one or more scopes and an optional expiry:
+
+  upload     create shares (/api/upload, /api/uploads)
+  download   read share metadata and fetch files
+  handshake  run key exchanges (durins-door send/receive)
+  list       list shares and read their history
+  admin      everything above, plus the dashboard, updates and revocation
+
+Only a hash of each token is stored; the token is printed once, when it is
+created. Changes take effect immediately, even on a running server.`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create an API token and print it",
+	Long: `Creates a token with the given scopes and prints it. Store it somewhere safe:
+it cannot be shown again.
+
+Examples:
+  durins-door admin token create ci --scope upload --expires 90d
+  durins-door admin token create dashboard --scope admin`,
+	Args: cobra.ExactArgs(1),
+	RunE: runTokenCreate,
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Args:  cobra.NoArgs,
+	RunE:  runTokenList,
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <name|id>",
+	Short: "Delete an API token",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTokenRevoke,
+}
+
+var (
+	flagTokenScopes  []string
+	flagTokenExpires string
+)
+
+func init() {
+	tokenCreateCmd.Flags().StringSliceVar(&flagTokenScopes, "scope", nil, "Scope to grant: upload, download, handshake, list or admin (repeatable or comma-separated)")
+	tokenCreateCmd.Flags().StringVar(&flagTokenExpires, "expires", "", `Lifetime, e.g. "24h" or "90d" (default: never expires)`)
+	tokenCreateCmd.MarkFlagRequired("scope")
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenRevokeCmd)
+	adminCmd.AddCommand(tokenCmd)
+}
+
+func runTokenCreate(cmd *cobra.Command, args []string) error {
+	var expiresAt time.Time
+	if flagTokenExpires != "" {
+		var err error
+		expiresAt, err = parseExpiry(flagTokenExpires)
+		if err != nil {
+			return fmt.Errorf("invalid --expires: %w", err)
+		}
+	}
+
+	st, err := openStore(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+
+	t, token, err := st.CreateAPIToken(cmd.Context(), args[0], flagTokenScopes, expiresAt)
+	if err != nil {
+		return fmt.Errorf("create token: %w", err)
+	}
+	fmt.Printf("✅ Created token %s (%s) with scopes: %s\n", t.Name, t.ID, strings.Join(t.Scopes, ", "))
+	if !t.ExpiresAt.IsZero() {
+		fmt.Printf("   Expires: %s\n", t.ExpiresAt.Format(time.RFC822))
+	}
+	fmt.Println()
+	fmt.Printf("   %s\n", token)
+	fmt.Println()
+	fmt.Println("   This is the only time the token is shown. Use it with --api-token or")
+	fmt.Println("   DURINS_DOOR_TOKEN, or as an Authorization: Bearer header.")
+	return nil
+}
+
+func runTokenList(cmd *cobra.Command, args []string) error {
+	st, err := openStore(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+
+	tokens, err := st.APITokens(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("list tokens: %w", err)
+	}
+	if len(tokens) == 0 {
+		fmt.Println("No API tokens. Create one with: durins-door admin token create <name> --scope ...")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES\tLAST USED")
+	for _, t := range tokens {
+		expires := "never"
+		if !t.ExpiresAt.IsZero() {
+			expires = t.ExpiresAt.Format(time.RFC822)
+			if t.IsExpired() {
+				expires += " (expired)"
+			}
+		}
+		lastUsed := "never"
+		if !t.LastUsedAt.IsZero() {
+			lastUsed = t.LastUsedAt.Format(time.RFC822)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.ID, t.Name, strings.Join(t.Scopes, ","),
+			t.CreatedAt.Format(time.RFC822), expires, lastUsed)
+	}
+	return w.Flush()
+}
+
+func runTokenRevoke(cmd *cobra.Command, args []string) error {
+	st, err := openStore(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+
+	t, err := st.RevokeAPIToken(cmd.Context(), args[0])
+	if errors.Is(err, share.ErrTokenNotFound) {
+		return fmt.Errorf("no token named or with ID %q", args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+	fmt.Printf("✅ Revoked token %s (%s).\n", t.Name, t.ID)
+	return nil
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List, restore or permanently delete revoked shares",
+	Long: `Revoked shares are kept in the trash: their links no longer work, but the
+share and its encrypted file can be restored. The server deletes them for
+good once they have been in the trash longer than --trash-retention.`,
+}
+
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the shares in the trash",
+	Args:  cobra.NoArgs,
+	RunE:  runTrashList,
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore <share-id>",
+	Short: "Take a revoked share out of the trash",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTrashRestore,
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty [share-id]",
+	Short: "Permanently delete shares in the trash and their files",
+	Long: `Deletes every share in the trash, or only the one given, together with its
+encrypted file. This cannot be undone. With --older-than only shares revoked
+at least that long ago are deleted.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runTrashEmpty,
+}
+
+var flagTrashOlderThan time.Duration
+
+func init() {
+	trashEmptyCmd.Flags().DurationVar(&flagTrashOlderThan, "older-than", 0, "Only delete shares revoked at least this long ago")
+	addBlobStoreFlags(trashEmptyCmd)
+	trashCmd.AddCommand(trashListCmd, trashRestoreCmd, trashEmptyCmd)
+	rootCmd.AddCommand(trashCmd)
+}
+
+func runTrashList(cmd *cobra.Command, args []string) error {
+	st, err := openStore(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+
+	shares, err := st.Trash(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("list trash: %w", err)
+	}
+	if len(shares) == 0 {
+		fmt.Println("The trash is empty.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tFILE\tSIZE\tREVOKED\tEXPIRES")
+	for _, sh := range shares {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			sh.ID[:16],
+			sh.Filename,
+			humanSizeCmd(sh.Size),
+			sh.DeletedAt.Format(time.RFC822),
+			expiryString(sh.ExpiresAt),
+		)
+	}
+	return w.Flush()
+}
+
+func runTrashRestore(cmd *cobra.Command, args []string) error {
+	st, err := openStore(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+
+	id, err := resolveTrashedID(cmd, st, args[0])
+	if err != nil {
+		return err
+	}
+	sh, err := st.Restore(cmd.Context(), id)
+	if err != nil {
+		if errors.Is(err, share.ErrNotFound) {
+			return fmt.Errorf("share %q is not in the trash", id)
+		}
+		return err
+	}
+	fmt.Printf("✅ Restored %s (%s).\n", sh.Filename, sh.ID[:16])
+	if sh.IsExpired() {
+		fmt.Printf("⚠  It expired on %s and will be purged unless you extend it:\n", sh.ExpiresAt.Format(time.RFC822))
+		fmt.Printf("   durins-door update %s --expires 24h\n", sh.ID)
+	}
+	return nil
+}
+
+func runTrashEmpty(cmd *cobra.Command, args []string) error {
+	st, err := openStore(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+	if err := configureBlobStore(st); err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		id, err := resolveTrashedID(cmd, st, args[0])
+		if err != nil {
+			return err
+		}
+		if err := st.Delete(cmd.Context(), id); err != nil {
+			return fmt.Errorf("delete %s: %w", id, err)
+		}
+		fmt.Println("✅ Share and encrypted file deleted.")
+		return nil
+	}
+
+	n, err := st.EmptyTrash(cmd.Context(), flagTrashOlderThan)
+	if err != nil {
+		return fmt.Errorf("empty trash: %w", err)
+	}
+	fmt.Printf("✅ Deleted %d share(s) from the trash.\n", n)
+	return nil
+}
+
+// resolveTrashedID expands an ID prefix against the shares in the trash.
+func resolveTrashedID(cmd *cobra.Command, st *share.Store, id string) (string, error) {
+	shares, err := st.Trash(cmd.Context())
+	if err != nil {
+		return "", err
+	}
+	id, err = matchShareID(shares, id)
+	if err != nil {
+		return "", err
+	}
+	for _, sh := range shares {
+		if sh.ID == id {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("share %q is not in the trash", id)
+}
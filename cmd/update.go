@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/unisoniq/durins-door/internal/share"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var updateCmd = &cobra.Command{
+	Use:   "update <share-id>",
+	Short: "Change a share's expiry, download limit, password, tags, note or access policies",
+	Long: `Changes the settings of an existing share. Only the flags you pass are
+changed, and every change is recorded in the share's history.
+
+--expires counts from now, so it can both extend and shorten a share's life,
+and also revives a share that has expired but not been purged yet. A burn
+share keeps its single download, and a limit cannot be set at or below the
+number of downloads already made.
+
+--tag replaces the share's tags (--tag "" removes them all), and --note ""
+removes the note.
+
+--totp gives the share a new TOTP secret, printed once, whose codes every
+download then needs; --no-totp removes it. --allow-ip replaces the share's
+IP allowlist and --no-allow-ip lets it be downloaded from anywhere again.
+
+This edits the database directly. --expires is held to the --max-expiry of
+the server last started on it, as PATCH /api/shares/{id} is, the way
+remote clients change shares.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runUpdate,
+}
+
+var (
+	flagUpdateExpires      string
+	flagUpdateMaxDownloads int
+	flagUpdatePassword     string
+	flagUpdateNoPassword   bool
+	flagUpdateTags         []string
+	flagUpdateNote         string
+	flagUpdateTOTP         bool
+	flagUpdateNoTOTP       bool
+	flagUpdateAllowIPs     []string
+	flagUpdateNoAllowIPs   bool
+)
+
+func init() {
+	updateCmd.Flags().StringVar(&flagUpdateExpires, "expires", "", `New expiry, counted from now, e.g. "24h" or "7d"`)
+	updateCmd.Flags().IntVar(&flagUpdateMaxDownloads, "max-downloads", 0, "New maximum number of downloads (0 = unlimited)")
+	updateCmd.Flags().StringVar(&flagUpdatePassword, "password", "", "Set or replace the download password")
+	updateCmd.Flags().BoolVar(&flagUpdateNoPassword, "no-password", false, "Remove the download password")
+	updateCmd.Flags().StringSliceVar(&flagUpdateTags, "tag", nil, "Replace the share's tags (repeatable or comma-separated)")
+	updateCmd.Flags().StringVar(&flagUpdateNote, "note", "", "Set or replace the note")
+	updateCmd.Flags().BoolVar(&flagUpdateTOTP, "totp", false, "Set or replace the TOTP secret downloads need a code from")
+	updateCmd.Flags().BoolVar(&flagUpdateNoTOTP, "no-totp", false, "Remove the TOTP secret")
+	updateCmd.Flags().StringSliceVar(&flagUpdateAllowIPs, "allow-ip", nil, "Replace the IP allowlist (repeatable or comma-separated)")
+	updateCmd.Flags().BoolVar(&flagUpdateNoAllowIPs, "no-allow-ip", false, "Remove the IP allowlist")
+	updateCmd.MarkFlagsMutuallyExclusive("password", "no-password")
+	updateCmd.MarkFlagsMutuallyExclusive("totp", "no-totp")
+	updateCmd.MarkFlagsMutuallyExclusive("allow-ip", "no-allow-ip")
+	rootCmd.AddCommand(updateCmd)
+}
+
+func runUpdate(cmd *cobra.Command, args []string) error {
+	var u share.ShareUpdate
+	if flagUpdateExpires != "" {
+		t, err := parseExpiry(flagUpdateExpires)
+		if err != nil {
+			return fmt.Errorf("parsing --expires: %w", err)
+		}
+		u.ExpiresAt = &t
+	}
+	if cmd.Flags().Changed("max-downloads") {
+		u.MaxDownloads = &flagUpdateMaxDownloads
+	}
+	switch {
+	case flagUpdateNoPassword:
+		hash := ""
+		u.PasswordHash = &hash
+	case cmd.Flags().Changed("password"):
+		if flagUpdatePassword == "" {
+			return errors.New("--password cannot be empty; use --no-password to remove it")
+		}
+		b, err := bcrypt.GenerateFromPassword([]byte(flagUpdatePassword), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("hash password: %w", err)
+		}
+		hash := string(b)
+		u.PasswordHash = &hash
+	}
+	if cmd.Flags().Changed("tag") {
+		u.Tags = &flagUpdateTags
+	}
+	if cmd.Flags().Changed("note") {
+		u.Note = &flagUpdateNote
+	}
+	switch {
+	case flagUpdateNoTOTP:
+		secret := ""
+		u.TOTPSecret = &secret
+	case flagUpdateTOTP:
+		secret, err := share.NewTOTPSecret()
+		if err != nil {
+			return fmt.Errorf("creating TOTP secret: %w", err)
+		}
+		u.TOTPSecret = &secret
+	}
+	switch {
+	case flagUpdateNoAllowIPs:
+		u.AllowedIPs = &[]string{}
+	case cmd.Flags().Changed("allow-ip"):
+		if len(flagUpdateAllowIPs) == 0 {
+			return errors.New("--allow-ip cannot be empty; use --no-allow-ip to remove the allowlist")
+		}
+		u.AllowedIPs = &flagUpdateAllowIPs
+	}
+	if u.IsEmpty() {
+		return errors.New("nothing to change; pass --expires, --max-downloads, --password, --no-password, --tag, --note, --totp, --no-totp, --allow-ip or --no-allow-ip")
+	}
+
+	st, err := openStore(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("open store: %w", err)
+	}
+	defer st.Close()
+
+	id, err := resolveShareID(cmd.Context(), st, args[0])
+	if err != nil {
+		return err
+	}
+	if u.ExpiresAt != nil {
+		if err := checkMaxExpiry(cmd.Context(), st, *u.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	sh, err := st.Update(cmd.Context(), id, u, "cli")
+	if err != nil {
+		if errors.Is(err, share.ErrNotFound) {
+			return fmt.Errorf("share %q not found", id)
+		}
+		return err
+	}
+
+	fmt.Printf("✅ Updated %s (%s)\n", sh.Filename, sh.ID[:16])
+	fmt.Printf("  Expires:   %s\n", expiryString(sh.ExpiresAt))
+	if sh.MaxDownloads > 0 {
+		fmt.Printf("  Downloads: %d / %d\n", sh.Downloads, sh.MaxDownloads)
+	} else {
+		fmt.Printf("  Downloads: %d (unlimited)\n", sh.Downloads)
+	}
+	if sh.PasswordHash != "" {
+		fmt.Println("  Password:  set")
+	} else {
+		fmt.Println("  Password:  none")
+	}
+	if len(sh.Tags) > 0 {
+		fmt.Printf("  Tags:      %s\n", strings.Join(sh.Tags, ", "))
+	}
+	if sh.Note != "" {
+		fmt.Printf("  Note:      %s\n", sh.Note)
+	}
+	if len(sh.AllowedIPs) > 0 {
+		fmt.Printf("  Allowed:   %s\n", strings.Join(sh.AllowedIPs, ", "))
+	}
+	switch {
+	case flagUpdateTOTP:
+		printTOTPSecret(sh.TOTPSecret, sh.Filename)
+	case sh.HasTOTP():
+		fmt.Println("  TOTP:      required")
+	}
+	return nil
+}
+
+// checkMaxExpiry holds a new expiry to the --max-expiry the server recorded
+// in the store, the limit PATCH /api/shares/{id} applies.
+func checkMaxExpiry(ctx context.Context, st *share.Store, t time.Time) error {
+	v, err := st.Setting(ctx, share.MaxExpirySetting)
+	if err != nil {
+		return fmt.Errorf("read --max-expiry: %w", err)
+	}
+	if v == "" {
+		return nil
+	}
+	limit, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid stored %s %q: %w", share.MaxExpirySetting, v, err)
+	}
+	return share.CheckMaxExpiry(t, limit)
+}
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,27 +11,58 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/unisoniq/durins-door/internal/apiclient"
+	"github.com/unisoniq/durins-door/internal/crypto"
+	"github.com/unisoniq/durins-door/internal/share"
+	"github.com/unisoniq/durins-door/internal/webcrypto"
 )
 
 var (
 	uploadPassword     string
+	uploadSeal         bool
 	uploadExpires      string
 	uploadMaxDownloads int
+	uploadBurn         bool
+	uploadAvailableAt  string
+	uploadTags         []string
+	uploadNote         string
+	uploadTOTP         bool
+	uploadAllowIPs     []string
 )
 
 var uploadCmd = &cobra.Command{
 	Use:   "upload <file>",
-	Short: "Upload a file to a remote Durin's Door server",
-	Long: `Uploads a file to a running Durin's Door server. The server encrypts and
-stores the file. Requires --server-url and --token to be set.`,
+	Short: "Encrypt a file locally and upload it to a Durin's Door server",
+	Long: `Encrypts a file on this machine with a fresh AES-256-GCM key and uploads
+only the ciphertext. The key is placed in the URL fragment (#key=…), which
+is never sent to the server, so the link works with "durins-door download"
+and in the browser.
+
+--password alone makes the server ask for the password before it hands
+out the file. With --seal the password is also mixed into the encryption
+key, so the link and the stored file together still cannot be decrypted
+without it. Sealed links open with "durins-door download" only, and since
+sealing encrypts the file in memory it is limited to 256 MB; other uploads
+are encrypted as they stream.
+
+--totp makes downloads also need the current code from an authenticator
+app; the secret to add to the app is printed once. --allow-ip limits
+downloads to the given addresses or CIDR ranges.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runUpload,
 }
 
 func init() {
 	uploadCmd.Flags().StringVar(&uploadPassword, "password", "", "Password-protect the share")
+	uploadCmd.Flags().BoolVar(&uploadSeal, "seal", false, "Also encrypt with the password, not just gate downloads on it (needs --password; files up to 256 MB)")
 	uploadCmd.Flags().StringVar(&uploadExpires, "expires", "", `Expiry duration, e.g. "24h" or "7d"`)
 	uploadCmd.Flags().IntVar(&uploadMaxDownloads, "max-downloads", 0, "Maximum number of downloads (0 = unlimited)")
+	uploadCmd.Flags().BoolVar(&uploadBurn, "burn", false, "Burn after reading: delete the file after its first download")
+	uploadCmd.MarkFlagsMutuallyExclusive("burn", "max-downloads")
+	uploadCmd.Flags().StringVar(&uploadAvailableAt, "available-at", "", `Embargo until this time (RFC 3339, or a delay like "2h" or "1d")`)
+	uploadCmd.Flags().StringSliceVar(&uploadTags, "tag", nil, "Tag the share (repeatable or comma-separated)")
+	uploadCmd.Flags().StringVar(&uploadNote, "note", "", "Free-text note shown in list and the admin dashboard")
+	uploadCmd.Flags().BoolVar(&uploadTOTP, "totp", false, "Require a TOTP code from an authenticator app to download")
+	uploadCmd.Flags().StringSliceVar(&uploadAllowIPs, "allow-ip", nil, "Only allow downloads from this IP or CIDR range (repeatable or comma-separated)")
 	rootCmd.AddCommand(uploadCmd)
 }
 
@@ -44,12 +77,24 @@ func runUpload(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("%s is a directory — please zip it first", filePath)
 	}
 
+	if uploadSeal && uploadPassword == "" {
+		return fmt.Errorf("--seal needs --password")
+	}
+	if uploadSeal && fi.Size() > maxSealSize {
+		return fmt.Errorf("--seal encrypts the whole file in memory and is limited to %s; %s is %s",
+			humanSizeCmd(maxSealSize), filepath.Base(filePath), humanSizeCmd(fi.Size()))
+	}
+
 	f, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("opening file: %w", err)
+		return fmt.Errorf("cannot open file: %w", err)
 	}
 	defer f.Close()
 
+	if uploadBurn {
+		uploadMaxDownloads = 1
+	}
+
 	// Parse expiry
 	var expiresAt string
 	if uploadExpires != "" {
@@ -59,18 +104,35 @@ func runUpload(cmd *cobra.Command, args []string) error {
 		}
 		expiresAt = t.UTC().Format(time.RFC3339)
 	}
+	availableFrom, err := formatAvailableAt(uploadAvailableAt)
+	if err != nil {
+		return err
+	}
 
 	client := newAPIClient()
 
-	fmt.Fprintf(os.Stderr, "Uploading %s (%s)...\n", filepath.Base(filePath), humanSizeCmd(fi.Size()))
+	// Encrypt client-side as the file is read; the server only ever sees
+	// ciphertext.
+	enc, err := encryptUpload(f, fi.Size(), uploadPassword, uploadSeal)
+	if err != nil {
+		return fmt.Errorf("encrypting: %w", err)
+	}
 
+	fmt.Fprintf(os.Stderr, "Encrypting and uploading %s (%s)...\n", filepath.Base(filePath), humanSizeCmd(fi.Size()))
 	share, err := client.Upload(apiclient.UploadInput{
-		Filename:     filepath.Base(filePath),
-		FileData:     f,
-		FileSize:     fi.Size(),
-		Password:     uploadPassword,
-		ExpiresAt:    expiresAt,
-		MaxDownloads: uploadMaxDownloads,
+		Filename:      filepath.Base(filePath),
+		FileData:      enc.Data,
+		FileSize:      enc.Size,
+		Password:      uploadPassword,
+		ExpiresAt:     expiresAt,
+		MaxDownloads:  uploadMaxDownloads,
+		Burn:          uploadBurn,
+		AvailableFrom: availableFrom,
+		Tags:          uploadTags,
+		Note:          uploadNote,
+		TOTP:          uploadTOTP,
+		AllowedIPs:    uploadAllowIPs,
+		Raw:           true,
 	})
 	if err != nil {
 		return fmt.Errorf("uploading: %w", err)
@@ -79,24 +141,143 @@ func runUpload(cmd *cobra.Command, args []string) error {
 	fmt.Fprintln(os.Stderr)
 	fmt.Fprintln(os.Stderr, "Share created!")
 	fmt.Fprintf(os.Stderr, "  ID:   %s\n", share.ID)
-	fmt.Fprintf(os.Stderr, "  File: %s (%s)\n", share.Filename, formatSizeCmd(share.FileSize))
-	if share.MaxDownloads != nil {
+	fmt.Fprintf(os.Stderr, "  File: %s (%s)\n", share.Filename, formatSizeCmd(fi.Size()))
+	if share.Burn {
+		fmt.Fprintln(os.Stderr, "  Burn after reading: yes")
+	} else if share.MaxDownloads != nil {
 		fmt.Fprintf(os.Stderr, "  Max downloads: %d\n", *share.MaxDownloads)
 	}
+	if share.AvailableFrom != nil {
+		fmt.Fprintf(os.Stderr, "  Available from: %s\n", share.AvailableFrom.Format(time.RFC3339))
+	}
 	if share.ExpiresAt != nil {
 		fmt.Fprintf(os.Stderr, "  Expires: %s\n", share.ExpiresAt.Format(time.RFC3339))
 	}
-	if uploadPassword != "" {
+	if len(share.Tags) > 0 {
+		fmt.Fprintf(os.Stderr, "  Tags: %s\n", strings.Join(share.Tags, ", "))
+	}
+	warnIfNotEmbargoed(share, availableFrom)
+	printAccessPolicies(share, uploadTOTP, uploadAllowIPs)
+	if uploadSeal {
+		fmt.Fprintln(os.Stderr, "  Password-protected: yes, and sealed (the link alone cannot decrypt)")
+	} else if uploadPassword != "" {
 		fmt.Fprintln(os.Stderr, "  Password-protected: yes")
 	}
+	if share.OwnerToken != "" {
+		fmt.Fprintf(os.Stderr, "  Owner token: %s\n", share.OwnerToken)
+		fmt.Fprintf(os.Stderr, "    (lets you view, change and revoke this share through /api/shares/%s)\n", share.ID)
+	}
 
-	// Print the download URL
+	// Print the download URL; the key lives only in the fragment.
 	serverURL := strings.TrimRight(flagServerURL, "/")
-	fmt.Printf("%s/d/%s\n", serverURL, share.ID)
+	fragment := "key=" + enc.KeyB64
+	if uploadSeal {
+		fragment += "&" + webcrypto.SealFragment
+	}
+	fmt.Printf("%s/d/%s#%s\n", serverURL, share.ID, fragment)
 
 	return nil
 }
 
+// maxSealSize caps files uploaded with --seal, which webcrypto.Seal
+// encrypts in one block in memory.
+const maxSealSize = 256 << 20 // 256 MB
+
+// encryptedUpload is a file encrypted for upload, with the key for the
+// link's fragment.
+type encryptedUpload struct {
+	Data   io.ReadSeeker
+	Size   int64
+	KeyB64 string
+}
+
+// encryptUpload encrypts the size bytes of f under a fresh key. The file is
+// streamed (webcrypto.NewStreamReader), so it is read and encrypted as the
+// upload goes and may be any size. With seal, the key is mixed with password
+// (webcrypto.Seal), which needs the whole file in memory; the caller holds
+// it to maxSealSize.
+func encryptUpload(f io.ReadSeeker, size int64, password string, seal bool) (*encryptedUpload, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if !seal {
+		r, err := webcrypto.NewStreamReader(f, size, key)
+		if err != nil {
+			return nil, err
+		}
+		return &encryptedUpload{Data: r, Size: r.Size(), KeyB64: webcrypto.EncodeKey(key)}, nil
+	}
+	plaintext := make([]byte, size)
+	if _, err := io.ReadFull(f, plaintext); err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	blob, err := webcrypto.Seal(plaintext, key, password)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedUpload{Data: bytes.NewReader(blob), Size: int64(len(blob)), KeyB64: webcrypto.EncodeKey(key)}, nil
+}
+
+// parseAvailableAt reads an --available-at value: an RFC 3339 time, or a
+// delay from now in parseExpiry's format.
+func parseAvailableAt(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(s)); err == nil {
+		return t, nil
+	}
+	t, err := parseExpiry(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want an RFC 3339 time or a delay like \"2h\": %w", err)
+	}
+	return t, nil
+}
+
+// formatAvailableAt turns an --available-at flag into the API's RFC 3339
+// form; "" stays "".
+func formatAvailableAt(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	t, err := parseAvailableAt(s)
+	if err != nil {
+		return "", fmt.Errorf("parsing --available-at: %w", err)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// warnIfNotEmbargoed tells the user when the server ignored --available-at
+// (the hosted web app does not support it), since the link then works
+// straight away.
+func warnIfNotEmbargoed(sh *apiclient.Share, availableFrom string) {
+	if availableFrom != "" && sh.AvailableFrom == nil {
+		fmt.Fprintln(os.Stderr, "⚠  This server does not support --available-at; the link works immediately.")
+	}
+}
+
+// printAccessPolicies reports a new share's TOTP secret and IP allowlist,
+// and warns when the server ignored --totp or --allow-ip (the hosted web
+// app supports neither), since the link then works without them.
+func printAccessPolicies(sh *apiclient.Share, totp bool, allowIPs []string) {
+	if len(sh.AllowedIPs) > 0 {
+		fmt.Fprintf(os.Stderr, "  Allowed from: %s\n", strings.Join(sh.AllowedIPs, ", "))
+	} else if len(allowIPs) > 0 {
+		fmt.Fprintln(os.Stderr, "⚠  This server does not support --allow-ip; the link works from anywhere.")
+	}
+	if sh.TOTPSecret != "" {
+		printTOTPSecret(sh.TOTPSecret, sh.Filename)
+	} else if totp {
+		fmt.Fprintln(os.Stderr, "⚠  This server does not support --totp; the link works without a code.")
+	}
+}
+
+// printTOTPSecret prints a share's new TOTP secret and its otpauth:// URI,
+// which authenticator apps import. Neither can be shown again.
+func printTOTPSecret(secret, filename string) {
+	fmt.Fprintf(os.Stderr, "  TOTP secret: %s\n", secret)
+	fmt.Fprintf(os.Stderr, "    %s\n", share.TOTPURI(secret, filename))
+	fmt.Fprintln(os.Stderr, "    (add it to an authenticator app now: downloads need its current code)")
+}
+
 // parseExpiry handles "24h", "7d", etc.
 func parseExpiry(s string) (time.Time, error) {
 	s = strings.TrimSpace(s)
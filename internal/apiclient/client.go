@@ -5,20 +5,29 @@ package apiclient
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // Client is an HTTP client for the Durin's Door server API.
+//
+// Recipient calls (GetShare, DownloadFile, IncrementDownloads,
+// CreateHandshake, GetHandshake and the Poll methods) need only the share
+// or handshake ID and work without a token; everything else needs one.
 type Client struct {
-	BaseURL    string
+	BaseURL string
+	// AdminToken is sent as a bearer token when set: the server's admin
+	// token or a scoped API token.
 	AdminToken string
 	http       *http.Client
+	transfer   *http.Client // no overall timeout, for large uploads/downloads
 }
 
 // New creates a Client.
@@ -27,6 +36,7 @@ func New(baseURL, adminToken string) *Client {
 		BaseURL:    strings.TrimRight(baseURL, "/"),
 		AdminToken: adminToken,
 		http:       &http.Client{Timeout: 120 * time.Second},
+		transfer:   &http.Client{},
 	}
 }
 
@@ -34,10 +44,12 @@ func New(baseURL, adminToken string) *Client {
 
 // Share represents a share returned by the API.
 type Share struct {
-	ID                string     `json:"id"`
-	Filename          string     `json:"filename"`
-	FileSize          int64      `json:"file_size"`
-	MimeType          string     `json:"mime_type,omitempty"`
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	FileSize int64  `json:"file_size"`
+	MimeType string `json:"mime_type,omitempty"`
+	// PasswordHash is sent only by the web app's API, which has no verify
+	// endpoint; the Go server keeps it to itself.
 	PasswordHash      *string    `json:"password_hash,omitempty"`
 	MaxDownloads      *int       `json:"max_downloads,omitempty"`
 	Downloads         int        `json:"downloads"`
@@ -45,6 +57,18 @@ type Share struct {
 	CreatedAt         time.Time  `json:"created_at"`
 	StoragePath       string     `json:"storage_path,omitempty"`
 	PasswordProtected bool       `json:"password_protected"`
+	ClientEncrypted   bool       `json:"client_encrypted,omitempty"`
+	Burn              bool       `json:"burn,omitempty"`
+	AvailableFrom     *time.Time `json:"available_from,omitempty"`
+	Tags              []string   `json:"tags,omitempty"`
+	Note              string     `json:"note,omitempty"`
+	TOTPRequired      bool       `json:"totp_required,omitempty"`
+	AllowedIPs        []string   `json:"allowed_ips,omitempty"`
+	// OwnerToken lets its holder view, update and revoke this share. The
+	// server returns it only from Upload.
+	OwnerToken string `json:"owner_token,omitempty"`
+	// TOTPSecret is returned only from an Upload that asked for TOTP.
+	TOTPSecret string `json:"totp_secret,omitempty"`
 }
 
 // Handshake represents a handshake returned by the API.
@@ -78,53 +102,136 @@ type UploadInput struct {
 	Password     string
 	ExpiresAt    string // RFC3339
 	MaxDownloads int
+	// Burn asks for a burn-after-reading share, deleted after one download.
+	Burn bool
+	// AvailableFrom (RFC3339) embargoes the share until that time.
+	AvailableFrom string
+	Tags          []string
+	Note          string
+	// TOTP asks the server for a TOTP secret, whose codes downloads of the
+	// share will need.
+	TOTP bool
+	// AllowedIPs limits downloads to these addresses or CIDR prefixes.
+	AllowedIPs []string
+	// Raw marks FileData as ciphertext the caller already encrypted. The
+	// server stores it as-is instead of encrypting it with its own key.
+	Raw bool
 }
 
-// Upload encrypts and uploads a file to the server, returning the created share.
+// Upload uploads a file to the server, returning the created share.
+// When FileData is seekable and the server supports resumable uploads, the
+// file is sent in chunks and a dropped connection resumes where it left off.
+// Otherwise the multipart body is streamed, so the file is never held in
+// memory either way.
 func (c *Client) Upload(input UploadInput) (*Share, error) {
-	var buf bytes.Buffer
-	mw := multipart.NewWriter(&buf)
+	if rs, ok := input.FileData.(io.ReadSeeker); ok && input.FileSize >= 0 && c.supportsResumable() {
+		return c.uploadResumable(input, rs)
+	}
+	return c.uploadMultipart(input)
+}
 
-	fw, err := mw.CreateFormFile("file", input.Filename)
+func (c *Client) uploadMultipart(input UploadInput) (*Share, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeUploadForm(mw, input))
+	}()
+
+	endpoint := "/api/upload"
+	if input.Raw {
+		endpoint = "/api/upload/raw"
+	}
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+endpoint, pr)
 	if err != nil {
-		return nil, fmt.Errorf("creating form file: %w", err)
+		pr.Close()
+		return nil, err
 	}
-	if _, err := io.Copy(fw, input.FileData); err != nil {
-		return nil, fmt.Errorf("writing file data: %w", err)
+	c.setAuth(req)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var share Share
+	if err := c.doJSONWith(c.transfer, req, &share); err != nil {
+		pr.Close()
+		return nil, err
 	}
+	return &share, nil
+}
 
+// writeUploadForm writes the metadata fields followed by the file part, so
+// the server knows the share settings before the file data arrives.
+func writeUploadForm(mw *multipart.Writer, input UploadInput) error {
 	if input.Password != "" {
-		mw.WriteField("password", input.Password)
+		if err := mw.WriteField("password", input.Password); err != nil {
+			return err
+		}
 	}
 	if input.ExpiresAt != "" {
-		mw.WriteField("expires_at", input.ExpiresAt)
+		if err := mw.WriteField("expires_at", input.ExpiresAt); err != nil {
+			return err
+		}
 	}
 	if input.MaxDownloads > 0 {
-		mw.WriteField("max_downloads", fmt.Sprintf("%d", input.MaxDownloads))
+		if err := mw.WriteField("max_downloads", fmt.Sprintf("%d", input.MaxDownloads)); err != nil {
+			return err
+		}
+	}
+	if input.Burn {
+		if err := mw.WriteField("burn", "true"); err != nil {
+			return err
+		}
+	}
+	if input.AvailableFrom != "" {
+		if err := mw.WriteField("available_from", input.AvailableFrom); err != nil {
+			return err
+		}
+	}
+	if len(input.Tags) > 0 {
+		if err := mw.WriteField("tags", strings.Join(input.Tags, ",")); err != nil {
+			return err
+		}
+	}
+	if input.Note != "" {
+		if err := mw.WriteField("note", input.Note); err != nil {
+			return err
+		}
+	}
+	if input.TOTP {
+		if err := mw.WriteField("totp", "true"); err != nil {
+			return err
+		}
+	}
+	if len(input.AllowedIPs) > 0 {
+		if err := mw.WriteField("allowed_ips", strings.Join(input.AllowedIPs, ",")); err != nil {
+			return err
+		}
 	}
-	mw.Close()
 
-	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/api/upload", &buf)
+	fw, err := mw.CreateFormFile("file", input.Filename)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("creating form file: %w", err)
 	}
-	c.setAuth(req)
-	req.Header.Set("Content-Type", mw.FormDataContentType())
-
-	var share Share
-	if err := c.doJSON(req, &share); err != nil {
-		return nil, err
+	if _, err := io.Copy(fw, input.FileData); err != nil {
+		return fmt.Errorf("writing file data: %w", err)
 	}
-	return &share, nil
+	return mw.Close()
 }
 
 // GetShare fetches share metadata by ID.
 func (c *Client) GetShare(id string) (*Share, error) {
+	return c.getShareAs(id, c.AdminToken)
+}
+
+// getShareAs fetches share metadata with the given bearer token, such as
+// the share's owner token where the client's own token may only upload.
+func (c *Client) getShareAs(id, token string) (*Share, error) {
 	req, err := http.NewRequest(http.MethodGet, c.BaseURL+"/api/shares/"+id, nil)
 	if err != nil {
 		return nil, err
 	}
-	c.setAuth(req)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 	var share Share
 	if err := c.doJSON(req, &share); err != nil {
 		return nil, err
@@ -132,29 +239,140 @@ func (c *Client) GetShare(id string) (*Share, error) {
 	return &share, nil
 }
 
-// ListShares returns all shares.
-func (c *Client) ListShares() ([]Share, error) {
-	req, err := http.NewRequest(http.MethodGet, c.BaseURL+"/api/shares", nil)
+// ShareQuery filters, sorts and pages a share listing. Zero fields are
+// left to the server's defaults.
+type ShareQuery struct {
+	Tags   []string // shares must carry all of these
+	Search string
+	Status string // active, embargoed, expired or exhausted
+	// Sort is created, expires, size, name or downloads, prefixed with
+	// "-" for descending order. The server lists the newest first.
+	Sort             string
+	MinSize, MaxSize int64 // bytes
+	CreatedAfter     time.Time
+	CreatedBefore    time.Time
+	ExpiresAfter     time.Time
+	ExpiresBefore    time.Time
+	Limit            int    // page size; the server defaults to 100
+	Cursor           string // from a previous ListShares call
+}
+
+func (q ShareQuery) values() url.Values {
+	v := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	if len(q.Tags) > 0 {
+		v.Set("tag", strings.Join(q.Tags, ","))
+	}
+	set("search", q.Search)
+	set("status", q.Status)
+	set("sort", q.Sort)
+	if q.MinSize > 0 {
+		v.Set("min_size", strconv.FormatInt(q.MinSize, 10))
+	}
+	if q.MaxSize > 0 {
+		v.Set("max_size", strconv.FormatInt(q.MaxSize, 10))
+	}
+	for name, t := range map[string]time.Time{
+		"created_after":  q.CreatedAfter,
+		"created_before": q.CreatedBefore,
+		"expires_after":  q.ExpiresAfter,
+		"expires_before": q.ExpiresBefore,
+	} {
+		if !t.IsZero() {
+			v.Set(name, t.UTC().Format(time.RFC3339))
+		}
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	set("cursor", q.Cursor)
+	return v
+}
+
+// ListShares returns one page of the shares matching q, and the cursor for
+// the next page, which is empty on the last one.
+func (c *Client) ListShares(q ShareQuery) ([]Share, string, error) {
+	u := c.BaseURL + "/api/shares"
+	if v := q.values(); len(v) > 0 {
+		u += "?" + v.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	c.setAuth(req)
 	var shares []Share
-	if err := c.doJSON(req, &shares); err != nil {
-		return nil, err
+	header, err := c.doJSONHeader(c.http, req, &shares)
+	if err != nil {
+		return nil, "", err
+	}
+	return shares, header.Get("X-Next-Cursor"), nil
+}
+
+// ErrVerifyUnsupported is returned by VerifyPassword when the server has no
+// verify endpoint.
+var ErrVerifyUnsupported = errors.New("server cannot verify share passwords")
+
+// VerifyPassword checks a share's password and TOTP code on the server and
+// returns a short-lived ticket for DownloadFile. Either may be empty when
+// the share does not need it. After too many wrong attempts the server
+// refuses to check for a while, and the error says how long.
+func (c *Client) VerifyPassword(id, password, code string) (string, error) {
+	b, _ := json.Marshal(map[string]string{"password": password, "code": code})
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/api/shares/"+id+"/verify", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	c.setAuth(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http request: %w", err)
 	}
-	return shares, nil
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading response: %w", err)
+	}
+
+	var out struct {
+		Ticket string `json:"ticket"`
+		Error  string `json:"error"`
+	}
+	jsonErr := json.Unmarshal(body, &out)
+	switch {
+	case resp.StatusCode == http.StatusOK && out.Ticket != "":
+		return out.Ticket, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		if jsonErr != nil || out.Error == "" {
+			return "", ErrVerifyUnsupported
+		}
+	}
+	msg := out.Error
+	if msg == "" {
+		msg = string(body)
+	}
+	return "", fmt.Errorf("API error %d: %s", resp.StatusCode, msg)
 }
 
-// DownloadFile downloads the encrypted file for a share.
-func (c *Client) DownloadFile(id string) ([]byte, error) {
+// DownloadFile downloads the encrypted file for a share. ticket, from
+// VerifyPassword, is required when the share has a password or TOTP code.
+func (c *Client) DownloadFile(id, ticket string) ([]byte, error) {
 	req, err := http.NewRequest(http.MethodGet, c.BaseURL+"/api/shares/"+id+"/file", nil)
 	if err != nil {
 		return nil, err
 	}
 	c.setAuth(req)
+	if ticket != "" {
+		req.Header.Set("X-Download-Ticket", ticket)
+	}
 
-	resp, err := c.http.Do(req)
+	resp, err := c.transfer.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("downloading file: %w", err)
 	}
@@ -320,15 +538,25 @@ func (c *Client) setAuth(req *http.Request) {
 }
 
 func (c *Client) doJSON(req *http.Request, out interface{}) error {
-	resp, err := c.http.Do(req)
+	return c.doJSONWith(c.http, req, out)
+}
+
+func (c *Client) doJSONWith(hc *http.Client, req *http.Request, out interface{}) error {
+	_, err := c.doJSONHeader(hc, req, out)
+	return err
+}
+
+// doJSONHeader is doJSONWith that also returns the response headers.
+func (c *Client) doJSONHeader(hc *http.Client, req *http.Request, out interface{}) (http.Header, error) {
+	resp, err := hc.Do(req)
 	if err != nil {
-		return fmt.Errorf("http request: %w", err)
+		return nil, fmt.Errorf("http request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("reading response: %w", err)
+		return nil, fmt.Errorf("reading response: %w", err)
 	}
 
 	if resp.StatusCode >= 400 {
@@ -340,13 +568,13 @@ func (c *Client) doJSON(req *http.Request, out interface{}) error {
 		if msg == "" {
 			msg = string(body)
 		}
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("API error %d: %s", resp.StatusCode, msg)
 	}
 
 	if out != nil && len(body) > 0 {
 		if err := json.Unmarshal(body, out); err != nil {
-			return fmt.Errorf("decoding response: %w", err)
+			return nil, fmt.Errorf("decoding response: %w", err)
 		}
 	}
-	return nil
+	return resp.Header, nil
 }
@@ -0,0 +1,283 @@
+package apiclient
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resumable uploads speak the tus 1.0 protocol against /api/uploads. Each
+// PATCH carries at most resumableChunkSize bytes; when a request fails the
+// client asks the server how far it got (HEAD) and continues from there.
+
+const (
+	tusVersion = "1.0.0"
+
+	// resumableChunkSize is the amount of data sent per PATCH request.
+	resumableChunkSize = 16 << 20 // 16 MB
+
+	// maxUploadRetries is how many consecutive failed requests are tolerated
+	// before giving up. The counter resets whenever the upload makes progress.
+	maxUploadRetries = 5
+)
+
+// httpStatusError is returned for non-2xx responses during a resumable upload.
+type httpStatusError struct {
+	Code int
+	Msg  string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("upload failed (%d): %s", e.Code, e.Msg)
+}
+
+// retryable reports whether a failed request is worth retrying.
+func retryable(err error) bool {
+	se, ok := err.(*httpStatusError)
+	if !ok {
+		return true // network error
+	}
+	return se.Code == http.StatusConflict || se.Code >= 500
+}
+
+// supportsResumable probes the server for tus support. The hosted web app
+// does not implement it, in which case Upload falls back to multipart.
+func (c *Client) supportsResumable() bool {
+	req, err := http.NewRequest(http.MethodOptions, c.BaseURL+"/api/uploads", nil)
+	if err != nil {
+		return false
+	}
+	c.setAuth(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return false
+	}
+	return strings.Contains(resp.Header.Get("Tus-Version"), tusVersion)
+}
+
+// uploadResumable uploads src via the tus protocol, resuming after dropped
+// connections, and returns the created share.
+func (c *Client) uploadResumable(input UploadInput, src io.ReadSeeker) (*Share, error) {
+	up, err := c.createUpload(input)
+	if err != nil {
+		return nil, err
+	}
+	location, shareID := up.location, up.shareID
+
+	var offset int64
+	failures := 0
+	for shareID == "" {
+		var newOffset int64
+		newOffset, shareID, err = c.patchUpload(location, src, offset, input.FileSize)
+		if err == nil {
+			offset = newOffset
+			failures = 0
+			continue
+		}
+		if !retryable(err) {
+			return nil, err
+		}
+		failures++
+		if failures > maxUploadRetries {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", failures, err)
+		}
+		time.Sleep(retryDelay(failures))
+
+		// Ask the server how much it actually kept.
+		if headOffset, herr := c.uploadOffset(location); herr == nil {
+			if headOffset > offset {
+				failures = 0
+			}
+			offset = headOffset
+		}
+	}
+
+	// The owner token can read the new share even when the client's token
+	// is scoped to uploads only. Servers predating owner tokens send none.
+	token := up.ownerToken
+	if token == "" {
+		token = c.AdminToken
+	}
+	share, err := c.getShareAs(shareID, token)
+	if err != nil {
+		return nil, err
+	}
+	share.OwnerToken = up.ownerToken
+	share.TOTPSecret = up.totpSecret
+	return share, nil
+}
+
+// createdUpload is what the tus creation request returns.
+type createdUpload struct {
+	location   string // upload URL
+	shareID    string // set only for zero-length uploads, which complete at once
+	ownerToken string // of the share to be created
+	totpSecret string // set when the upload asked for TOTP
+}
+
+// createUpload issues the tus creation request.
+func (c *Client) createUpload(input UploadInput) (*createdUpload, error) {
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/api/uploads", nil)
+	if err != nil {
+		return nil, err
+	}
+	c.setAuth(req)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.FormatInt(input.FileSize, 10))
+
+	meta := map[string]string{"filename": input.Filename}
+	if input.Password != "" {
+		meta["password"] = input.Password
+	}
+	if input.ExpiresAt != "" {
+		meta["expires_at"] = input.ExpiresAt
+	}
+	if input.MaxDownloads > 0 {
+		meta["max_downloads"] = strconv.Itoa(input.MaxDownloads)
+	}
+	if input.Burn {
+		meta["burn"] = "true"
+	}
+	if input.AvailableFrom != "" {
+		meta["available_from"] = input.AvailableFrom
+	}
+	if len(input.Tags) > 0 {
+		meta["tags"] = strings.Join(input.Tags, ",")
+	}
+	if input.Note != "" {
+		meta["note"] = input.Note
+	}
+	if input.TOTP {
+		meta["totp"] = "true"
+	}
+	if len(input.AllowedIPs) > 0 {
+		meta["allowed_ips"] = strings.Join(input.AllowedIPs, ",")
+	}
+	if input.Raw {
+		meta["encryption"] = "client"
+	}
+	req.Header.Set("Upload-Metadata", encodeTusMetadata(meta))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("creating upload: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &httpStatusError{Code: resp.StatusCode, Msg: strings.TrimSpace(string(body))}
+	}
+
+	loc, err := c.resolve(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload location: %w", err)
+	}
+	return &createdUpload{
+		location:   loc,
+		shareID:    resp.Header.Get("X-Share-Id"),
+		ownerToken: resp.Header.Get("X-Owner-Token"),
+		totpSecret: resp.Header.Get("X-TOTP-Secret"),
+	}, nil
+}
+
+// patchUpload sends the next chunk starting at offset. It returns the new
+// offset and, once the final chunk has been accepted, the share ID.
+func (c *Client) patchUpload(location string, src io.ReadSeeker, offset, total int64) (int64, string, error) {
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return offset, "", fmt.Errorf("seeking file: %w", err)
+	}
+	n := total - offset
+	if n > resumableChunkSize {
+		n = resumableChunkSize
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, location, io.LimitReader(src, n))
+	if err != nil {
+		return offset, "", err
+	}
+	req.ContentLength = n
+	c.setAuth(req)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+
+	resp, err := c.transfer.Do(req)
+	if err != nil {
+		return offset, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return offset, "", &httpStatusError{Code: resp.StatusCode, Msg: strings.TrimSpace(string(body))}
+	}
+
+	newOffset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return offset, "", fmt.Errorf("invalid Upload-Offset in response")
+	}
+	return newOffset, resp.Header.Get("X-Share-Id"), nil
+}
+
+// uploadOffset asks the server how many bytes of the upload it holds.
+func (c *Client) uploadOffset(location string) (int64, error) {
+	req, err := http.NewRequest(http.MethodHead, location, nil)
+	if err != nil {
+		return 0, err
+	}
+	c.setAuth(req)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &httpStatusError{Code: resp.StatusCode, Msg: resp.Status}
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// resolve turns a possibly relative Location header into an absolute URL.
+func (c *Client) resolve(location string) (string, error) {
+	base, err := url.Parse(c.BaseURL + "/")
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+func retryDelay(attempt int) time.Duration {
+	d := time.Second << (attempt - 1)
+	if d > 30*time.Second {
+		d = 30 * time.Second
+	}
+	return d
+}
+
+// encodeTusMetadata builds an Upload-Metadata header value.
+func encodeTusMetadata(meta map[string]string) string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(meta[k])))
+	}
+	return strings.Join(pairs, ",")
+}
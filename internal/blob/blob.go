@@ -0,0 +1,96 @@
+// Package blob stores encrypted share files. A Store holds opaque objects
+// addressed by key (e.g. "{shareID}.enc"); the local filesystem and
+// S3-compatible object storage are supported.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// Info describes a stored object.
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Store is a blob storage backend. Keys are flat names without "..";
+// implementations must make Put atomic, so a failed or aborted Put (src
+// returning an error) never leaves a partial object behind.
+type Store interface {
+	// Put stores the contents of src under key. size is the length of src,
+	// or -1 if unknown.
+	Put(ctx context.Context, key string, src io.Reader, size int64) error
+
+	// Get opens the object for reading.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// GetRange opens length bytes of the object starting at offset. A
+	// negative length reads to the end.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+
+	// Stat returns the object's size and modification time.
+	Stat(ctx context.Context, key string) (Info, error)
+
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List calls fn for every object in the store, in no particular order,
+	// and stops at the first error fn returns.
+	List(ctx context.Context, fn func(key string, info Info) error) error
+}
+
+// Key returns the object key for a share's encrypted file.
+func Key(shareID string) string {
+	return shareID + ".enc"
+}
+
+// PutFile moves the local file at path into s under key. Local stores
+// rename the file in place; other backends upload it and remove the file.
+func PutFile(ctx context.Context, s Store, key, path string) error {
+	if l, ok := s.(*Local); ok {
+		return l.importFile(key, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, key, f, fi.Size()); err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(path)
+}
+
+// Describe returns a short human-readable description of a store, for
+// startup banners and logs.
+func Describe(s Store) string {
+	switch s := s.(type) {
+	case *Local:
+		return "local " + s.root
+	case *S3:
+		return fmt.Sprintf("s3 %s/%s", s.endpoint, strings.TrimSuffix(s.bucket+"/"+s.prefix, "/"))
+	default:
+		return fmt.Sprintf("%T", s)
+	}
+}
+
+func validKey(key string) error {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,443 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3PartSize is the multipart upload part size. Objects smaller than this
+// are sent with a single PUT. S3 requires parts of at least 5 MiB.
+const s3PartSize = 8 << 20 // 8 MB
+
+// S3Config configures an S3-compatible object store (AWS S3, MinIO, R2, ...).
+type S3Config struct {
+	Endpoint     string // e.g. "http://localhost:9000"; default AWS for Region
+	Region       string // default "us-east-1"
+	Bucket       string
+	Prefix       string // optional key prefix, e.g. "durins-door/"
+	AccessKey    string
+	SecretKey    string
+	SessionToken string // optional, for temporary credentials
+}
+
+// S3 stores blobs in an S3-compatible bucket using path-style requests
+// signed with AWS Signature Version 4.
+type S3 struct {
+	endpoint string
+	region   string
+	bucket   string
+	prefix   string
+	access   string
+	secret   string
+	token    string
+	http     *http.Client
+}
+
+// NewS3 returns a Store backed by the configured bucket.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3: bucket is required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3: access key and secret key are required")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint: %w", err)
+	}
+	return &S3{
+		endpoint: endpoint,
+		region:   region,
+		bucket:   cfg.Bucket,
+		prefix:   cfg.Prefix,
+		access:   cfg.AccessKey,
+		secret:   cfg.SecretKey,
+		token:    cfg.SessionToken,
+		http:     &http.Client{}, // no timeout: objects can be large
+	}, nil
+}
+
+// Put uploads src. Small objects use a single PUT; larger ones a multipart
+// upload that is aborted if src fails, so no partial object is left behind.
+func (s *S3) Put(ctx context.Context, key string, src io.Reader, size int64) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(src, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.putObject(ctx, key, buf[:n])
+	}
+	if err != nil {
+		return err
+	}
+	return s.putMultipart(ctx, key, buf, src)
+}
+
+func (s *S3) putObject(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, data, nil)
+	if err != nil {
+		return fmt.Errorf("s3 put %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) putMultipart(ctx context.Context, key string, first []byte, rest io.Reader) error {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return fmt.Errorf("s3 create multipart upload %s: %w", key, err)
+	}
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil || created.UploadID == "" {
+		return fmt.Errorf("s3 create multipart upload %s: invalid response", key)
+	}
+
+	if err := s.uploadParts(ctx, key, created.UploadID, first, rest); err != nil {
+		// Abort with a fresh context: ctx may be the reason we failed.
+		if resp, aerr := s.do(context.Background(), http.MethodDelete, key,
+			url.Values{"uploadId": {created.UploadID}}, nil, nil); aerr == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *S3) uploadParts(ctx context.Context, key, uploadID string, buf []byte, src io.Reader) error {
+	var parts []completedPart
+	data := buf
+	for num := 1; ; num++ {
+		q := url.Values{"partNumber": {strconv.Itoa(num)}, "uploadId": {uploadID}}
+		resp, err := s.do(ctx, http.MethodPut, key, q, data, nil)
+		if err != nil {
+			return fmt.Errorf("s3 upload part %d of %s: %w", num, key, err)
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: num, ETag: resp.Header.Get("ETag")})
+
+		n, err := io.ReadFull(src, buf)
+		if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		data = buf[:n]
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, nil)
+	if err != nil {
+		return fmt.Errorf("s3 complete multipart upload %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	// CompleteMultipartUpload can fail after a 200 status; the error is in
+	// the body.
+	respBody, _ := io.ReadAll(resp.Body)
+	if bytes.Contains(respBody, []byte("<Error>")) {
+		return fmt.Errorf("s3 complete multipart upload %s: %w", key, parseS3Error(resp.StatusCode, respBody))
+	}
+	return nil
+}
+
+// Get opens the object for reading.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+// GetRange requests a byte range of the object.
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	h := http.Header{}
+	switch {
+	case length > 0:
+		h.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		h.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, h)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat issues a HEAD request for the object.
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	if err := validKey(key); err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	info := Info{Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+// Delete removes the object.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("s3 delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List pages through the objects under the configured prefix with
+// ListObjectsV2.
+func (s *S3) List(ctx context.Context, fn func(key string, info Info) error) error {
+	var token string
+	for {
+		q := url.Values{"list-type": {"2"}}
+		if s.prefix != "" {
+			q.Set("prefix", s.prefix)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := s.send(ctx, http.MethodGet, "/"+s.bucket, q, nil, nil)
+		if err != nil {
+			return fmt.Errorf("s3 list %s: %w", s.bucket, err)
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3 list %s: invalid response: %w", s.bucket, err)
+		}
+		for _, obj := range page.Contents {
+			key := strings.TrimPrefix(obj.Key, s.prefix)
+			if err := fn(key, Info{Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// s3Error is an error response from the object store.
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("status %d", e.Status)
+	}
+	return fmt.Sprintf("%s: %s (status %d)", e.Code, e.Message, e.Status)
+}
+
+func parseS3Error(status int, body []byte) error {
+	e := &s3Error{Status: status}
+	_ = xml.Unmarshal(body, e)
+	return e
+}
+
+// do sends a signed request for key and returns the response if its status
+// is 2xx. A 404 (or NoSuchKey) is reported as ErrNotFound.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	return s.send(ctx, method, "/"+s.bucket+"/"+uriEncode(s.prefix+key, false), query, body, header)
+}
+
+// send is do for an already escaped request path.
+func (s *S3) send(ctx context.Context, method, path string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	rawQuery := canonicalQuery(query)
+	u, err := url.Parse(s.endpoint + path)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = rawQuery
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if len(body) == 0 {
+		req.Body = http.NoBody
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, path, rawQuery, hashHex(body), time.Now())
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	serr := parseS3Error(resp.StatusCode, respBody).(*s3Error)
+	if resp.StatusCode == http.StatusNotFound && (serr.Code == "" || serr.Code == "NoSuchKey") {
+		return nil, ErrNotFound
+	}
+	return nil, serr
+}
+
+// sign adds AWS Signature Version 4 headers to req. path and rawQuery must
+// be the exact escaped forms used in the request URL.
+func (s *S3) sign(req *http.Request, path, rawQuery, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	canonRequest, signedHeaders := canonicalRequest(req, path, rawQuery, payloadHash)
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonRequest))
+
+	k := hmacSHA256([]byte("AWS4"+s.secret), date)
+	k = hmacSHA256(k, s.region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.access+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalRequest returns the SigV4 canonical request for req, which signs
+// the host and every x-amz-* header, and the list of signed headers.
+func canonicalRequest(req *http.Request, path, rawQuery, payloadHash string) (canonical, signedHeaders string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+	canonical = strings.Join([]string{
+		req.Method, path, rawQuery, canonHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	return canonical, signedHeaders
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as SigV4 requires.
+func canonicalQuery(q url.Values) string {
+	if len(q) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except unreserved characters (and
+// '/' unless encodeSlash is set), per the SigV4 specification.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
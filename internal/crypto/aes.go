@@ -3,9 +3,12 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -81,6 +84,23 @@ func DeriveKeyWithSalt(passphrase string, salt []byte) ([]byte, error) {
 	return key, nil
 }
 
+// MixPassword binds key to a password: it derives a key from password and
+// salt with Argon2id and returns HMAC-SHA256 of that under key. The result
+// can only be recomputed by someone holding both key and password, so a
+// leaked link or shared secret is not enough to decrypt on its own.
+func MixPassword(key []byte, password string, salt []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key length: got %d bytes, want %d", len(key), KeySize)
+	}
+	pwKey, err := DeriveKeyWithSalt(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	m := hmac.New(sha256.New, key)
+	m.Write(pwKey)
+	return m.Sum(nil), nil
+}
+
 // Encryptor wraps an io.Writer and encrypts data as it is written.
 // Uses AES-256-GCM in streaming mode with prepended per-chunk nonces.
 type Encryptor struct {
@@ -114,6 +134,37 @@ func NewEncryptor(dst io.Writer, key []byte) (*Encryptor, error) {
 	}, nil
 }
 
+// ResumeEncryptor continues an encrypted stream previously started by
+// NewEncryptor. fileNonce is the nonce written at the start of the stream and
+// chunk is the number of chunks already written. Nothing is written to dst
+// until data arrives, so dst should be positioned at the end of the stream.
+func ResumeEncryptor(dst io.Writer, key, fileNonce []byte, chunk int) (*Encryptor, error) {
+	if len(fileNonce) != NonceSize {
+		return nil, fmt.Errorf("invalid nonce length: got %d bytes, want %d", len(fileNonce), NonceSize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &Encryptor{
+		dst:   dst,
+		block: block,
+		buf:   make([]byte, 0, ChunkSize),
+		nonce: append([]byte(nil), fileNonce...),
+		chunk: chunk,
+	}, nil
+}
+
+// Nonce returns the file-level nonce written at the start of the stream.
+func (e *Encryptor) Nonce() []byte {
+	return e.nonce
+}
+
+// Chunks returns the number of chunks encrypted so far.
+func (e *Encryptor) Chunks() int {
+	return e.chunk
+}
+
 func (e *Encryptor) encryptChunk(data []byte) error {
 	gcm, err := cipher.NewGCM(e.block)
 	if err != nil {
@@ -258,3 +309,180 @@ func DecryptStream(dst io.Writer, src io.Reader, key []byte) error {
 	}
 	return nil
 }
+
+// EncryptedSize returns the length of the stream EncryptStream produces for
+// size bytes of plaintext: the file nonce, then per chunk a 4-byte length,
+// a nonce and the GCM tag on top of the data.
+func EncryptedSize(size int64) int64 {
+	chunks := (size + ChunkSize - 1) / ChunkSize
+	return NonceSize + chunks*(4+NonceSize+TagSize) + size
+}
+
+// EncryptReader encrypts a seekable plaintext source on the fly, producing
+// the stream EncryptStream would while holding one chunk in memory. Every
+// chunk but the last is full, so each offset of the output falls in a known
+// chunk of the source; chunk nonces depend only on the file nonce and the
+// chunk number, so seeking back re-encrypts the same bytes. Resumable
+// uploads can therefore rewind it.
+type EncryptReader struct {
+	src   io.ReadSeeker
+	size  int64 // plaintext bytes
+	key   []byte
+	nonce []byte
+	pos   int64
+	chunk int    // chunk held in buf, -1 for none
+	buf   []byte // chunk, framed as in the stream
+}
+
+// NewEncryptReader returns an EncryptReader over the first size bytes of
+// src, under key and a fresh file nonce.
+func NewEncryptReader(src io.ReadSeeker, size int64, key []byte) (*EncryptReader, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	nonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return &EncryptReader{src: src, size: size, key: key, nonce: nonce, chunk: -1}, nil
+}
+
+// Size returns the length of the encrypted stream.
+func (r *EncryptReader) Size() int64 {
+	return EncryptedSize(r.size)
+}
+
+// Read reads encrypted bytes at the current position.
+func (r *EncryptReader) Read(p []byte) (int, error) {
+	if r.pos >= r.Size() {
+		return 0, io.EOF
+	}
+	if r.pos < NonceSize {
+		n := copy(p, r.nonce[r.pos:])
+		r.pos += int64(n)
+		return n, nil
+	}
+	const framed = 4 + NonceSize + ChunkSize + TagSize
+	chunk := int((r.pos - NonceSize) / framed)
+	if chunk != r.chunk {
+		if err := r.encryptChunk(chunk); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[(r.pos-NonceSize)%framed:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *EncryptReader) encryptChunk(chunk int) error {
+	start := int64(chunk) * ChunkSize
+	if _, err := r.src.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	data := make([]byte, min(ChunkSize, r.size-start))
+	if _, err := io.ReadFull(r.src, data); err != nil {
+		return fmt.Errorf("failed to read chunk %d: %w", chunk, err)
+	}
+	var buf bytes.Buffer
+	enc, err := ResumeEncryptor(&buf, r.key, r.nonce, chunk)
+	if err != nil {
+		return err
+	}
+	enc.Write(data)
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	r.buf, r.chunk = buf.Bytes(), chunk
+	return nil
+}
+
+// Seek sets the position in the encrypted stream.
+func (r *EncryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// PlaintextSize walks the chunk length prefixes of an encrypted stream and
+// returns the number of plaintext bytes it holds, without decrypting. Unlike
+// EncryptedSize it does not assume full chunks, so it also measures streams
+// that were flushed part-way through, as resumable uploads are. It fails on
+// a truncated stream or an impossible chunk length.
+func PlaintextSize(src io.Reader) (int64, error) {
+	if _, err := io.ReadFull(src, make([]byte, NonceSize)); err != nil {
+		return 0, fmt.Errorf("failed to read file nonce: %w", err)
+	}
+	var size int64
+	header := make([]byte, 4)
+	for chunk := 0; ; chunk++ {
+		if _, err := io.ReadFull(src, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return size, nil
+			}
+			return 0, fmt.Errorf("failed to read chunk %d header: %w", chunk, err)
+		}
+		length := uint32(header[0])<<24 | uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+		if length < TagSize || length > ChunkSize+TagSize {
+			return 0, fmt.Errorf("invalid chunk %d length %d", chunk, length)
+		}
+		n, err := io.CopyN(io.Discard, src, NonceSize+int64(length))
+		if err != nil {
+			return 0, fmt.Errorf("chunk %d truncated after %d of %d bytes", chunk, n, NonceSize+int64(length))
+		}
+		size += int64(length) - TagSize
+	}
+}
+
+// CheckHeader reads the file nonce and the first chunk of an encrypted
+// stream and verifies that the chunk decrypts under key. It catches
+// truncated or corrupt files and wrong keys without reading the whole
+// stream. An empty stream (nonce only) passes.
+func CheckHeader(src io.Reader, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	fileNonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(src, fileNonce); err != nil {
+		return fmt.Errorf("failed to read file nonce: %w", err)
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(src, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return fmt.Errorf("failed to read chunk header: %w", err)
+	}
+	length := uint32(header[0])<<24 | uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+	if length < TagSize || length > ChunkSize+TagSize {
+		return fmt.Errorf("invalid chunk length %d", length)
+	}
+	chunkNonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(src, chunkNonce); err != nil {
+		return fmt.Errorf("failed to read chunk nonce: %w", err)
+	}
+	ciphertext := make([]byte, length)
+	if _, err := io.ReadFull(src, ciphertext); err != nil {
+		return fmt.Errorf("failed to read ciphertext: %w", err)
+	}
+	if _, err := gcm.Open(nil, chunkNonce, ciphertext, nil); err != nil {
+		return fmt.Errorf("failed to decrypt chunk 0: %w", err)
+	}
+	return nil
+}
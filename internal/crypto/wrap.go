@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WrapKey encrypts key under the key-encryption key kek with AES-256-GCM.
+// aad binds the wrapped key to its owner (the share ID), so a wrapped key
+// copied onto another row fails to unwrap. Output: nonce || ciphertext+tag.
+func WrapKey(kek, key, aad []byte) ([]byte, error) {
+	gcm, err := newKEKCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, key, aad), nil
+}
+
+// UnwrapKey reverses WrapKey. It fails if kek or aad do not match.
+func UnwrapKey(kek, wrapped, aad []byte) ([]byte, error) {
+	gcm, err := newKEKCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < NonceSize+TagSize {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	key, err := gcm.Open(nil, wrapped[:NonceSize], wrapped[NonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key: wrong KEK or corrupted data")
+	}
+	return key, nil
+}
+
+// ParseKEK decodes a key-encryption key given as 64 hex characters or as
+// base64 (standard or URL alphabet, padded or not) of 32 bytes.
+func ParseKEK(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if b, err := hex.DecodeString(s); err == nil && len(b) == KeySize {
+		return b, nil
+	}
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding,
+		base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if b, err := enc.DecodeString(s); err == nil && len(b) == KeySize {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("KEK must be %d bytes as hex or base64", KeySize)
+}
+
+func newKEKCipher(kek []byte) (cipher.AEAD, error) {
+	if len(kek) != KeySize {
+		return nil, fmt.Errorf("invalid KEK length: got %d bytes, want %d", len(kek), KeySize)
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
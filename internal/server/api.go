@@ -4,16 +4,16 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/unisoniq/durins-door/internal/crypto"
 	"github.com/unisoniq/durins-door/internal/share"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -25,13 +25,25 @@ type apiShare struct {
 	Filename          string     `json:"filename"`
 	FileSize          int64      `json:"file_size"`
 	MimeType          string     `json:"mime_type,omitempty"`
-	PasswordHash      *string    `json:"password_hash,omitempty"`
 	MaxDownloads      *int       `json:"max_downloads,omitempty"`
 	Downloads         int        `json:"downloads"`
 	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
 	CreatedAt         time.Time  `json:"created_at"`
 	StoragePath       string     `json:"storage_path,omitempty"`
 	PasswordProtected bool       `json:"password_protected"`
+	ClientEncrypted   bool       `json:"client_encrypted,omitempty"`
+	Burn              bool       `json:"burn,omitempty"`
+	AvailableFrom     *time.Time `json:"available_from,omitempty"`
+	Tags              []string   `json:"tags,omitempty"`
+	Note              string     `json:"note,omitempty"`
+	TOTPRequired      bool       `json:"totp_required,omitempty"`
+	AllowedIPs        []string   `json:"allowed_ips,omitempty"`
+	// OwnerToken is returned only when the share is created.
+	OwnerToken string `json:"owner_token,omitempty"`
+	// TOTPSecret and TOTPURI are returned only when a TOTP secret is
+	// created, on upload or update.
+	TOTPSecret string `json:"totp_secret,omitempty"`
+	TOTPURI    string `json:"totp_uri,omitempty"`
 }
 
 func shareToAPI(sh *share.Share) apiShare {
@@ -42,7 +54,13 @@ func shareToAPI(sh *share.Share) apiShare {
 		Downloads:         sh.Downloads,
 		CreatedAt:         sh.CreatedAt,
 		PasswordProtected: sh.PasswordHash != "",
-		StoragePath:       sh.EncryptedPath,
+		StoragePath:       sh.BlobKey,
+		ClientEncrypted:   sh.ClientEncrypted,
+		Burn:              sh.Burn,
+		Tags:              sh.Tags,
+		Note:              sh.Note,
+		TOTPRequired:      sh.HasTOTP(),
+		AllowedIPs:        sh.AllowedIPs,
 	}
 	if sh.MaxDownloads > 0 {
 		md := sh.MaxDownloads
@@ -52,8 +70,31 @@ func shareToAPI(sh *share.Share) apiShare {
 		t := sh.ExpiresAt
 		a.ExpiresAt = &t
 	}
-	if sh.PasswordHash != "" {
-		a.PasswordHash = &sh.PasswordHash
+	if !sh.AvailableFrom.IsZero() {
+		t := sh.AvailableFrom
+		a.AvailableFrom = &t
+	}
+	return a
+}
+
+// publicShareToAPI is shareToAPI without what only the share's owner and
+// the server's operators see: its labels, IP allowlist and where its file
+// is stored.
+func publicShareToAPI(sh *share.Share) apiShare {
+	a := shareToAPI(sh)
+	a.Tags = nil
+	a.Note = ""
+	a.StoragePath = ""
+	a.AllowedIPs = nil
+	return a
+}
+
+// withTOTPSecret adds sh's TOTP secret and its otpauth:// URI to a, for
+// the response that created the secret.
+func withTOTPSecret(a apiShare, sh *share.Share) apiShare {
+	if sh.HasTOTP() {
+		a.TOTPSecret = sh.TOTPSecret
+		a.TOTPURI = share.TOTPURI(sh.TOTPSecret, sh.Filename)
 	}
 	return a
 }
@@ -90,154 +131,10 @@ func handshakeToAPI(h *share.Handshake) apiHandshake {
 	return a
 }
 
-// --- Upload endpoint ---
-
-// maxUploadSize is the server-side upload limit (50 MB), matching the client.
-const maxUploadSize = 50 << 20 // 50 MB
-
-// handleAPIUpload handles POST /api/upload
-// Accepts multipart form: file + JSON metadata fields.
-// Encrypts the file server-side and stores it.
-func (s *Server) handleAPIUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// Enforce server-side max upload size (50 MB) before reading any body.
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
-
-	// Parse multipart form (memory buffer capped to maxUploadSize; disk not used)
-	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
-		jsonError(w, "Upload too large or invalid multipart form: "+err.Error(), http.StatusRequestEntityTooLarge)
-		return
-	}
-
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		jsonError(w, "Missing file field", http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
-
-	// Read the entire file into memory for encryption
-	plaintext, err := io.ReadAll(file)
-	if err != nil {
-		jsonError(w, "Reading file: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	filename := header.Filename
-	if filename == "" {
-		filename = "upload"
-	}
-
-	// Parse optional fields
-	password := r.FormValue("password")
-	expiresStr := r.FormValue("expires_at")
-	maxDownloadsStr := r.FormValue("max_downloads")
-
-	// Generate encryption key
-	key, err := crypto.GenerateKey()
-	if err != nil {
-		jsonError(w, "Generating key: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	keyHex := crypto.KeyToHex(key)
-
-	// Generate share ID
-	shareID := randomAPIID()
-
-	// Encrypt file to disk
-	encPath := filepath.Join(s.store.DataDir(), "files", shareID+".enc")
-	if err := os.MkdirAll(filepath.Dir(encPath), 0700); err != nil {
-		jsonError(w, "Creating files dir: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	encFile, err := os.OpenFile(encPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
-	if err != nil {
-		jsonError(w, "Creating encrypted file: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	enc, err := crypto.NewEncryptor(encFile, key)
-	if err != nil {
-		encFile.Close()
-		os.Remove(encPath)
-		jsonError(w, "Creating encryptor: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if _, err := enc.Write(plaintext); err != nil {
-		encFile.Close()
-		os.Remove(encPath)
-		jsonError(w, "Encrypting: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if err := enc.Flush(); err != nil {
-		encFile.Close()
-		os.Remove(encPath)
-		jsonError(w, "Flushing encryptor: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	encFile.Close()
-
-	// Hash password if provided
-	var passwordHash string
-	if password != "" {
-		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-		if err != nil {
-			os.Remove(encPath)
-			jsonError(w, "Hashing password: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		passwordHash = string(hash)
-	}
-
-	// Parse expiry
-	expiresAt := time.Now().Add(time.Hour) // default 1 hour
-	if expiresStr != "" {
-		if t, err := time.Parse(time.RFC3339, expiresStr); err == nil {
-			expiresAt = t
-		}
-	}
-
-	// Parse max downloads
-	var maxDownloads int
-	if maxDownloadsStr != "" {
-		fmt.Sscanf(maxDownloadsStr, "%d", &maxDownloads)
-	}
-
-	adminToken := randomAPIID()
-
-	sh := &share.Share{
-		ID:            shareID,
-		Filename:      filepath.Base(filename),
-		EncryptedPath: encPath,
-		KeyHex:        keyHex,
-		CreatedAt:     time.Now(),
-		ExpiresAt:     expiresAt,
-		MaxDownloads:  maxDownloads,
-		PasswordHash:  passwordHash,
-		AdminToken:    adminToken,
-		Size:          int64(len(plaintext)),
-	}
-
-	if err := s.store.Create(r.Context(), sh); err != nil {
-		os.Remove(encPath)
-		jsonError(w, "Creating share: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(shareToAPI(sh))
-}
-
 // --- Share metadata endpoint ---
 
-// handleAPIShareGet handles GET /api/shares/{id}
+// handleAPIShareGet handles GET /api/shares/{id}. Recipients without a
+// token get the public view (see publicShareToAPI).
 func (s *Server) handleAPIShareGet(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/shares/")
 
@@ -247,16 +144,30 @@ func (s *Server) handleAPIShareGet(w http.ResponseWriter, r *http.Request) {
 		s.handleAPIShareFile(w, r, id)
 		return
 	}
+	if strings.HasSuffix(id, "/events") {
+		id = strings.TrimSuffix(id, "/events")
+		s.handleAPIShareEvents(w, r, id)
+		return
+	}
 	if strings.HasSuffix(id, "/downloads") {
 		id = strings.TrimSuffix(id, "/downloads")
 		s.handleAPIShareIncrementDownloads(w, r, id)
 		return
 	}
+	if strings.HasSuffix(id, "/verify") {
+		id = strings.TrimSuffix(id, "/verify")
+		s.handleAPIShareVerify(w, r, id)
+		return
+	}
 
 	if r.Method == http.MethodDelete {
 		s.handleAPIShareDelete(w, r, id)
 		return
 	}
+	if r.Method == http.MethodPatch {
+		s.handleAPIShareUpdate(w, r, id)
+		return
+	}
 
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -273,14 +184,29 @@ func (s *Server) handleAPIShareGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a := shareToAPI(sh)
+	if !canManage(r) {
+		if !allowedFrom(r, sh) {
+			notAllowedFrom(w)
+			return
+		}
+		a = publicShareToAPI(sh)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(shareToAPI(sh))
+	json.NewEncoder(w).Encode(a)
+}
+
+// notAllowedFrom answers a request from outside a share's IP allowlist.
+func notAllowedFrom(w http.ResponseWriter) {
+	jsonError(w, "This share cannot be downloaded from your network address", http.StatusForbidden)
 }
 
 // handleAPIShareFile handles GET /api/shares/{id}/file
 // Returns the encrypted file as-is (the CLI decrypts client-side).
-// If the share is password-protected, the caller must supply the correct
-// password via the X-Share-Password header.
+// If the share has a password or a TOTP secret, the caller must present a
+// ticket from POST /api/shares/{id}/verify in the X-Download-Ticket
+// header, and it must connect from inside the share's IP allowlist. The
+// whole file is always sent, and counted as a download once it has been.
 func (s *Server) handleAPIShareFile(w http.ResponseWriter, r *http.Request, id string) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -297,6 +223,10 @@ func (s *Server) handleAPIShareFile(w http.ResponseWriter, r *http.Request, id s
 		return
 	}
 
+	if sh.IsEmbargoed() {
+		notYetAvailable(w, sh, true)
+		return
+	}
 	if sh.IsExpired() {
 		jsonError(w, "Share expired", http.StatusGone)
 		return
@@ -306,24 +236,47 @@ func (s *Server) handleAPIShareFile(w http.ResponseWriter, r *http.Request, id s
 		return
 	}
 
-	// --- Password verification ---
-	if sh.PasswordHash != "" {
-		provided := r.Header.Get("X-Share-Password")
-		if provided == "" {
-			// Also accept via query param for flexibility (e.g. direct browser links)
-			provided = r.URL.Query().Get("password")
-		}
-		if provided == "" {
-			jsonError(w, "Password required", http.StatusUnauthorized)
-			return
-		}
-		if err := bcrypt.CompareHashAndPassword([]byte(sh.PasswordHash), []byte(provided)); err != nil {
-			jsonError(w, "Invalid password", http.StatusUnauthorized)
-			return
-		}
+	// --- Access policy and password verification ---
+	if !allowedFrom(r, sh) {
+		notAllowedFrom(w)
+		return
+	}
+	if needsTicket(sh) && !s.passwords.validTicket(sh, r.Header.Get("X-Download-Ticket")) {
+		jsonError(w, "This share needs a "+secretsRequired(sh)+": get a ticket from POST /api/shares/"+sh.ID+"/verify", http.StatusUnauthorized)
+		return
 	}
 
-	f, err := os.Open(sh.EncryptedPath)
+	// Every response that hands out the file claims a download slot up
+	// front and counts once written, so the limit holds however often the
+	// file is fetched. Range requests are not honoured: a partial read
+	// would have to count as a whole download or escape the limit.
+	claim, err := s.store.ClaimDownload(r.Context(), sh.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, share.ErrNotFound):
+			jsonError(w, "Share not found", http.StatusNotFound)
+		case errors.Is(err, share.ErrNotYetAvailable):
+			notYetAvailable(w, sh, true)
+		case errors.Is(err, share.ErrExpired):
+			jsonError(w, "Share expired", http.StatusGone)
+		case errors.Is(err, share.ErrExhausted):
+			jsonError(w, "Download limit reached", http.StatusGone)
+		default:
+			jsonError(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	stopHold := s.store.HoldDownload(r.Context(), claim)
+	var sent int64
+	complete := false
+	defer func() {
+		stopHold()
+		s.recordDownload(r, sh.ID, sent, complete)
+		s.finishDownload(r, claim, spent(sh, sent, complete))
+	}()
+
+	blobs := s.store.Blobs()
+	f, err := blobs.Get(r.Context(), sh.BlobKey)
 	if err != nil {
 		log.Printf("cannot open encrypted file for %s: %v", sh.ID, err)
 		jsonError(w, "File not found on server", http.StatusInternalServerError)
@@ -334,19 +287,28 @@ func (s *Server) handleAPIShareFile(w http.ResponseWriter, r *http.Request, id s
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", sanitizedContentDisposition(sh.Filename))
 	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if info, err := blobs.Stat(r.Context(), sh.BlobKey); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
 
-	if _, err := io.Copy(w, f); err != nil {
+	sent, err = io.Copy(w, f)
+	if err != nil {
 		log.Printf("file stream error for %s: %v", sh.ID, err)
+		return
 	}
+	complete = r.Context().Err() == nil
 }
 
-// handleAPIShareIncrementDownloads handles POST /api/shares/{id}/downloads
+// handleAPIShareIncrementDownloads handles POST /api/shares/{id}/downloads.
+// Downloads are counted by the file endpoint when the transfer completes, so
+// this only confirms the share exists. Clients still call it because the
+// web app's API counts here instead.
 func (s *Server) handleAPIShareIncrementDownloads(w http.ResponseWriter, r *http.Request, id string) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if err := s.store.IncrementDownloads(r.Context(), id); err != nil {
+	if _, err := s.store.Get(r.Context(), id); err != nil {
 		if err == share.ErrNotFound {
 			jsonError(w, "Share not found", http.StatusNotFound)
 			return
@@ -358,6 +320,240 @@ func (s *Server) handleAPIShareIncrementDownloads(w http.ResponseWriter, r *http
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// handleAPIShareVerify handles POST /api/shares/{id}/verify with a JSON
+// body {"password": "...", "code": "..."}, giving whichever the share
+// requires. A correct password and TOTP code are exchanged for a
+// short-lived ticket for GET /api/shares/{id}/file. Wrong guesses are
+// limited per share and per client (see passwordGuard); while locked out
+// the answer is 429 with Retry-After.
+func (s *Server) handleAPIShareVerify(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFieldSize)).Decode(&input); err != nil {
+		jsonError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sh, err := s.store.Get(r.Context(), id)
+	if err != nil {
+		if err == share.ErrNotFound {
+			jsonError(w, "Share not found", http.StatusNotFound)
+			return
+		}
+		jsonError(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if sh.IsEmbargoed() {
+		notYetAvailable(w, sh, true)
+		return
+	}
+	if sh.IsExpired() {
+		jsonError(w, "Share expired", http.StatusGone)
+		return
+	}
+	if sh.IsExhausted() {
+		jsonError(w, "Download limit reached", http.StatusGone)
+		return
+	}
+	if !allowedFrom(r, sh) {
+		notAllowedFrom(w)
+		return
+	}
+	if !needsTicket(sh) {
+		jsonError(w, "Share has no password or TOTP code", http.StatusBadRequest)
+		return
+	}
+
+	ok, wait := s.passwords.checkSecrets(sh, allowlistIP(r), input.Password, input.Code)
+	if wait > 0 {
+		tooManyAttempts(w, wait)
+		jsonError(w, "Too many wrong attempts; try again in "+humanDuration(wait), http.StatusTooManyRequests)
+		return
+	}
+	if !ok {
+		jsonError(w, invalidSecrets(sh), http.StatusUnauthorized)
+		return
+	}
+
+	ticket, expires := s.passwords.issueTicket(sh)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"ticket": ticket, "expires_at": expires})
+}
+
+// secretsRequired names what downloading sh takes: "password", "TOTP
+// code" or "password and TOTP code".
+func secretsRequired(sh *share.Share) string {
+	switch {
+	case sh.PasswordHash != "" && sh.HasTOTP():
+		return "password and TOTP code"
+	case sh.HasTOTP():
+		return "TOTP code"
+	}
+	return "password"
+}
+
+// invalidSecrets is the error for a wrong password or TOTP code for sh.
+func invalidSecrets(sh *share.Share) string {
+	if sh.PasswordHash != "" && sh.HasTOTP() {
+		return "Invalid password or TOTP code"
+	}
+	return "Invalid " + secretsRequired(sh)
+}
+
+// tooManyAttempts sets Retry-After for a password lockout of wait.
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	secs := int(wait.Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+// apiShareEvent is an entry in a share's audit trail: a download (type
+// "download") or a change of its settings (type "update").
+type apiShareEvent struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Completed bool      `json:"completed,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// handleAPIShareEvents handles GET /api/shares/{id}/events: the share's
+// downloads and setting changes, oldest first. The log outlives the share,
+// so this answers for deleted shares too and only 404s when there is
+// nothing to show.
+func (s *Server) handleAPIShareEvents(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	events, err := s.store.DownloadEvents(r.Context(), id)
+	if err != nil {
+		jsonError(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	changes, err := s.store.ShareChanges(r.Context(), id)
+	if err != nil {
+		jsonError(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 && len(changes) == 0 {
+		if _, err := s.store.Get(r.Context(), id); err == share.ErrNotFound {
+			jsonError(w, "Share not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	result := make([]apiShareEvent, 0, len(events)+len(changes))
+	for _, ev := range events {
+		result = append(result, apiShareEvent{
+			Type:      "download",
+			Time:      ev.Time,
+			IPHash:    ev.IPHash,
+			UserAgent: ev.UserAgent,
+			Bytes:     ev.Bytes,
+			Completed: ev.Completed,
+		})
+	}
+	for _, c := range changes {
+		result = append(result, apiShareEvent{
+			Type:   "update",
+			Time:   c.Time,
+			Actor:  c.Actor,
+			Detail: c.Detail,
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.Before(result[j].Time) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// shareUpdateRequest is the body of PATCH /api/shares/{id}. Omitted fields
+// are left unchanged; an empty password or note removes it, and tags and
+// allowed IPs replace the share's. "totp": true creates a new TOTP secret,
+// returned once in the response, and false removes it.
+type shareUpdateRequest struct {
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxDownloads *int       `json:"max_downloads"`
+	Password     *string    `json:"password"`
+	Tags         *[]string  `json:"tags"`
+	Note         *string    `json:"note"`
+	TOTP         *bool      `json:"totp"`
+	AllowedIPs   *[]string  `json:"allowed_ips"`
+}
+
+// handleAPIShareUpdate handles PATCH /api/shares/{id}: changes the share's
+// expiry, download limit, password, tags, note or access policies, within
+// the server's policy, and returns the updated share.
+func (s *Server) handleAPIShareUpdate(w http.ResponseWriter, r *http.Request, id string) {
+	var req shareUpdateRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxFieldSize)).Decode(&req); err != nil {
+		jsonError(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u := share.ShareUpdate{ExpiresAt: req.ExpiresAt, MaxDownloads: req.MaxDownloads, Tags: req.Tags, Note: req.Note, AllowedIPs: req.AllowedIPs}
+	if req.ExpiresAt != nil {
+		if err := s.checkExpiry(*req.ExpiresAt); err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Password != nil {
+		hash := ""
+		if *req.Password != "" {
+			b, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				jsonError(w, "Hashing password", http.StatusInternalServerError)
+				return
+			}
+			hash = string(b)
+		}
+		u.PasswordHash = &hash
+	}
+	if req.TOTP != nil {
+		secret := ""
+		if *req.TOTP {
+			var err error
+			if secret, err = share.NewTOTPSecret(); err != nil {
+				jsonError(w, "Creating TOTP secret", http.StatusInternalServerError)
+				return
+			}
+		}
+		u.TOTPSecret = &secret
+	}
+
+	sh, err := s.store.Update(r.Context(), id, u, s.apiActor(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, share.ErrNotFound):
+			jsonError(w, "Share not found", http.StatusNotFound)
+		case errors.Is(err, share.ErrInvalidUpdate):
+			jsonError(w, err.Error(), http.StatusBadRequest)
+		default:
+			log.Printf("error updating share %s: %v", id, err)
+			jsonError(w, "Internal error", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("share updated: %s", sh.ID)
+	a := shareToAPI(sh)
+	if u.TOTPSecret != nil {
+		a = withTOTPSecret(a, sh)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
 // handleAPIShareDelete handles DELETE /api/shares/{id}
 func (s *Server) handleAPIShareDelete(w http.ResponseWriter, r *http.Request, id string) {
 	if err := s.store.Revoke(r.Context(), id); err != nil {
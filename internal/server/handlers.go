@@ -1,23 +1,24 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"mime"
 	"net/http"
-	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/unisoniq/durins-door/internal/crypto"
 	"github.com/unisoniq/durins-door/internal/share"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // homeData is passed to the home page template.
@@ -30,24 +31,45 @@ type homeData struct {
 type downloadData struct {
 	Share             *share.Share
 	PasswordRequired  bool
-	PasswordWrong     bool
+	CodeRequired      bool // the share has a TOTP secret
+	PasswordWrong     bool // the password or code was wrong
+	RetryIn           string // set while password attempts are locked out
 	DownloadsRemaining int
 	ExpiresIn         string
 	HumanSize         string
 	Error             string
 	CSRFToken         string
+	OpensIn           string // set while the share is embargoed
 }
 
 // adminData is passed to the admin page template.
 type adminData struct {
-	Shares  []*share.Share
+	Shares []*share.Share
+	// Token names the token the page was opened with.
 	Token   string
 	BaseURL string
+	// Tag, Search, Status and Sort echo the filter form.
+	Tag    string
+	Search string
+	Status string
+	Sort   string
+	// NextURL links to the next page, if there is one.
+	NextURL string
 }
 
 // galleryData is passed to the gallery page template.
 type galleryData struct {
-	Shares []*share.Share
+	Shares  []*share.Share
+	NextURL string
+}
+
+// expiresIn returns the time left until an expiry, or "never" for a share
+// without one.
+func expiresIn(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return humanDuration(time.Until(t))
 }
 
 func humanDuration(d time.Duration) string {
@@ -115,6 +137,18 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		s.renderError(w, r, "The door is sealed — this link does not exist.", http.StatusNotFound)
 		return
 	}
+	if !allowedFrom(r, sh) {
+		s.renderError(w, r, "The door does not open to strangers — this file cannot be downloaded from your network.", http.StatusForbidden)
+		return
+	}
+	if sh.IsEmbargoed() {
+		tooEarly(w, sh)
+		s.renderTemplateStatus(w, http.StatusTooEarly, "download.html", downloadData{
+			Share:   sh,
+			OpensIn: humanDuration(time.Until(sh.AvailableFrom)),
+		})
+		return
+	}
 	if sh.IsExpired() {
 		s.renderError(w, r, "The door has closed — this link has expired.", http.StatusGone)
 		return
@@ -127,8 +161,9 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	data := downloadData{
 		Share:              sh,
 		PasswordRequired:   sh.PasswordHash != "",
+		CodeRequired:       sh.HasTOTP(),
 		DownloadsRemaining: sh.DownloadsRemaining(),
-		ExpiresIn:          humanDuration(time.Until(sh.ExpiresAt)),
+		ExpiresIn:          expiresIn(sh.ExpiresAt),
 		HumanSize:          humanSize(sh.Size),
 	}
 
@@ -155,17 +190,32 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 
 	// Validate CSRF token
 	cookie, err := r.Cookie("csrf_token")
-	formToken := r.FormValue("csrf_token")
+	formToken := r.PostFormValue("csrf_token")
 	if err != nil || cookie.Value == "" || cookie.Value != formToken {
 		http.Error(w, "Invalid request", http.StatusForbidden)
 		return
 	}
 
-	// POST — handle password check + file delivery
-	if sh.PasswordHash != "" {
-		password := r.FormValue("password")
-		if err := bcrypt.CompareHashAndPassword([]byte(sh.PasswordHash), []byte(password)); err != nil {
-			data.PasswordWrong = true
+	// POST — handle password and code check + file delivery. Attempts
+	// count against the same limits as the API's verify endpoint.
+	if needsTicket(sh) {
+		ok, wait := s.passwords.checkSecrets(sh, allowlistIP(r), r.PostFormValue("password"), r.PostFormValue("code"))
+		if !ok {
+			if wantsCiphertext(r) {
+				if wait > 0 {
+					tooManyAttempts(w, wait)
+					jsonError(w, "Too many wrong attempts; try again in "+humanDuration(wait), http.StatusTooManyRequests)
+					return
+				}
+				jsonError(w, invalidSecrets(sh), http.StatusUnauthorized)
+				return
+			}
+			if wait > 0 {
+				tooManyAttempts(w, wait)
+				data.RetryIn = humanDuration(wait)
+			} else {
+				data.PasswordWrong = true
+			}
 			data.CSRFToken = cookie.Value
 			s.renderTemplate(w, "download.html", data)
 			return
@@ -178,16 +228,39 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 
 // handleDirectDownload handles the actual file download (GET with token or POST).
 func (s *Server) streamDecryptedFile(w http.ResponseWriter, r *http.Request, sh *share.Share) {
-	// Re-validate atomically
-	if sh.IsExpired() || sh.IsExhausted() {
-		http.Error(w, "Share no longer available", http.StatusGone)
+	// Claim the download before streaming. The store re-checks expiry and
+	// the limit as it reserves the slot, so two concurrent requests cannot
+	// both take the last download. The claim is held for as long as the
+	// transfer runs and only counts once the whole file has been written; a
+	// failed transfer gives the slot back.
+	claim, err := s.store.ClaimDownload(r.Context(), sh.ID)
+	if err != nil {
+		if errors.Is(err, share.ErrNotYetAvailable) {
+			notYetAvailable(w, sh, false)
+			return
+		}
+		if errors.Is(err, share.ErrExpired) || errors.Is(err, share.ErrExhausted) || errors.Is(err, share.ErrNotFound) {
+			http.Error(w, "Share no longer available", http.StatusGone)
+			return
+		}
+		log.Printf("error claiming download for %s: %v", sh.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-
-	// Increment downloads before streaming (prevent race condition double-download)
-	if err := s.store.IncrementDownloads(r.Context(), sh.ID); err != nil {
-		log.Printf("error incrementing downloads for %s: %v", sh.ID, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	stopHold := s.store.HoldDownload(r.Context(), claim)
+	cw := &countingWriter{ResponseWriter: w}
+	w = cw
+	complete := false
+	defer func() {
+		stopHold()
+		s.recordDownload(r, sh.ID, cw.n, complete)
+		s.finishDownload(r, claim, spent(sh, cw.n, complete))
+	}()
+
+	// Zero-knowledge shares are decrypted by the browser with the key from
+	// the link's #key fragment; the server only hands out the ciphertext.
+	if sh.ClientEncrypted {
+		complete = s.serveCiphertext(w, r, sh)
 		return
 	}
 
@@ -198,7 +271,15 @@ func (s *Server) streamDecryptedFile(w http.ResponseWriter, r *http.Request, sh
 		return
 	}
 
-	f, err := os.Open(sh.EncryptedPath)
+	// If the file was encrypted with a passphrase-derived key, a 16-byte
+	// Argon2id salt was prepended. Skip it — the derived key is already
+	// stored in the share record.
+	var offset int64
+	if sh.SaltHex != "" {
+		offset = crypto.SaltSize
+	}
+
+	f, err := s.store.Blobs().GetRange(r.Context(), sh.BlobKey, offset, -1)
 	if err != nil {
 		log.Printf("cannot open encrypted file for %s: %v", sh.ID, err)
 		http.Error(w, "File not found on server", http.StatusInternalServerError)
@@ -206,18 +287,6 @@ func (s *Server) streamDecryptedFile(w http.ResponseWriter, r *http.Request, sh
 	}
 	defer f.Close()
 
-	// If the file was encrypted with a passphrase-derived key, a 16-byte
-	// Argon2id salt was prepended. Skip it — the derived key is already
-	// stored in the share record.
-	if sh.SaltHex != "" {
-		saltBuf := make([]byte, crypto.SaltSize)
-		if _, err := io.ReadFull(f, saltBuf); err != nil {
-			log.Printf("failed to read salt header for %s: %v", sh.ID, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-	}
-
 	w.Header().Set("Content-Disposition", sanitizedContentDisposition(sh.Filename))
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -225,17 +294,145 @@ func (s *Server) streamDecryptedFile(w http.ResponseWriter, r *http.Request, sh
 	if err := crypto.DecryptStream(w, f, key); err != nil {
 		// Can't change status after headers sent; log the error
 		log.Printf("decrypt stream error for %s: %v", sh.ID, err)
+		return
+	}
+	complete = r.Context().Err() == nil
+}
+
+// tooEarly sets Retry-After for a 425 response to an embargoed share.
+func tooEarly(w http.ResponseWriter, sh *share.Share) {
+	secs := int(time.Until(sh.AvailableFrom).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+// notYetAvailable answers a request for an embargoed share with 425 Too
+// Early and Retry-After, and a JSON error body for API clients (asJSON) or
+// a plain-text one.
+func notYetAvailable(w http.ResponseWriter, sh *share.Share, asJSON bool) {
+	tooEarly(w, sh)
+	if asJSON {
+		jsonError(w, "Share not available until "+sh.AvailableFrom.UTC().Format(time.RFC3339), http.StatusTooEarly)
+		return
+	}
+	http.Error(w, "Not yet available", http.StatusTooEarly)
+}
+
+// finishDownload counts a claimed download if the transfer completed and
+// releases the claim otherwise. The request context may already be
+// cancelled (client gone), so the store update runs without it.
+func (s *Server) finishDownload(r *http.Request, claim *share.DownloadClaim, complete bool) {
+	ctx := context.WithoutCancel(r.Context())
+	if complete {
+		if err := s.store.CommitDownload(ctx, claim); err != nil {
+			log.Printf("error counting download for %s: %v", claim.ShareID, err)
+		}
+		return
+	}
+	if err := s.store.ReleaseDownload(ctx, claim); err != nil {
+		log.Printf("error releasing download for %s: %v", claim.ShareID, err)
+	}
+}
+
+// spent reports whether a transfer of sh uses up its download slot: when it
+// completed, or for a burn share, as soon as it handed out any data, so a
+// burn share cannot be read again and again through transfers cut short.
+func spent(sh *share.Share, sent int64, complete bool) bool {
+	return complete || sh.Burn && sent > 0
+}
+
+// recordDownload adds a transfer to the download log. Like finishDownload
+// it runs after the response, so it does not use the request context.
+func (s *Server) recordDownload(r *http.Request, shareID string, sent int64, complete bool) {
+	if s.eventRetention == 0 {
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	ipHash, err := s.store.HashIP(ctx, clientIP(r))
+	if err != nil {
+		log.Printf("error hashing client IP for %s: %v", shareID, err)
+	}
+	ev := &share.DownloadEvent{
+		ShareID:   shareID,
+		IPHash:    ipHash,
+		UserAgent: r.UserAgent(),
+		Bytes:     sent,
+		Completed: complete,
+	}
+	if err := s.store.RecordDownload(ctx, ev); err != nil {
+		log.Printf("error logging download of %s: %v", shareID, err)
+	}
+}
+
+// countingWriter counts the body bytes written to a response.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// serveCiphertext streams a client-encrypted blob unmodified for the download
+// page to decrypt in the browser. It reports whether the whole blob was sent.
+func (s *Server) serveCiphertext(w http.ResponseWriter, r *http.Request, sh *share.Share) bool {
+	f, err := s.store.Blobs().Get(r.Context(), sh.BlobKey)
+	if err != nil {
+		log.Printf("cannot open encrypted file for %s: %v", sh.ID, err)
+		http.Error(w, "File not found on server", http.StatusInternalServerError)
+		return false
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if fi, err := s.store.Blobs().Stat(r.Context(), sh.BlobKey); err == nil {
+		w.Header().Set("Content-Length", fmt.Sprint(fi.Size))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("ciphertext stream error for %s: %v", sh.ID, err)
+		return false
+	}
+	return r.Context().Err() == nil
+}
+
+// wantsCiphertext reports whether a download POST came from the page's own
+// fetch (zero-knowledge shares) rather than a plain form submission.
+func wantsCiphertext(r *http.Request) bool {
+	return r.Header.Get("Accept") == "application/octet-stream"
+}
+
+// handleGalleryRedirect sends the old public /gallery URL to the gallery's
+// place behind the dashboard login, keeping the query (cursor, token).
+func (s *Server) handleGalleryRedirect(w http.ResponseWriter, r *http.Request) {
+	target := "/admin/gallery"
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
 	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
 }
 
-// handleGallery renders the public gallery of active shares.
+// handleGallery renders the gallery of active shares, a page at a
+// time. Its links hand out the shares, so it is behind the list scope.
 func (s *Server) handleGallery(w http.ResponseWriter, r *http.Request) {
-	shares, err := s.store.List(r.Context())
+	page, err := s.store.List(r.Context(), share.ListQuery{
+		ListFilter: share.ListFilter{Status: share.StatusActive},
+		Limit:      galleryPageSize,
+		Cursor:     r.URL.Query().Get("cursor"),
+	})
+	if errors.Is(err, share.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	data := galleryData{Shares: shares}
+	data := galleryData{Shares: page.Shares, NextURL: nextPageURL(r, page.Next)}
 	s.renderTemplate(w, "gallery.html", data)
 }
 
@@ -246,7 +443,16 @@ func (s *Server) handleGuide(w http.ResponseWriter, r *http.Request) {
 
 // handleAdmin renders the admin dashboard.
 func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
-	shares, err := s.store.List(r.Context())
+	q, err := listQuery(r, adminPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := s.store.List(r.Context(), q)
+	if errors.Is(err, share.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -257,9 +463,14 @@ func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
 		scheme = "https"
 	}
 	data := adminData{
-		Shares:  shares,
-		Token:   s.adminToken,
+		Shares:  page.Shares,
+		Token:   s.tokenLabel(r),
 		BaseURL: fmt.Sprintf("%s://%s", scheme, r.Host),
+		Tag:     strings.Join(q.Tags, ","),
+		Search:  q.Search,
+		Status:  q.Status,
+		Sort:    q.Sort,
+		NextURL: nextPageURL(r, page.Next),
 	}
 	s.renderTemplate(w, "admin.html", data)
 }
@@ -289,35 +500,135 @@ func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-// handleAPIShares returns JSON list of active shares.
+// Page sizes for share listings. The API's limit parameter can ask for up
+// to maxListLimit.
+const (
+	apiPageSize     = 100
+	adminPageSize   = 50
+	galleryPageSize = 48
+	maxListLimit    = 1000
+)
+
+// listQuery reads a share listing query from the request's parameters:
+//
+//	tag                         repeatable, or a comma-separated list
+//	search, status, sort        as in share.ListQuery
+//	min_size, max_size          bytes
+//	created_after, created_before,
+//	expires_after, expires_before  RFC 3339 times
+//	limit                       page size, defaultLimit if absent
+//	cursor                      from a previous page
+func listQuery(r *http.Request, defaultLimit int) (share.ListQuery, error) {
+	v := r.URL.Query()
+	var tags []string
+	for _, t := range v["tag"] {
+		tags = append(tags, strings.Split(t, ",")...)
+	}
+	filter, err := share.ParseListFilter(tags, v.Get("search"), v.Get("status"))
+	if err != nil {
+		return share.ListQuery{}, err
+	}
+	for name, dst := range map[string]*int64{"min_size": &filter.MinSize, "max_size": &filter.MaxSize} {
+		if s := v.Get(name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				return share.ListQuery{}, fmt.Errorf("%s must be a number of bytes", name)
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range map[string]*time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+		"expires_after":  &filter.ExpiresAfter,
+		"expires_before": &filter.ExpiresBefore,
+	} {
+		if s := v.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return share.ListQuery{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*dst = t
+		}
+	}
+	sort, err := share.ParseSort(v.Get("sort"))
+	if err != nil {
+		return share.ListQuery{}, err
+	}
+	limit := defaultLimit
+	if s := v.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return share.ListQuery{}, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+	}
+	return share.ListQuery{ListFilter: filter, Sort: sort, Limit: limit, Cursor: v.Get("cursor")}, nil
+}
+
+// nextPageURL returns the request's URL with its cursor replaced by next,
+// or "" if there is no next page.
+func nextPageURL(r *http.Request, next string) string {
+	if next == "" {
+		return ""
+	}
+	v := r.URL.Query()
+	v.Set("cursor", next)
+	return r.URL.Path + "?" + v.Encode()
+}
+
+// handleAPIShares returns a JSON list of shares not in the trash, filtered,
+// sorted and paged by the query parameters described at listQuery. When
+// there are more shares, the X-Next-Cursor header holds the cursor for the
+// next page.
 func (s *Server) handleAPIShares(w http.ResponseWriter, r *http.Request) {
-	shares, err := s.store.List(r.Context())
+	q, err := listQuery(r, apiPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := s.store.List(r.Context(), q)
+	if errors.Is(err, share.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	type shareJSON struct {
-		ID                 string    `json:"id"`
-		Filename           string    `json:"filename"`
-		Size               int64     `json:"size"`
-		CreatedAt          time.Time `json:"created_at"`
-		ExpiresAt          time.Time `json:"expires_at"`
-		Downloads          int       `json:"downloads"`
-		MaxDownloads       int       `json:"max_downloads"`
-		PasswordProtected  bool      `json:"password_protected"`
-	}
-	result := make([]shareJSON, 0, len(shares))
-	for _, sh := range shares {
+		ID                 string     `json:"id"`
+		Filename           string     `json:"filename"`
+		Size               int64      `json:"size"`
+		CreatedAt          time.Time  `json:"created_at"`
+		ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+		Downloads          int        `json:"downloads"`
+		MaxDownloads       int        `json:"max_downloads"`
+		PasswordProtected  bool       `json:"password_protected"`
+		Status             string     `json:"status"`
+		Tags               []string   `json:"tags,omitempty"`
+		Note               string     `json:"note,omitempty"`
+	}
+	result := make([]shareJSON, 0, len(page.Shares))
+	for _, sh := range page.Shares {
 		result = append(result, shareJSON{
 			ID:                sh.ID,
 			Filename:          sh.Filename,
 			Size:              sh.Size,
 			CreatedAt:         sh.CreatedAt,
-			ExpiresAt:         sh.ExpiresAt,
 			Downloads:         sh.Downloads,
 			MaxDownloads:      sh.MaxDownloads,
 			PasswordProtected: sh.PasswordHash != "",
+			Status:            sh.Status(),
+			Tags:              sh.Tags,
+			Note:              sh.Note,
 		})
+		if !sh.ExpiresAt.IsZero() {
+			t := sh.ExpiresAt
+			result[len(result)-1].ExpiresAt = &t
+		}
+	}
+	if page.Next != "" {
+		w.Header().Set("X-Next-Cursor", page.Next)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
@@ -325,10 +636,17 @@ func (s *Server) handleAPIShares(w http.ResponseWriter, r *http.Request) {
 
 // renderTemplate renders a named template with data.
 func (s *Server) renderTemplate(w http.ResponseWriter, name string, data any) {
+	s.renderTemplateStatus(w, http.StatusOK, name, data)
+}
+
+// renderTemplateStatus renders a named template with data and the given
+// status code. The headers go out with the status, so they are set first.
+func (s *Server) renderTemplateStatus(w http.ResponseWriter, status int, name string, data any) {
 	tmpl, err := template.New(name).Funcs(template.FuncMap{
 		"humanDuration": humanDuration,
 		"humanSize":     humanSize,
 		"until":         func(t time.Time) time.Duration { return time.Until(t) },
+		"expiresIn":     expiresIn,
 		"isExpired":     func(sh *share.Share) bool { return sh.IsExpired() },
 		"isExhausted":   func(sh *share.Share) bool { return sh.IsExhausted() },
 		// add is used by the admin template for simple integer arithmetic.
@@ -343,6 +661,7 @@ func (s *Server) renderTemplate(w http.ResponseWriter, name string, data any) {
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
 	if err := tmpl.Execute(w, data); err != nil {
 		log.Printf("template execute error for %s: %v", name, err)
 	}
@@ -350,9 +669,8 @@ func (s *Server) renderTemplate(w http.ResponseWriter, name string, data any) {
 
 // renderError renders a themed error page.
 func (s *Server) renderError(w http.ResponseWriter, r *http.Request, msg string, status int) {
-	w.WriteHeader(status)
 	data := downloadData{Error: msg}
-	s.renderTemplate(w, "download.html", data)
+	s.renderTemplateStatus(w, status, "download.html", data)
 }
 
 // handleFileStream handles streaming decryption for direct download links.
@@ -368,6 +686,14 @@ func (s *Server) handleFileStream(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Not found", http.StatusNotFound)
 		return
 	}
+	if !allowedFrom(r, sh) {
+		http.Error(w, "Not allowed from your network address", http.StatusForbidden)
+		return
+	}
+	if sh.IsEmbargoed() {
+		notYetAvailable(w, sh, false)
+		return
+	}
 	if sh.IsExpired() {
 		http.Error(w, "Expired", http.StatusGone)
 		return
@@ -376,8 +702,14 @@ func (s *Server) handleFileStream(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Download limit reached", http.StatusGone)
 		return
 	}
-	if sh.PasswordHash != "" {
-		http.Error(w, "Password required — use the download page", http.StatusForbidden)
+	// Only the download page can decrypt a zero-knowledge share. A redirect
+	// without its own fragment keeps the browser's #key intact.
+	if sh.ClientEncrypted {
+		http.Redirect(w, r, "/d/"+sh.ID, http.StatusSeeOther)
+		return
+	}
+	if needsTicket(sh) {
+		http.Error(w, "This share needs a "+secretsRequired(sh)+" — use the download page", http.StatusForbidden)
 		return
 	}
 
@@ -1,12 +1,20 @@
 package server
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/unisoniq/durins-door/internal/share"
 )
 
 // loggingMiddleware logs HTTP requests.
@@ -84,7 +92,7 @@ func (rl *rateLimiter) allow(ip string) bool {
 
 func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := clientIP(r)
+		ip := allowlistIP(r)
 		if !rl.allow(ip) {
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
@@ -93,9 +101,10 @@ func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
 	})
 }
 
-// clientIP extracts the client IP from the request, checking X-Forwarded-For
-// and X-Real-IP headers (for reverse proxy setups) before falling back to
-// RemoteAddr. The port is stripped so all connections from one IP share a bucket.
+// clientIP extracts the client IP recorded in the download log, checking
+// X-Forwarded-For and X-Real-IP headers (for reverse proxy setups) before
+// falling back to RemoteAddr. The port is stripped. Any client can send
+// those headers, so limits use allowlistIP instead.
 func clientIP(r *http.Request) string {
 	// Check X-Forwarded-For first (may contain "client, proxy1, proxy2")
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
@@ -117,6 +126,35 @@ func clientIP(r *http.Request) string {
 	return host
 }
 
+// allowlistIP returns the client address that share IP allowlists are
+// checked against, password attempts are counted for and rate limits are
+// kept by. It is clientIP,
+// except that the forwarding headers, which any client can send, are
+// believed only from a proxy or tunnel on this host, and then the last
+// X-Forwarded-For entry, the one that proxy added, is used.
+func allowlistIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if addr, err := netip.ParseAddr(host); err != nil || !addr.Unmap().IsLoopback() {
+		return host
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(xff[len(xff)-1], ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	if xri := r.Header.Get("X-Real-IP"); xri != "" {
+		return strings.TrimSpace(xri)
+	}
+	return host
+}
+
+// allowedFrom reports whether sh's IP allowlist admits the client of r.
+func allowedFrom(r *http.Request, sh *share.Share) bool {
+	return sh.AllowsIP(allowlistIP(r))
+}
+
 // securityHeaders adds standard security headers to every response.
 func securityHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -132,44 +170,263 @@ func securityHeaders(next http.Handler) http.Handler {
 	})
 }
 
-// adminAuthMiddleware enforces bearer token or cookie-based authentication.
-func adminAuthMiddleware(token string, next http.Handler) http.Handler {
+// bearerToken returns the token from an "Authorization: Bearer …" header, or
+// "" if none was sent.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+// authKey is the context key for the API token a request was
+// authenticated with.
+type authKey struct{}
+
+// authedToken returns the API token that requireScope admitted r with, or
+// nil if it was not admitted that way. The server's admin token appears as
+// an unnamed token with the admin scope.
+func authedToken(r *http.Request) *share.APIToken {
+	t, _ := r.Context().Value(authKey{}).(*share.APIToken)
+	return t
+}
+
+// authenticate resolves a presented credential: the server's admin token
+// or a named API token. It returns nil for an unknown, revoked or expired
+// one.
+func (s *Server) authenticate(ctx context.Context, cred string) (*share.APIToken, error) {
+	if cred == "" {
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(cred), []byte(s.adminToken)) == 1 {
+		return &share.APIToken{Scopes: []string{share.ScopeAdmin}}, nil
+	}
+	t, err := s.store.AuthenticateToken(ctx, cred)
+	if errors.Is(err, share.ErrInvalidToken) {
+		return nil, nil
+	}
+	return t, err
+}
+
+// requireScope admits requests whose token grants any of scopes. The token
+// is taken from the Authorization header or the admin_session cookie. A
+// token passed as ?token= is moved into that cookie and the request
+// redirected to the clean URL, so the dashboard can be opened from a link.
+func (s *Server) requireScope(next http.Handler, scopes ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if token == "" {
-			http.Error(w, "Admin not configured", http.StatusForbidden)
+		granted := func(t *share.APIToken) bool {
+			return slices.ContainsFunc(scopes, t.HasScope)
+		}
+
+		creds := []string{bearerToken(r)}
+		if cookie, err := r.Cookie("admin_session"); err == nil {
+			creds = append(creds, cookie.Value)
+		}
+		for _, cred := range creds {
+			t, err := s.authenticate(r.Context(), cred)
+			if err != nil {
+				log.Printf("checking API token: %v", err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			if t == nil {
+				continue
+			}
+			if !granted(t) {
+				http.Error(w, fmt.Sprintf("Forbidden: token lacks the %s scope", strings.Join(scopes, " or ")), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authKey{}, t)))
 			return
 		}
-		// Check Authorization header
-		auth := r.Header.Get("Authorization")
-		if auth == "Bearer "+token {
-			next.ServeHTTP(w, r)
+
+		// If a token is provided as query param, set a session cookie and
+		// redirect to the clean URL (so the token doesn't linger in browser
+		// history/logs).
+		if token := r.URL.Query().Get("token"); token != "" {
+			t, err := s.authenticate(r.Context(), token)
+			if err != nil {
+				log.Printf("checking API token: %v", err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			if t != nil && granted(t) {
+				http.SetCookie(w, &http.Cookie{
+					Name:     "admin_session",
+					Value:    token,
+					Path:     "/admin",
+					HttpOnly: true,
+					SameSite: http.SameSiteStrictMode,
+					MaxAge:   3600, // 1 hour
+				})
+				cleanURL := *r.URL
+				q := cleanURL.Query()
+				q.Del("token")
+				cleanURL.RawQuery = q.Encode()
+				http.Redirect(w, r, cleanURL.String(), http.StatusSeeOther)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="Durin's Door Admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// ownerRoute returns the share ID of a request that a share's owner token
+// may make: GET, PATCH or DELETE on /api/shares/{id}, or GET on
+// /api/shares/{id}/events. Anything else needs an API token, unless it is a
+// recipient route.
+func ownerRoute(r *http.Request) (string, bool) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/shares/")
+	if id, ok := strings.CutSuffix(rest, "/events"); ok {
+		return id, r.Method == http.MethodGet && id != "" && !strings.Contains(id, "/")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodPatch, http.MethodDelete:
+		return rest, rest != "" && !strings.Contains(rest, "/")
+	}
+	return "", false
+}
+
+// shareScopes returns the scopes that admit a request to the per-share API:
+// metadata for list or download, the file and password checks for
+// download, the history for list, and changes for admin.
+func shareScopes(r *http.Request) []string {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/shares/")
+	switch {
+	case strings.HasSuffix(rest, "/file"), strings.HasSuffix(rest, "/downloads"), strings.HasSuffix(rest, "/verify"):
+		return []string{share.ScopeDownload}
+	case strings.HasSuffix(rest, "/events"):
+		return []string{share.ScopeList}
+	case r.Method == http.MethodGet:
+		return []string{share.ScopeList, share.ScopeDownload}
+	}
+	return []string{share.ScopeAdmin}
+}
+
+// recipientRoute reports whether r is one of the requests a recipient
+// makes, which the unguessable share or handshake ID authorises by itself:
+// reading a share's metadata, checking its password, fetching its file and
+// bumping its download counter, or creating a handshake and polling it.
+// Looking a handshake up by its short pairing code and completing it are
+// for senders, who need a token to upload anyway.
+func recipientRoute(r *http.Request) bool {
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/api/shares/"); ok {
+		id, sub, _ := strings.Cut(rest, "/")
+		if id == "" {
+			return false
+		}
+		switch sub {
+		case "":
+			return r.Method == http.MethodGet
+		case "file":
+			return r.Method == http.MethodGet
+		case "downloads", "verify":
+			return r.Method == http.MethodPost
+		}
+		return false
+	}
+	if r.URL.Path == "/api/handshakes" {
+		return r.Method == http.MethodPost
+	}
+	if id, ok := strings.CutPrefix(r.URL.Path, "/api/handshakes/"); ok {
+		return r.Method == http.MethodGet && id != "" && !strings.Contains(id, "/")
+	}
+	return false
+}
+
+// allowRecipients serves recipient routes (see recipientRoute) to anyone,
+// rate-limited, and requires a token with one of scopes for the rest. On a
+// private server every route needs the token. A valid token sent to a
+// recipient route is still recognised, so handlers can show its holder
+// more; an unknown one is ignored.
+func (s *Server) allowRecipients(next http.Handler, scopes ...string) http.Handler {
+	guarded := s.requireScope(next, scopes...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.private || !recipientRoute(r) {
+			guarded.ServeHTTP(w, r)
 			return
 		}
-		// Check session cookie (set after first header-based auth)
-		if cookie, err := r.Cookie("admin_session"); err == nil && cookie.Value == token {
-			next.ServeHTTP(w, r)
+		if !s.recipientLimiter.allow(allowlistIP(r)) {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
-		// If token is provided as query param, set a session cookie and redirect
-		// to the clean URL (so the token doesn't linger in browser history/logs).
-		if r.URL.Query().Get("token") == token {
-			http.SetCookie(w, &http.Cookie{
-				Name:     "admin_session",
-				Value:    token,
-				Path:     "/admin",
-				HttpOnly: true,
-				SameSite: http.SameSiteStrictMode,
-				MaxAge:   3600, // 1 hour
-			})
-			// Redirect to the same path without the token query param
-			cleanURL := *r.URL
-			q := cleanURL.Query()
-			q.Del("token")
-			cleanURL.RawQuery = q.Encode()
-			http.Redirect(w, r, cleanURL.String(), http.StatusSeeOther)
+		t, err := s.authenticate(r.Context(), bearerToken(r))
+		if err != nil {
+			log.Printf("checking API token: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
 		}
-		w.Header().Set("WWW-Authenticate", `Bearer realm="Durin's Door Admin"`)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if t != nil {
+			r = r.WithContext(context.WithValue(r.Context(), authKey{}, t))
+		}
+		next.ServeHTTP(w, r)
 	})
 }
+
+// ownerKey is the context key marking a request made with the owner token
+// of the share it addresses.
+type ownerKey struct{}
+
+// isOwner reports whether r was admitted with its share's owner token.
+func isOwner(r *http.Request) bool {
+	owner, _ := r.Context().Value(ownerKey{}).(bool)
+	return owner
+}
+
+// shareAuthMiddleware guards the per-share API. It admits a share's owner
+// token as a bearer token for that share's management routes (see
+// ownerRoute), recipients without a token (see allowRecipients), and API
+// tokens with the scope the route needs (see shareScopes).
+func (s *Server) shareAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if id, ok := ownerRoute(r); ok && token != "" && token != s.adminToken {
+			owner, err := s.store.CheckOwnerToken(r.Context(), id, token)
+			if err != nil {
+				log.Printf("checking owner token for %s: %v", id, err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			if owner {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ownerKey{}, true)))
+				return
+			}
+		}
+		s.allowRecipients(next, shareScopes(r)...).ServeHTTP(w, r)
+	})
+}
+
+// canManage reports whether r may see a share's private details (labels,
+// storage path): it came from the share's owner or a token with the list
+// scope.
+func canManage(r *http.Request) bool {
+	t := authedToken(r)
+	return isOwner(r) || (t != nil && t.HasScope(share.ScopeList))
+}
+
+// apiActor names who made an authorised API request, for the share's
+// change history: its owner, a named API token, or the server's admin
+// token ("api").
+func (s *Server) apiActor(r *http.Request) string {
+	t := authedToken(r)
+	switch {
+	case isOwner(r):
+		return "owner"
+	case t == nil || t.Name == "":
+		return "api"
+	default:
+		return "token:" + t.Name
+	}
+}
+
+// tokenLabel identifies the token r was admitted with on the dashboard: an
+// API token's name, or the server's admin token, of which the page shows
+// only the start.
+func (s *Server) tokenLabel(r *http.Request) string {
+	if t := authedToken(r); t != nil && t.Name != "" {
+		return t.Name
+	}
+	return s.adminToken
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unisoniq/durins-door/internal/share"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password and TOTP code attempts are limited per share and per client IP,
+// so a share cannot be guessed at from many addresses, nor many shares
+// from one. The first few failures are free; each one after that locks the
+// key out for twice as long as the last, and a long run of them for
+// passwordLockout. Counters live in memory and are forgotten after
+// passwordForgetAfter without a failure.
+const (
+	passwordFreeAttempts = 3
+	passwordBaseDelay    = 2 * time.Second
+	passwordMaxDelay     = 15 * time.Minute
+	passwordLockoutAfter = 20
+	passwordLockout      = time.Hour
+	passwordForgetAfter  = 24 * time.Hour
+)
+
+// ticketTTL is how long a download ticket from a correct password and code
+// lasts: long enough to fetch the file, and resume it once or twice.
+const ticketTTL = 10 * time.Minute
+
+// attempts counts consecutive password failures for one key.
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// passwordGuard tracks password and code failures and issues download
+// tickets.
+type passwordGuard struct {
+	mu   sync.Mutex
+	keys map[string]*attempts
+
+	// usedSteps holds, per share ID, the TOTP time step of the last code
+	// accepted, so a code cannot be replayed.
+	usedSteps map[string]int64
+
+	// ticketKey signs tickets. It is made per process, so a restart
+	// invalidates outstanding tickets; they are short-lived anyway.
+	ticketKey []byte
+}
+
+func newPasswordGuard() *passwordGuard {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &passwordGuard{keys: make(map[string]*attempts), usedSteps: make(map[string]int64), ticketKey: key}
+}
+
+// guardKeys returns the counters a password attempt on shareID from ip
+// is charged to.
+func guardKeys(shareID, ip string) []string {
+	return []string{"share:" + shareID, "ip:" + ip}
+}
+
+// reserve charges an attempt to keys as a failure before it is checked,
+// so parallel attempts see each other and cannot all slip in before the
+// first one fails; a correct one is refunded by succeed. If any of keys is
+// locked out, nothing is charged and reserve returns how long the caller
+// must wait.
+func (g *passwordGuard) reserve(keys ...string) (wait time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for _, k := range keys {
+		if a, ok := g.keys[k]; ok && a.lockedUntil.After(now) {
+			wait = max(wait, a.lockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, k := range keys {
+		a, ok := g.keys[k]
+		if !ok {
+			a = &attempts{}
+			g.keys[k] = a
+		}
+		a.failures++
+		a.lastFailure = now
+		a.lockedUntil = now.Add(backoff(a.failures))
+	}
+	return 0
+}
+
+// succeed clears the counters of keys after a correct password.
+func (g *passwordGuard) succeed(keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, k := range keys {
+		delete(g.keys, k)
+	}
+}
+
+// purge forgets counters whose last failure is older than
+// passwordForgetAfter and whose lockout is over, and used TOTP steps too
+// old to be accepted again anyway.
+func (g *passwordGuard) purge() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for k, a := range g.keys {
+		if now.Sub(a.lastFailure) > passwordForgetAfter && now.After(a.lockedUntil) {
+			delete(g.keys, k)
+		}
+	}
+	for id, step := range g.usedSteps {
+		if step < share.OldestTOTPStep(now) {
+			delete(g.usedSteps, id)
+		}
+	}
+}
+
+// backoff returns the lockout after the given number of consecutive
+// failures.
+func backoff(failures int) time.Duration {
+	switch {
+	case failures >= passwordLockoutAfter:
+		return passwordLockout
+	case failures < passwordFreeAttempts:
+		return 0
+	}
+	d := passwordBaseDelay << (failures - passwordFreeAttempts)
+	return min(d, passwordMaxDelay)
+}
+
+// needsTicket reports whether downloading sh takes a ticket: it has a
+// password, a TOTP secret or both.
+func needsTicket(sh *share.Share) bool {
+	return sh.PasswordHash != "" || sh.HasTOTP()
+}
+
+// checkSecrets verifies the password and TOTP code sh requires, on behalf
+// of the client at ip and subject to the attempt limits; a wrong password
+// and a wrong code count alike. It returns a non-zero wait, and checks
+// nothing, while the share or the client is locked out. A code is
+// accepted once: replaying it fails.
+func (g *passwordGuard) checkSecrets(sh *share.Share, ip, password, code string) (ok bool, wait time.Duration) {
+	keys := guardKeys(sh.ID, ip)
+	if wait := g.reserve(keys...); wait > 0 {
+		return false, wait
+	}
+	ok = true
+	if sh.PasswordHash != "" {
+		ok = bcrypt.CompareHashAndPassword([]byte(sh.PasswordHash), []byte(password)) == nil
+	}
+	var step int64
+	if ok && sh.HasTOTP() {
+		step, ok = sh.CheckTOTP(code, time.Now())
+	}
+	if !ok || !g.useStep(sh, step) {
+		return false, 0 // already charged by reserve
+	}
+	g.succeed(keys...)
+	return true, 0
+}
+
+// useStep records that sh's TOTP code for step has been used, and reports
+// false if that step, or a later one, already was. Shares without TOTP
+// always pass.
+func (g *passwordGuard) useStep(sh *share.Share, step int64) bool {
+	if !sh.HasTOTP() {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if last, ok := g.usedSteps[sh.ID]; ok && step <= last {
+		return false
+	}
+	g.usedSteps[sh.ID] = step
+	return true
+}
+
+// A ticket is "<expiry>.<mac>": the Unix expiry time and an HMAC of the
+// share ID, that time, the share's password hash and its TOTP secret, so
+// changing or removing either voids outstanding tickets.
+
+// issueTicket returns a download ticket for sh and its expiry.
+func (g *passwordGuard) issueTicket(sh *share.Share) (string, time.Time) {
+	expires := time.Now().Add(ticketTTL).Truncate(time.Second)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + g.ticketMAC(sh, exp), expires
+}
+
+// validTicket reports whether ticket was issued for sh and has not expired.
+func (g *passwordGuard) validTicket(sh *share.Share, ticket string) bool {
+	exp, mac, ok := strings.Cut(ticket, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(g.ticketMAC(sh, exp)))
+}
+
+func (g *passwordGuard) ticketMAC(sh *share.Share, exp string) string {
+	m := hmac.New(sha256.New, g.ticketKey)
+	m.Write([]byte(sh.ID + "\n" + exp + "\n" + sh.PasswordHash + "\n" + sh.TOTPSecret))
+	return hex.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/unisoniq/durins-door/internal/share"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parallel guesses must not all get past the lockout check before the
+// first of them fails: no more than the free attempts are ever checked.
+// The real bcrypt cost keeps each check slow enough for them to overlap.
+func TestPasswordLockoutHoldsUnderConcurrency(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("mellon"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		ip   func(i int) string
+	}{
+		{"one client", func(int) string { return "192.0.2.1" }},
+		{"many clients", func(i int) string { return fmt.Sprintf("192.0.2.%d", i) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newPasswordGuard()
+			sh := &share.Share{ID: "0123456789abcdef", PasswordHash: string(hash)}
+
+			var checked atomic.Int32
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := range 50 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					if ok, wait := g.checkSecrets(sh, tc.ip(i), "wrong", ""); ok {
+						t.Error("wrong password accepted")
+					} else if wait == 0 {
+						checked.Add(1)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			n := checked.Load()
+			if n > passwordFreeAttempts {
+				t.Errorf("%d guesses checked, want at most %d", n, passwordFreeAttempts)
+			}
+			// Check the lockout itself rather than with another guess: a
+			// slow machine may take longer than the lockout lasts.
+			if a := g.keys["share:"+sh.ID]; a == nil || a.failures != int(n) || !a.lockedUntil.After(a.lastFailure) {
+				t.Errorf("share counters %+v after %d failed guesses, want as many failures and a lockout", a, n)
+			}
+		})
+	}
+}
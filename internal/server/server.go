@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/unisoniq/durins-door/internal/share"
@@ -19,6 +20,18 @@ import (
 type Server struct {
 	store      *share.Store
 	adminToken string
+	private    bool
+
+	recipientLimiter *rateLimiter
+	passwords        *passwordGuard
+	uploadLocks      sync.Map // resumable upload ID -> *sync.Mutex
+
+	maxUploadSize     int64
+	tokenUploadLimits map[string]int64
+	eventRetention    time.Duration
+	maxExpiry         time.Duration
+	trashRetention    time.Duration
+
 	mux        *http.ServeMux
 	httpServer *http.Server
 	templates  embed.FS
@@ -32,16 +45,51 @@ type Config struct {
 	AdminToken string
 	Port       int
 	WebFS      embed.FS
+
+	// Private requires an API token on the recipient endpoints too, which
+	// otherwise need only the share or handshake ID.
+	Private bool
+
+	// MaxUploadSize is the default per-upload limit in bytes (0 = unlimited).
+	MaxUploadSize int64
+	// TokenUploadLimits overrides MaxUploadSize for specific bearer tokens,
+	// given as the token itself or an API token's name.
+	TokenUploadLimits map[string]int64
+	// MaxExpiry caps how far in the future a share's expiry may be set,
+	// on upload or update (0 = no limit).
+	MaxExpiry time.Duration
+	// TrashRetention is how long revoked shares stay restorable before
+	// the cleanup loop deletes them and their files (0 = at the next run).
+	TrashRetention time.Duration
+	// EventRetention is how long download log entries are kept. 0 turns
+	// the log off and clears existing entries.
+	EventRetention time.Duration
 }
 
+// Defaults for servers started without --event-retention,
+// --trash-retention or --max-upload-size.
+const (
+	DefaultEventRetention = 30 * 24 * time.Hour
+	DefaultTrashRetention = 7 * 24 * time.Hour
+	DefaultMaxUploadSize  = 50 << 20 // 50 MB
+)
+
 // New creates and configures a new Server.
 func New(cfg Config) *Server {
 	s := &Server{
 		store:      cfg.Store,
 		adminToken: cfg.AdminToken,
+		private:    cfg.Private,
+		passwords:  newPasswordGuard(),
 		mux:        http.NewServeMux(),
 		templates:  cfg.WebFS,
 		port:       cfg.Port,
+
+		maxUploadSize:     cfg.MaxUploadSize,
+		tokenUploadLimits: cfg.TokenUploadLimits,
+		eventRetention:    cfg.EventRetention,
+		maxExpiry:         cfg.MaxExpiry,
+		trashRetention:    cfg.TrashRetention,
 	}
 
 	// Build a sub-FS for static assets.
@@ -60,6 +108,8 @@ func New(cfg Config) *Server {
 
 func (s *Server) registerRoutes() {
 	rl := newRateLimiter(60, time.Minute)
+	// Recipients poll handshakes every two seconds, so they get more room.
+	s.recipientLimiter = newRateLimiter(120, time.Minute)
 
 	// Static assets
 	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(s.staticFS))))
@@ -68,24 +118,32 @@ func (s *Server) registerRoutes() {
 	s.mux.Handle("/", loggingMiddleware(rl.middleware(http.HandlerFunc(s.handleHome))))
 	s.mux.Handle("/d/", loggingMiddleware(rl.middleware(http.HandlerFunc(s.handleDownload))))
 	s.mux.Handle("/dl/", loggingMiddleware(rl.middleware(http.HandlerFunc(s.handleFileStream))))
-	s.mux.Handle("/gallery", loggingMiddleware(rl.middleware(http.HandlerFunc(s.handleGallery))))
+	s.mux.Handle("/gallery", loggingMiddleware(rl.middleware(http.HandlerFunc(s.handleGalleryRedirect))))
 	s.mux.Handle("/guide", loggingMiddleware(rl.middleware(http.HandlerFunc(s.handleGuide))))
 
 	// Admin routes (token-protected)
-	adminHandler := adminAuthMiddleware(s.adminToken, http.HandlerFunc(s.handleAdmin))
-	revokeHandler := adminAuthMiddleware(s.adminToken, http.HandlerFunc(s.handleRevoke))
-	apiSharesHandler := adminAuthMiddleware(s.adminToken, http.HandlerFunc(s.handleAPIShares))
+	adminHandler := s.requireScope(http.HandlerFunc(s.handleAdmin), share.ScopeAdmin)
+	revokeHandler := s.requireScope(http.HandlerFunc(s.handleRevoke), share.ScopeAdmin)
+	apiSharesHandler := s.requireScope(http.HandlerFunc(s.handleAPIShares), share.ScopeList)
+	// The gallery links every share, so it needs the same scope as the
+	// list it shows.
+	galleryHandler := s.requireScope(http.HandlerFunc(s.handleGallery), share.ScopeList)
 
 	s.mux.Handle("/admin", loggingMiddleware(adminHandler))
 	s.mux.Handle("/admin/", loggingMiddleware(adminHandler))
 	s.mux.Handle("/admin/revoke/", loggingMiddleware(revokeHandler))
+	s.mux.Handle("/admin/gallery", loggingMiddleware(galleryHandler))
 	s.mux.Handle("/api/shares", loggingMiddleware(apiSharesHandler))
 
-	// JSON API routes (token-protected)
-	s.mux.Handle("/api/upload", loggingMiddleware(adminAuthMiddleware(s.adminToken, http.HandlerFunc(s.handleAPIUpload))))
-	s.mux.Handle("/api/shares/", loggingMiddleware(adminAuthMiddleware(s.adminToken, http.HandlerFunc(s.handleAPIShareGet))))
-	s.mux.Handle("/api/handshakes", loggingMiddleware(adminAuthMiddleware(s.adminToken, http.HandlerFunc(s.handleAPIHandshakes))))
-	s.mux.Handle("/api/handshakes/", loggingMiddleware(adminAuthMiddleware(s.adminToken, http.HandlerFunc(s.handleAPIHandshakeByID))))
+	// JSON API routes (token-protected by scope; recipient routes are open
+	// to holders of the share or handshake ID)
+	s.mux.Handle("/api/upload", loggingMiddleware(s.requireScope(http.HandlerFunc(s.handleAPIUpload), share.ScopeUpload)))
+	s.mux.Handle("/api/upload/raw", loggingMiddleware(s.requireScope(http.HandlerFunc(s.handleAPIUploadRaw), share.ScopeUpload)))
+	s.mux.Handle("/api/uploads", loggingMiddleware(s.requireScope(http.HandlerFunc(s.handleTusUploads), share.ScopeUpload)))
+	s.mux.Handle("/api/uploads/", loggingMiddleware(s.requireScope(http.HandlerFunc(s.handleTusUpload), share.ScopeUpload)))
+	s.mux.Handle("/api/shares/", loggingMiddleware(s.shareAuthMiddleware(http.HandlerFunc(s.handleAPIShareGet))))
+	s.mux.Handle("/api/handshakes", loggingMiddleware(s.allowRecipients(http.HandlerFunc(s.handleAPIHandshakes), share.ScopeHandshake)))
+	s.mux.Handle("/api/handshakes/", loggingMiddleware(s.allowRecipients(http.HandlerFunc(s.handleAPIHandshakeByID), share.ScopeHandshake)))
 }
 
 // Start starts the HTTP server and blocks until the context is cancelled.
@@ -97,10 +155,11 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 
 	s.httpServer = &http.Server{
-		Handler:      securityHeaders(s.mux),
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 0, // no write timeout for large file streams
-		IdleTimeout:  120 * time.Second,
+		Handler:           securityHeaders(s.mux),
+		ReadHeaderTimeout: 30 * time.Second,
+		ReadTimeout:       0, // no read timeout for large streaming uploads
+		WriteTimeout:      0, // no write timeout for large file streams
+		IdleTimeout:       120 * time.Second,
 	}
 
 	log.Printf("Durin's Door listening on %s", addr)
@@ -142,12 +201,37 @@ func (s *Server) runCleanup(ctx context.Context) {
 			} else if n > 0 {
 				log.Printf("cleaned up %d expired share(s)", n)
 			}
+			tn, err := s.store.EmptyTrash(ctx, s.trashRetention)
+			if err != nil {
+				log.Printf("trash cleanup error: %v", err)
+			} else if tn > 0 {
+				log.Printf("deleted %d revoked share(s) from the trash", tn)
+			}
 			hn, err := s.store.PurgeHandshakes(ctx)
 			if err != nil {
 				log.Printf("handshake cleanup error: %v", err)
 			} else if hn > 0 {
 				log.Printf("cleaned up %d expired handshake(s)", hn)
 			}
+			cn, err := s.store.PurgeDownloadClaims(ctx)
+			if err != nil {
+				log.Printf("download claim cleanup error: %v", err)
+			} else if cn > 0 {
+				log.Printf("released %d stale download claim(s)", cn)
+			}
+			en, err := s.store.PurgeDownloadEvents(ctx, s.eventRetention)
+			if err != nil {
+				log.Printf("download log cleanup error: %v", err)
+			} else if en > 0 {
+				log.Printf("removed %d old download event(s)", en)
+			}
+			s.passwords.purge()
+			un, err := s.purgeStagedUploads()
+			if err != nil {
+				log.Printf("staging cleanup error: %v", err)
+			} else if un > 0 {
+				log.Printf("cleaned up %d abandoned upload(s)", un)
+			}
 		}
 	}
 }
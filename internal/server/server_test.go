@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/unisoniq/durins-door/internal/crypto"
+	"github.com/unisoniq/durins-door/internal/share"
+)
+
+const testAdminToken = "test-admin-token"
+
+// newTestServer returns a server over a fresh SQLite store with a KEK set,
+// listening on an httptest server that is closed with the test.
+func newTestServer(t *testing.T, cfg Config) (*Server, *httptest.Server) {
+	t.Helper()
+	st, err := share.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	kek, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetKEK(context.Background(), kek); err != nil {
+		t.Fatalf("set KEK: %v", err)
+	}
+
+	cfg.Store = st
+	cfg.AdminToken = testAdminToken
+	s := New(cfg)
+	ts := httptest.NewServer(s.mux)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// The gallery links every active share, and a link is all a download needs,
+// so it must not be open to the public.
+func TestGalleryNeedsListScope(t *testing.T) {
+	_, ts := newTestServer(t, Config{})
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Get(ts.URL + "/gallery?cursor=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); resp.StatusCode != http.StatusMovedPermanently || loc != "/admin/gallery?cursor=abc" {
+		t.Errorf("/gallery: %s to %q, want a redirect to /admin/gallery?cursor=abc", resp.Status, loc)
+	}
+
+	resp, err = client.Get(ts.URL + "/admin/gallery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("/admin/gallery without a token: %s, want 401", resp.Status)
+	}
+}
@@ -0,0 +1,580 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unisoniq/durins-door/internal/blob"
+	"github.com/unisoniq/durins-door/internal/crypto"
+	"github.com/unisoniq/durins-door/internal/share"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Resumable uploads implement the core of the tus 1.0 protocol
+// (https://tus.io/protocols/resumable-upload) plus the creation, termination
+// and expiration extensions:
+//
+//	POST   /api/uploads        create an upload (Upload-Length, Upload-Metadata)
+//	HEAD   /api/uploads/{id}   report Upload-Offset
+//	PATCH  /api/uploads/{id}   append data at Upload-Offset
+//	DELETE /api/uploads/{id}   abandon an upload
+//
+// Staged data is encrypted as it arrives, so the staging file is already the
+// final .enc blob once the last byte lands; it is then moved into files/ and
+// registered as a share whose ID is returned in the X-Share-Id header. The
+// share's owner token is chosen when the upload is created and returned then
+// in X-Owner-Token, so it is not lost if the last response is; likewise the
+// TOTP secret of an upload with "totp true" metadata, in X-TOTP-Secret.
+// Uploads created with "encryption client" metadata carry ciphertext from the
+// client and are staged verbatim, like /api/upload/raw.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+
+	// stagingTTL is how long an upload may sit idle before runCleanup
+	// garbage-collects it.
+	stagingTTL = 24 * time.Hour
+)
+
+// stagedUpload is the on-disk state of a resumable upload, persisted as
+// staging/{id}.json next to the encrypted staging/{id}.enc.
+type stagedUpload struct {
+	ID           string     `json:"id"`
+	Length       int64      `json:"length"`   // total plaintext bytes
+	Offset       int64      `json:"offset"`   // plaintext bytes received
+	EncSize      int64      `json:"enc_size"` // committed ciphertext bytes
+	Chunks       int        `json:"chunks"`   // encrypted chunks written
+	KeyHex       string     `json:"key_hex"`  // wrapped under the KEK
+	NonceHex     string     `json:"nonce_hex"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	OwnerToken   string     `json:"owner_token"`
+	TOTPSecret   string     `json:"totp_secret,omitempty"`
+	Meta         uploadMeta `json:"meta"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func (u *stagedUpload) expiresAt() time.Time {
+	return u.UpdatedAt.Add(stagingTTL)
+}
+
+// lockUpload serialises PATCH/DELETE requests per upload ID. The lock's
+// entry is dropped when the upload is completed or removed.
+func (s *Server) lockUpload(id string) func() {
+	m, _ := s.uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := m.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (s *Server) stagingDir() string {
+	return filepath.Join(s.store.DataDir(), "staging")
+}
+
+func (s *Server) stagingPaths(id string) (infoPath, encPath string) {
+	dir := s.stagingDir()
+	return filepath.Join(dir, id+".json"), filepath.Join(dir, id+".enc")
+}
+
+// handleTusUploads handles OPTIONS and POST /api/uploads.
+func (s *Server) handleTusUploads(w http.ResponseWriter, r *http.Request) {
+	s.setTusHeaders(w)
+	switch r.Method {
+	case http.MethodOptions:
+		s.handleTusOptions(w, r)
+	case http.MethodPost:
+		if !checkTusVersion(w, r) {
+			return
+		}
+		s.handleTusCreate(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTusUpload handles OPTIONS, HEAD, PATCH and DELETE /api/uploads/{id}.
+func (s *Server) handleTusUpload(w http.ResponseWriter, r *http.Request) {
+	s.setTusHeaders(w)
+	id := strings.TrimPrefix(r.URL.Path, "/api/uploads/")
+	if r.Method == http.MethodOptions {
+		s.handleTusOptions(w, r)
+		return
+	}
+	if !validUploadID(id) {
+		http.NotFound(w, r)
+		return
+	}
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		s.handleTusHead(w, r, id)
+	case http.MethodPatch:
+		s.handleTusPatch(w, r, id)
+	case http.MethodDelete:
+		s.handleTusDelete(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (s *Server) handleTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	if limit := s.uploadLimit(r); limit > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(limit, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// handleTusCreate handles POST /api/uploads.
+func (s *Server) handleTusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if limit := s.uploadLimit(r); limit > 0 && length > limit {
+		http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	meta, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkMeta(&meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	up := &stagedUpload{
+		ID:         randomAPIID(),
+		Length:     length,
+		OwnerToken: randomAPIID(),
+		Meta:       meta,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	// Hash the password now so it never sits on disk in the clear.
+	if meta.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(meta.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Hashing password", http.StatusInternalServerError)
+			return
+		}
+		up.PasswordHash = string(hash)
+	}
+	if meta.TOTP {
+		if up.TOTPSecret, err = share.NewTOTPSecret(); err != nil {
+			http.Error(w, "Creating TOTP secret", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := s.createStagedUpload(up); err != nil {
+		log.Printf("creating staged upload: %v", err)
+		http.Error(w, "Creating upload", http.StatusInternalServerError)
+		return
+	}
+
+	// A zero-length upload is complete as soon as it exists.
+	if length == 0 {
+		shareID, err := s.completeStagedUpload(r.Context(), up)
+		if err != nil {
+			log.Printf("completing empty upload %s: %v", up.ID, err)
+			http.Error(w, "Completing upload", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Share-Id", shareID)
+	}
+
+	w.Header().Set("X-Owner-Token", up.OwnerToken)
+	if up.TOTPSecret != "" {
+		w.Header().Set("X-TOTP-Secret", up.TOTPSecret)
+	}
+	w.Header().Set("Location", "/api/uploads/"+up.ID)
+	w.Header().Set("Upload-Expires", up.expiresAt().UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// createStagedUpload writes the staging file header and its info record.
+func (s *Server) createStagedUpload(up *stagedUpload) error {
+	if err := os.MkdirAll(s.stagingDir(), 0700); err != nil {
+		return fmt.Errorf("create staging dir: %w", err)
+	}
+	_, encPath := s.stagingPaths(up.ID)
+	f, err := os.OpenFile(encPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("create staging file: %w", err)
+	}
+	if !up.Meta.ClientEncrypted {
+		err = s.startStagedEncryption(f, up)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(encPath)
+		return err
+	}
+
+	if err := s.saveStagedUpload(up); err != nil {
+		os.Remove(encPath)
+		return err
+	}
+	return nil
+}
+
+// startStagedEncryption writes the encrypted stream header for a new staged
+// upload and records its (wrapped) key and nonce.
+func (s *Server) startStagedEncryption(f *os.File, up *stagedUpload) error {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	enc, err := crypto.NewEncryptor(f, key)
+	if err != nil {
+		return err
+	}
+	up.KeyHex, err = s.store.WrapKey(up.ID, crypto.KeyToHex(key))
+	if err != nil {
+		return err
+	}
+	up.NonceHex = hex.EncodeToString(enc.Nonce())
+	up.EncSize = crypto.NonceSize
+	return nil
+}
+
+// handleTusHead handles HEAD /api/uploads/{id}.
+func (s *Server) handleTusHead(w http.ResponseWriter, r *http.Request, id string) {
+	up, err := s.loadStagedUpload(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	w.Header().Set("Upload-Expires", up.expiresAt().UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleTusPatch handles PATCH /api/uploads/{id}. Whatever part of the body
+// arrives is kept, even if the client disconnects mid-request, so the next
+// HEAD reports exactly how far the upload got.
+func (s *Server) handleTusPatch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Missing or invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	unlock := s.lockUpload(id)
+	defer unlock()
+
+	up, err := s.loadStagedUpload(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if offset != up.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	n, copyErr := s.appendStagedUpload(r.Context(), up, r.Body)
+	if n > 0 {
+		up.Offset += n
+		up.UpdatedAt = time.Now()
+		if err := s.saveStagedUpload(up); err != nil {
+			log.Printf("saving staged upload %s: %v", id, err)
+			http.Error(w, "Saving upload state", http.StatusInternalServerError)
+			return
+		}
+	}
+	if copyErr != nil {
+		if errors.Is(copyErr, errUploadTooLarge) {
+			http.Error(w, "Data exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("upload %s interrupted at %d/%d bytes: %v", id, up.Offset, up.Length, copyErr)
+		http.Error(w, "Upload interrupted", http.StatusInternalServerError)
+		return
+	}
+
+	if up.Offset == up.Length {
+		shareID, err := s.completeStagedUpload(r.Context(), up)
+		if err != nil {
+			log.Printf("completing upload %s: %v", id, err)
+			http.Error(w, "Completing upload", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Share-Id", shareID)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.Header().Set("Upload-Expires", up.expiresAt().UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendStagedUpload encrypts src onto the end of the staging file and
+// returns how many plaintext bytes were committed. The staging file is first
+// truncated to the last committed size, discarding any torn write from a
+// previous crash.
+func (s *Server) appendStagedUpload(ctx context.Context, up *stagedUpload, src io.Reader) (int64, error) {
+	_, encPath := s.stagingPaths(up.ID)
+	f, err := os.OpenFile(encPath, os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("open staging file: %w", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(up.EncSize); err != nil {
+		return 0, fmt.Errorf("truncate staging file: %w", err)
+	}
+	if _, err := f.Seek(up.EncSize, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek staging file: %w", err)
+	}
+
+	counter := &countingReader{r: &contextReader{ctx: ctx, r: src}}
+	in := &limitReader{r: counter, remaining: up.Length - up.Offset}
+
+	var copyErr error
+	if up.Meta.ClientEncrypted {
+		_, copyErr = io.Copy(f, in)
+	} else {
+		keyHex, err := s.store.UnwrapKey(up.ID, up.KeyHex)
+		if err != nil {
+			return 0, err
+		}
+		key, err := crypto.KeyFromHex(keyHex)
+		if err != nil {
+			return 0, err
+		}
+		nonce, err := hex.DecodeString(up.NonceHex)
+		if err != nil {
+			return 0, fmt.Errorf("invalid staging nonce: %w", err)
+		}
+		enc, err := crypto.ResumeEncryptor(f, key, nonce, up.Chunks)
+		if err != nil {
+			return 0, err
+		}
+		_, copyErr = io.Copy(enc, in)
+
+		// Commit whatever arrived before the error, including the buffered tail.
+		if err := enc.Flush(); err != nil {
+			return 0, fmt.Errorf("flush staging file: %w", err)
+		}
+		up.Chunks = enc.Chunks()
+	}
+
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("sync staging file: %w", err)
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	n := counter.n
+	if up.Meta.ClientEncrypted {
+		n = size - up.EncSize // stored verbatim: only count what hit the disk
+	}
+	if n > up.Length-up.Offset {
+		n = up.Length - up.Offset
+	}
+	up.EncSize = size
+	return n, copyErr
+}
+
+// completeStagedUpload moves a finished staging file into the blob store and
+// registers its share. The share reuses the upload ID.
+func (s *Server) completeStagedUpload(ctx context.Context, up *stagedUpload) (string, error) {
+	infoPath, stagedPath := s.stagingPaths(up.ID)
+	keyHex, err := s.store.UnwrapKey(up.ID, up.KeyHex)
+	if err != nil {
+		return "", err
+	}
+	blobKey := blob.Key(up.ID)
+	if err := blob.PutFile(ctx, s.store.Blobs(), blobKey, stagedPath); err != nil {
+		return "", fmt.Errorf("move staged file: %w", err)
+	}
+
+	stored := &storedBlob{Key: blobKey, KeyHex: keyHex, Size: up.Length}
+	sh, err := newUploadedShare(up.ID, stored, up.Meta)
+	if err != nil {
+		s.store.Blobs().Delete(context.Background(), blobKey)
+		return "", err
+	}
+	sh.PasswordHash = up.PasswordHash
+	sh.TOTPSecret = up.TOTPSecret
+	if up.OwnerToken != "" {
+		sh.AdminToken = up.OwnerToken
+	}
+	if err := s.store.Create(ctx, sh); err != nil {
+		s.store.Blobs().Delete(context.Background(), blobKey)
+		return "", err
+	}
+	os.Remove(infoPath)
+	s.uploadLocks.Delete(up.ID)
+	log.Printf("resumable upload stored: %s (%s)", sh.ID, humanSize(sh.Size))
+	return sh.ID, nil
+}
+
+// handleTusDelete handles DELETE /api/uploads/{id} (termination extension).
+func (s *Server) handleTusDelete(w http.ResponseWriter, r *http.Request, id string) {
+	unlock := s.lockUpload(id)
+	defer unlock()
+
+	if _, err := s.loadStagedUpload(id); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.removeStagedUpload(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) removeStagedUpload(id string) {
+	infoPath, encPath := s.stagingPaths(id)
+	os.Remove(encPath)
+	os.Remove(infoPath)
+	s.uploadLocks.Delete(id)
+}
+
+func (s *Server) loadStagedUpload(id string) (*stagedUpload, error) {
+	infoPath, _ := s.stagingPaths(id)
+	b, err := os.ReadFile(infoPath)
+	if err != nil {
+		return nil, err
+	}
+	var up stagedUpload
+	if err := json.Unmarshal(b, &up); err != nil {
+		return nil, fmt.Errorf("decode staged upload %s: %w", id, err)
+	}
+	return &up, nil
+}
+
+// saveStagedUpload atomically replaces the upload's info record.
+func (s *Server) saveStagedUpload(up *stagedUpload) error {
+	infoPath, _ := s.stagingPaths(up.ID)
+	b, err := json.Marshal(up)
+	if err != nil {
+		return err
+	}
+	tmp := infoPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("write staged upload: %w", err)
+	}
+	return os.Rename(tmp, infoPath)
+}
+
+// purgeStagedUploads removes resumable uploads that have been idle for longer
+// than stagingTTL, along with any staging files that lost their info record.
+func (s *Server) purgeStagedUploads() (int, error) {
+	entries, err := os.ReadDir(s.stagingDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-stagingTTL)
+	count := 0
+	for _, e := range entries {
+		name := e.Name()
+		id := strings.TrimSuffix(strings.TrimSuffix(name, ".json"), ".enc")
+		if id == name || !validUploadID(id) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if strings.HasSuffix(name, ".json") {
+			up, err := s.loadStagedUpload(id)
+			if err == nil && up.UpdatedAt.After(cutoff) {
+				continue
+			}
+			s.removeStagedUpload(id)
+			count++
+			continue
+		}
+		// Orphaned .enc without an info record.
+		infoPath, _ := s.stagingPaths(id)
+		if _, err := os.Stat(infoPath); errors.Is(err, os.ErrNotExist) {
+			os.Remove(filepath.Join(s.stagingDir(), name))
+			count++
+		}
+	}
+	return count, nil
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated
+// "key base64value" pairs.
+func parseTusMetadata(header string) (uploadMeta, error) {
+	var meta uploadMeta
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return meta, fmt.Errorf("malformed pair %q", pair)
+		}
+		var value string
+		if len(fields) == 2 {
+			b, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return meta, fmt.Errorf("value for %q is not base64", fields[0])
+			}
+			value = string(b)
+		}
+		meta.set(fields[0], value)
+	}
+	return meta, nil
+}
+
+// validUploadID reports whether id looks like one of our random hex IDs, so
+// it can be used safely in a file path.
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/unisoniq/durins-door/internal/blob"
+	"github.com/unisoniq/durins-door/internal/crypto"
+)
+
+// tusRequest sends an authenticated tus request to ts.
+func tusRequest(t *testing.T, method, url string, body []byte, headers map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// A resumable upload ends a short chunk at every PATCH, so its file is
+// longer than a single-pass encryption of the same data. fsck must accept
+// it, and --repair must leave the share alone.
+func TestFsckAcceptsUnevenTusPatches(t *testing.T) {
+	s, ts := newTestServer(t, Config{})
+	ctx := context.Background()
+
+	data := make([]byte, 200_000)
+	rand.Read(data)
+	resp := tusRequest(t, http.MethodPost, ts.URL+"/api/uploads", nil, map[string]string{
+		"Upload-Length": strconv.Itoa(len(data)),
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create upload: %s", resp.Status)
+	}
+	location := ts.URL + resp.Header.Get("Location")
+
+	var shareID string
+	for _, part := range [][2]int{{0, 1000}, {1000, len(data)}} {
+		resp := tusRequest(t, http.MethodPatch, location, data[part[0]:part[1]], map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(part[0]),
+		})
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("PATCH at %d: %s", part[0], resp.Status)
+		}
+		shareID = resp.Header.Get("X-Share-Id")
+	}
+	if shareID == "" {
+		t.Fatal("upload did not complete")
+	}
+	info, err := s.store.Blobs().Stat(ctx, blob.Key(shareID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size == crypto.EncryptedSize(int64(len(data))) {
+		t.Fatal("file has the single-pass size; the test needs a short chunk mid-stream")
+	}
+
+	report, err := s.store.Fsck(ctx)
+	if err != nil {
+		t.Fatalf("fsck: %v", err)
+	}
+	if !report.HeadersChecked {
+		t.Error("headers were not checked")
+	}
+	for _, p := range report.Problems {
+		t.Errorf("unexpected problem: %s %s: %s", p.Kind, p.ShareID, p.Detail)
+		if p.Repairable() {
+			if err := s.store.Repair(ctx, p, true); err != nil {
+				t.Errorf("repair: %v", err)
+			}
+		}
+	}
+
+	sh, err := s.store.Get(ctx, shareID)
+	if err != nil {
+		t.Fatalf("share gone after repair: %v", err)
+	}
+	if _, err := s.store.Blobs().Stat(ctx, sh.BlobKey); err != nil {
+		t.Fatalf("file gone after repair: %v", err)
+	}
+}
+
+// An upload's lock is dropped from uploadLocks once the upload is completed
+// or terminated, so finished uploads do not leak a mutex each.
+func TestTusUploadLocksAreDropped(t *testing.T) {
+	s, ts := newTestServer(t, Config{})
+	data := []byte("one ring to rule them all")
+
+	create := func() string {
+		resp := tusRequest(t, http.MethodPost, ts.URL+"/api/uploads", nil, map[string]string{
+			"Upload-Length": strconv.Itoa(len(data)),
+		})
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create upload: %s", resp.Status)
+		}
+		return ts.URL + resp.Header.Get("Location")
+	}
+	patch := func(location string, part []byte) {
+		resp := tusRequest(t, http.MethodPatch, location, part, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		})
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("PATCH: %s", resp.Status)
+		}
+	}
+
+	completed := create()
+	patch(completed, data)
+	terminated := create()
+	patch(terminated, data[:5])
+	if resp := tusRequest(t, http.MethodDelete, terminated, nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: %s", resp.Status)
+	}
+
+	s.uploadLocks.Range(func(id, _ any) bool {
+		t.Errorf("lock for upload %s left behind", id)
+		return true
+	})
+}
@@ -0,0 +1,554 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unisoniq/durins-door/internal/blob"
+	"github.com/unisoniq/durins-door/internal/crypto"
+	"github.com/unisoniq/durins-door/internal/share"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxFieldSize caps each non-file multipart field (password, expiry, ...).
+const maxFieldSize = 4 << 10 // 4 KB
+
+// errUploadTooLarge is returned by limitReader once the configured upload
+// size limit has been exceeded.
+var errUploadTooLarge = errors.New("upload exceeds size limit")
+
+// uploadMeta holds the optional share settings sent alongside an upload.
+type uploadMeta struct {
+	Filename     string `json:"filename,omitempty"`
+	Password     string `json:"-"`
+	ExpiresAt    string `json:"expires_at,omitempty"` // RFC3339
+	MaxDownloads string `json:"max_downloads,omitempty"`
+	Burn         bool   `json:"burn,omitempty"` // burn after reading
+	// AvailableFrom (RFC3339) embargoes the share until that time.
+	AvailableFrom string `json:"available_from,omitempty"`
+	Tags          string `json:"tags,omitempty"` // comma-separated
+	Note          string `json:"note,omitempty"`
+	// TOTP asks for a TOTP secret, returned with the new share.
+	TOTP       bool   `json:"totp,omitempty"`
+	AllowedIPs string `json:"allowed_ips,omitempty"` // comma-separated
+
+	// ClientEncrypted marks a resumable upload whose data is already
+	// encrypted by the client ("encryption client" in Upload-Metadata).
+	ClientEncrypted bool `json:"client_encrypted,omitempty"`
+}
+
+// set assigns a named form field to the matching metadata entry.
+func (m *uploadMeta) set(name, value string) {
+	switch name {
+	case "filename":
+		m.Filename = value
+	case "password":
+		m.Password = value
+	case "expires_at":
+		m.ExpiresAt = value
+	case "max_downloads":
+		m.MaxDownloads = value
+	case "burn":
+		m.Burn, _ = strconv.ParseBool(value)
+	case "available_from":
+		m.AvailableFrom = value
+	case "tags":
+		m.Tags = value
+	case "note":
+		m.Note = value
+	case "totp":
+		m.TOTP, _ = strconv.ParseBool(value)
+	case "allowed_ips":
+		m.AllowedIPs = value
+	case "encryption":
+		m.ClientEncrypted = value == "client"
+	}
+}
+
+// expiry returns the requested expiry time, or the 1 hour default when none
+// was given.
+func (m *uploadMeta) expiry() (time.Time, error) {
+	if m.ExpiresAt == "" {
+		return time.Now().Add(time.Hour), nil
+	}
+	t, err := time.Parse(time.RFC3339, m.ExpiresAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expires_at: %w", err)
+	}
+	return t, nil
+}
+
+// maxDownloads returns the requested download limit, 0 (unlimited) if none
+// was given. A burn share always allows exactly one.
+func (m *uploadMeta) maxDownloads() (int, error) {
+	if m.Burn {
+		return 1, nil
+	}
+	if m.MaxDownloads == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(m.MaxDownloads)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid max_downloads %q", m.MaxDownloads)
+	}
+	return n, nil
+}
+
+// availableFrom returns the requested embargo time, zero if none was given.
+func (m *uploadMeta) availableFrom() (time.Time, error) {
+	if m.AvailableFrom == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, m.AvailableFrom)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid available_from: %w", err)
+	}
+	return t, nil
+}
+
+// labels returns the normalized tags and note.
+func (m *uploadMeta) labels() ([]string, string, error) {
+	tags, err := share.NormalizeTags(strings.Split(m.Tags, ","))
+	if err != nil {
+		return nil, "", err
+	}
+	note, err := share.NormalizeNote(m.Note)
+	if err != nil {
+		return nil, "", err
+	}
+	return tags, note, nil
+}
+
+// allowedIPs returns the normalized IP allowlist.
+func (m *uploadMeta) allowedIPs() ([]string, error) {
+	return share.NormalizeAllowedIPs(strings.Split(m.AllowedIPs, ","))
+}
+
+// handleAPIUpload handles POST /api/upload (multipart) and PUT /api/upload
+// (raw body). The file is streamed through the encryptor straight to disk,
+// so memory use stays constant regardless of the file size.
+func (s *Server) handleAPIUpload(w http.ResponseWriter, r *http.Request) {
+	s.handleUpload(w, r, true)
+}
+
+// handleAPIUploadRaw handles POST/PUT /api/upload/raw. The body is ciphertext
+// produced by the client (Web Crypto format) and is stored as-is; the key
+// stays in the uploader's URL fragment and never reaches the server.
+func (s *Server) handleAPIUploadRaw(w http.ResponseWriter, r *http.Request) {
+	s.handleUpload(w, r, false)
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, encrypt bool) {
+	switch r.Method {
+	case http.MethodPost:
+		s.handleMultipartUpload(w, r, encrypt)
+	case http.MethodPut:
+		s.handleRawUpload(w, r, encrypt)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMultipartUpload reads a multipart form part by part. The "file" part
+// is encrypted as it arrives; metadata fields may come before or after it.
+// Fields before it are checked before any of the file is read, so a bad
+// setting is refused up front; fields after it only once it is stored.
+func (s *Server) handleMultipartUpload(w http.ResponseWriter, r *http.Request, encrypt bool) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		jsonError(w, "Invalid multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	shareID := randomAPIID()
+	limit := s.uploadLimit(r)
+
+	var (
+		meta    uploadMeta
+		blob    *storedBlob
+		haveErr bool
+	)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			haveErr = true
+			jsonError(w, "Reading multipart form: "+err.Error(), http.StatusBadRequest)
+			break
+		}
+
+		if part.FormName() == "file" {
+			if blob != nil {
+				part.Close()
+				haveErr = true
+				jsonError(w, "Only one file per upload", http.StatusBadRequest)
+				break
+			}
+			if meta.Filename == "" {
+				meta.Filename = part.FileName()
+			}
+			// Refuse bad settings before reading the file. The part is
+			// left unread: closing it would drain it.
+			if err := s.checkMeta(&meta); err != nil {
+				haveErr = true
+				jsonError(w, err.Error(), http.StatusBadRequest)
+				break
+			}
+			blob, err = s.writeBlob(r.Context(), shareID, part, limit, encrypt)
+			part.Close()
+			if err != nil {
+				haveErr = true
+				uploadError(w, err)
+				break
+			}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+		part.Close()
+		if err != nil {
+			haveErr = true
+			jsonError(w, "Reading form field: "+err.Error(), http.StatusBadRequest)
+			break
+		}
+		meta.set(part.FormName(), string(value))
+	}
+
+	if haveErr {
+		if blob != nil {
+			s.store.Blobs().Delete(context.Background(), blob.Key)
+		}
+		return
+	}
+	if blob == nil {
+		jsonError(w, "Missing file field", http.StatusBadRequest)
+		return
+	}
+
+	s.finishUpload(w, r, shareID, blob, meta)
+}
+
+// handleRawUpload accepts the file as the raw request body. Metadata is read
+// from query parameters and checked before the body; the password travels
+// in the X-Share-Password header so it never appears in access logs.
+func (s *Server) handleRawUpload(w http.ResponseWriter, r *http.Request, encrypt bool) {
+	q := r.URL.Query()
+	meta := uploadMeta{
+		Filename:     q.Get("filename"),
+		Password:     r.Header.Get("X-Share-Password"),
+		ExpiresAt:    q.Get("expires_at"),
+		MaxDownloads: q.Get("max_downloads"),
+	}
+	for _, name := range []string{"burn", "available_from", "tags", "note", "totp", "allowed_ips"} {
+		meta.set(name, q.Get(name))
+	}
+	if meta.Filename == "" {
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+			meta.Filename = params["filename"]
+		}
+	}
+
+	if err := s.checkMeta(&meta); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := s.uploadLimit(r)
+	if limit > 0 && r.ContentLength > limit {
+		uploadError(w, errUploadTooLarge)
+		return
+	}
+
+	shareID := randomAPIID()
+	blob, err := s.writeBlob(r.Context(), shareID, r.Body, limit, encrypt)
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+
+	s.finishUpload(w, r, shareID, blob, meta)
+}
+
+// storedBlob describes an encrypted file written to the blob store.
+type storedBlob struct {
+	Key    string // blob store key
+	KeyHex string // empty for client-encrypted blobs
+	Size   int64  // bytes received from the client
+}
+
+// writeBlob streams src into the share's blob, through a fresh AES-256-GCM
+// encryptor when encrypt is set, or verbatim for ciphertext the client
+// already encrypted. Blob store writes are atomic, so an aborted upload
+// (client disconnect, size limit) never leaves a half-written file behind.
+func (s *Server) writeBlob(ctx context.Context, shareID string, src io.Reader, limit int64, encrypt bool) (*storedBlob, error) {
+	counter := &countingReader{r: &contextReader{ctx: ctx, r: src}}
+	var in io.Reader = counter
+	if limit > 0 {
+		in = &limitReader{r: counter, remaining: limit}
+	}
+
+	var key []byte
+	if encrypt {
+		var err error
+		key, err = crypto.GenerateKey()
+		if err != nil {
+			return nil, fmt.Errorf("generating key: %w", err)
+		}
+		// Encrypt on the fly; a read error (size limit, disconnect) is
+		// passed through the pipe and aborts the Put.
+		pr, pw := io.Pipe()
+		plain := in
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pw.CloseWithError(crypto.EncryptStream(pw, plain, key))
+		}()
+		// Unblock and wait for the encryptor so it never touches the request
+		// body after the handler returns.
+		defer func() {
+			pr.Close()
+			<-done
+		}()
+		in = pr
+	}
+
+	blobKey := blob.Key(shareID)
+	if err := s.store.Blobs().Put(ctx, blobKey, in, -1); err != nil {
+		return nil, err
+	}
+
+	stored := &storedBlob{Key: blobKey, Size: counter.n}
+	if key != nil {
+		stored.KeyHex = crypto.KeyToHex(key)
+	}
+	return stored, nil
+}
+
+// finishUpload registers the share for a stored blob and writes the JSON
+// response. The blob is removed again if the share cannot be created.
+func (s *Server) finishUpload(w http.ResponseWriter, r *http.Request, shareID string, blob *storedBlob, meta uploadMeta) {
+	if err := s.checkMeta(&meta); err != nil {
+		s.store.Blobs().Delete(context.Background(), blob.Key)
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sh, err := newUploadedShare(shareID, blob, meta)
+	if err != nil {
+		s.store.Blobs().Delete(context.Background(), blob.Key)
+		jsonError(w, "Creating share: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.store.Create(r.Context(), sh); err != nil {
+		s.store.Blobs().Delete(context.Background(), blob.Key)
+		jsonError(w, "Creating share: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("upload stored: %s (%s)", sh.ID, humanSize(sh.Size))
+	resp := withTOTPSecret(shareToAPI(sh), sh)
+	resp.OwnerToken = sh.AdminToken
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// newUploadedShare builds the share record for an uploaded blob, applying the
+// same defaults as the rest of the API (1 hour expiry, unlimited downloads).
+// A burn share always allows exactly one download. A TOTP secret is made
+// here if one was asked for.
+func newUploadedShare(shareID string, blob *storedBlob, meta uploadMeta) (*share.Share, error) {
+	filename := meta.Filename
+	if filename == "" {
+		filename = "upload"
+	}
+
+	var passwordHash string
+	if meta.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(meta.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("hash password: %w", err)
+		}
+		passwordHash = string(hash)
+	}
+
+	availableFrom, err := meta.availableFrom()
+	if err != nil {
+		return nil, err
+	}
+	tags, note, err := meta.labels()
+	if err != nil {
+		return nil, err
+	}
+	allowedIPs, err := meta.allowedIPs()
+	if err != nil {
+		return nil, err
+	}
+	var totpSecret string
+	if meta.TOTP {
+		if totpSecret, err = share.NewTOTPSecret(); err != nil {
+			return nil, fmt.Errorf("create TOTP secret: %w", err)
+		}
+	}
+
+	expiresAt, err := meta.expiry()
+	if err != nil {
+		return nil, err
+	}
+	maxDownloads, err := meta.maxDownloads()
+	if err != nil {
+		return nil, err
+	}
+
+	return &share.Share{
+		ID:              shareID,
+		Filename:        filepath.Base(filename),
+		BlobKey:         blob.Key,
+		KeyHex:          blob.KeyHex,
+		CreatedAt:       time.Now(),
+		ExpiresAt:       expiresAt,
+		MaxDownloads:    maxDownloads,
+		PasswordHash:    passwordHash,
+		AdminToken:      randomAPIID(),
+		Size:            blob.Size,
+		ClientEncrypted: blob.KeyHex == "",
+		Burn:            meta.Burn,
+		AvailableFrom:   availableFrom,
+		Tags:            tags,
+		Note:            note,
+		TOTPSecret:      totpSecret,
+		AllowedIPs:      allowedIPs,
+	}, nil
+}
+
+// checkMeta validates an upload's settings. The expiry must be within the
+// server's limit, and the share must become available before it expires. An
+// unparseable expiry, download limit or embargo is rejected rather than
+// replaced by a default, so a typo cannot quietly change how long or how
+// often a file can be fetched, or release it early. Tags, note and IP allowlist must be valid,
+// and TOTP and IP allowlists are refused where the store cannot enforce
+// them.
+func (s *Server) checkMeta(meta *uploadMeta) error {
+	if _, _, err := meta.labels(); err != nil {
+		return err
+	}
+	ips, err := meta.allowedIPs()
+	if err != nil {
+		return err
+	}
+	if (meta.TOTP || len(ips) > 0) && !s.store.SupportsAccessPolicies() {
+		return share.ErrPoliciesUnsupported
+	}
+	expiresAt, err := meta.expiry()
+	if err != nil {
+		return err
+	}
+	if _, err := meta.maxDownloads(); err != nil {
+		return err
+	}
+	if err := s.checkExpiry(expiresAt); err != nil {
+		return err
+	}
+	availableFrom, err := meta.availableFrom()
+	if err != nil {
+		return err
+	}
+	if !availableFrom.IsZero() && !availableFrom.Before(expiresAt) {
+		return errors.New("available_from must be before the expiry")
+	}
+	return nil
+}
+
+// checkExpiry enforces the server's maximum share lifetime (--max-expiry)
+// on a new or changed expiry time.
+func (s *Server) checkExpiry(t time.Time) error {
+	return share.CheckMaxExpiry(t, s.maxExpiry)
+}
+
+// uploadLimit returns the maximum upload size in bytes for the request's
+// bearer token or API token name, falling back to the server-wide default.
+// 0 means unlimited.
+func (s *Server) uploadLimit(r *http.Request) int64 {
+	if limit, ok := s.tokenUploadLimits[bearerToken(r)]; ok {
+		return limit
+	}
+	if t := authedToken(r); t != nil && t.Name != "" {
+		if limit, ok := s.tokenUploadLimits[t.Name]; ok {
+			return limit
+		}
+	}
+	return s.maxUploadSize
+}
+
+// uploadError maps a streaming upload failure to an HTTP response.
+func uploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		jsonError(w, "Upload too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, context.Canceled):
+		// Client went away; nobody is listening for the response.
+		log.Printf("upload aborted by client: %v", err)
+	default:
+		jsonError(w, "Storing upload: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// limitReader fails with errUploadTooLarge once more than remaining bytes
+// are offered, rather than silently truncating like io.LimitReader. It never
+// returns bytes beyond the limit.
+type limitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	// Ask for one byte more than allowed so an overflow is detected.
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = 0
+		return n, errUploadTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// contextReader stops reading once ctx is done, so a client disconnect
+// aborts the copy even if the body reader itself does not notice.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/unisoniq/durins-door/internal/blob"
+)
+
+// readTracker records how much of a request body the handler read.
+type readTracker struct {
+	r io.Reader
+	n int
+}
+
+func (t *readTracker) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.n += n
+	return n, err
+}
+
+func countBlobs(t *testing.T, s *Server) int {
+	t.Helper()
+	n := 0
+	err := s.store.Blobs().List(context.Background(), func(string, blob.Info) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// Bad share settings are refused with 400 before any of the file is read,
+// rather than replaced by defaults or noticed after it is stored.
+func TestUploadRejectsBadSettingsBeforeTheBody(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	file := bytes.Repeat([]byte("mellon"), 100_000)
+
+	for _, tc := range []struct {
+		name  string
+		query url.Values
+	}{
+		{"unparseable expires_at", url.Values{"expires_at": {"tomorrow"}}},
+		{"unparseable max_downloads", url.Values{"max_downloads": {"three"}}},
+		{"negative max_downloads", url.Values{"max_downloads": {"-1"}}},
+		{"unparseable available_from", url.Values{"available_from": {"soon"}}},
+		{"bad allowed_ips", url.Values{"allowed_ips": {"moria"}}},
+	} {
+		t.Run("raw/"+tc.name, func(t *testing.T) {
+			body := &readTracker{r: bytes.NewReader(file)}
+			req := httptest.NewRequest(http.MethodPut, "/api/upload?"+tc.query.Encode(), body)
+			req.Header.Set("Authorization", "Bearer "+testAdminToken)
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if body.n != 0 {
+				t.Errorf("%d bytes of the body read before refusing", body.n)
+			}
+		})
+
+		t.Run("multipart/"+tc.name, func(t *testing.T) {
+			var form bytes.Buffer
+			mw := multipart.NewWriter(&form)
+			for name := range tc.query {
+				mw.WriteField(name, tc.query.Get(name))
+			}
+			fw, _ := mw.CreateFormFile("file", "ring.bin")
+			fw.Write(file)
+			mw.Close()
+			total := form.Len()
+
+			body := &readTracker{r: &form}
+			req := httptest.NewRequest(http.MethodPost, "/api/upload", body)
+			req.Header.Set("Authorization", "Bearer "+testAdminToken)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if body.n > total/2 {
+				t.Errorf("%d of %d bytes read before refusing", body.n, total)
+			}
+		})
+	}
+
+	// A field after the file can only be checked once the file is in;
+	// the stored file is removed again.
+	t.Run("multipart/expires_at after the file", func(t *testing.T) {
+		var form bytes.Buffer
+		mw := multipart.NewWriter(&form)
+		fw, _ := mw.CreateFormFile("file", "ring.bin")
+		fw.Write(file)
+		mw.WriteField("expires_at", "tomorrow")
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/upload", &form)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400: %s", rec.Code, rec.Body)
+		}
+	})
+
+	if n := countBlobs(t, s); n != 0 {
+		t.Errorf("%d files stored by refused uploads", n)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/upload?expires_at="+url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))+"&max_downloads=3", bytes.NewReader(file))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("valid upload: status %d, want 201: %s", rec.Code, rec.Body)
+	}
+}
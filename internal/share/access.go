@@ -0,0 +1,169 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInvalidPolicy is returned (wrapped with the reason) for an access
+// policy that cannot be stored.
+var ErrInvalidPolicy = errors.New("invalid access policy")
+
+// ErrPoliciesUnsupported is returned when a TOTP requirement or IP
+// allowlist is set on a share in the web app's Postgres database. The web
+// app serves those shares too and does not enforce either policy, so
+// storing one there would only look like protection.
+var ErrPoliciesUnsupported = errors.New("TOTP codes and IP allowlists are not supported with --database-url: the web app serving the same shares does not enforce them")
+
+// MaxAllowedIPs is the most prefixes a share's IP allowlist can hold.
+const MaxAllowedIPs = 20
+
+// SupportsAccessPolicies reports whether shares in this store can carry a
+// TOTP requirement or an IP allowlist (see ErrPoliciesUnsupported).
+func (s *Store) SupportsAccessPolicies() bool {
+	_, shared := s.meta.(*Postgres)
+	return !shared
+}
+
+// TOTP parameters (RFC 6238): the defaults every authenticator app
+// assumes, with one step of clock skew allowed either way.
+const (
+	totpDigits  = 6
+	totpModulus = 1_000_000 // 10^totpDigits
+	totpPeriod  = 30
+	totpSkew    = 1
+)
+
+// totpEncoding is how TOTP secrets are written: unpadded base32, as
+// authenticator apps expect them.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160-bit TOTP secret in base32.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth:// URI for secret, which authenticator apps
+// import directly or from a QR code. account names the entry in the app.
+func TOTPURI(secret, account string) string {
+	const issuer = "Durin's Door"
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + v.Encode()
+}
+
+// HasTOTP reports whether downloading the share needs a TOTP code.
+func (s *Share) HasTOTP() bool {
+	return s.TOTPSecret != ""
+}
+
+// CheckTOTP reports whether code is the share's TOTP code at now or one
+// step either side, and returns the time step it matched. Callers that
+// accept a code should refuse it again for the same or an earlier step.
+func (s *Share) CheckTOTP(code string, now time.Time) (step int64, ok bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(s.TOTPSecret))
+	if err != nil || len(key) == 0 {
+		return 0, false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for st := current - totpSkew; st <= current+totpSkew; st++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, st)), []byte(code)) == 1 {
+			return st, true
+		}
+	}
+	return 0, false
+}
+
+// OldestTOTPStep returns the earliest time step whose code CheckTOTP still
+// accepts at now.
+func OldestTOTPStep(now time.Time) int64 {
+	return now.Unix()/totpPeriod - totpSkew
+}
+
+// totpCode computes the HOTP value (RFC 4226) of key for counter step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	m := hmac.New(sha1.New, key)
+	m.Write(msg[:])
+	sum := m.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// NormalizeAllowedIPs parses an IP allowlist: CIDR prefixes or single
+// addresses, which become /32 or /128 prefixes. It masks, de-duplicates
+// and sorts them, dropping empty entries.
+func NormalizeAllowedIPs(list []string) ([]string, error) {
+	var out []string
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var prefix netip.Prefix
+		if strings.Contains(entry, "/") {
+			p, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q is not an IP address or CIDR prefix", ErrInvalidPolicy, entry)
+			}
+			prefix = p.Masked()
+		} else {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q is not an IP address or CIDR prefix", ErrInvalidPolicy, entry)
+			}
+			addr = addr.Unmap().WithZone("")
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if p := prefix.String(); !slices.Contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	if len(out) > MaxAllowedIPs {
+		return nil, fmt.Errorf("%w: at most %d allowed IP ranges", ErrInvalidPolicy, MaxAllowedIPs)
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// AllowsIP reports whether a client at ip may download the share: always
+// when the share has no allowlist, otherwise only from inside one of its
+// prefixes. An unparseable ip is refused.
+func (s *Share) AllowsIP(ip string) bool {
+	if len(s.AllowedIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range s.AllowedIPs {
+		if prefix, err := netip.ParsePrefix(p); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,441 @@
+package share
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/unisoniq/durins-door/internal/blob"
+	"github.com/unisoniq/durins-door/internal/crypto"
+)
+
+// A backup archive is a tar stream holding a snapshot of the SQLite
+// database (shares.db), the encrypted file of every share (files/{id}.enc)
+// and, last, manifest.json with the size and SHA-256 of each entry. An
+// encrypted archive is archiveMagic, an Argon2id salt, and the tar stream
+// encrypted with crypto.EncryptStream under the passphrase-derived key.
+
+const (
+	archiveMagic    = "DURINSDOOR-BACKUP1\n"
+	archiveFormat   = 1
+	manifestName    = "manifest.json"
+	snapshotName    = "shares.db"
+	archiveFilesDir = "files/"
+)
+
+// ErrArchiveKEK is returned by Import when the archive's share keys were
+// wrapped under a different KEK than the one given.
+var ErrArchiveKEK = errors.New("the archive was made with a different KEK")
+
+// ErrArchivePassphrase is returned when an encrypted archive is read
+// without a passphrase.
+var ErrArchivePassphrase = errors.New("archive is encrypted; a passphrase is required")
+
+// Manifest describes the contents of a backup archive.
+type Manifest struct {
+	Format        int            `json:"format"`
+	CreatedAt     time.Time      `json:"created_at"`
+	SchemaVersion int            `json:"schema_version"`
+	Shares        int            `json:"shares"`
+	Files         []ManifestFile `json:"files"`
+	// Missing lists shares whose file was already gone at export time;
+	// their rows are in the snapshot but Import skips them.
+	Missing []string `json:"missing,omitempty"`
+}
+
+// ManifestFile is one archive entry.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Export writes a backup archive of the store to w, encrypted if
+// passphrase is non-empty. The database is snapshotted with VACUUM INTO, so
+// a running server can keep serving; files are copied afterwards, and
+// anything created after the snapshot is left out. Only the SQLite metadata
+// store can be exported. Share keys stay wrapped under the current KEK.
+func (s *Store) Export(ctx context.Context, w io.Writer, passphrase string) (*Manifest, error) {
+	sq, ok := s.meta.(*SQLite)
+	if !ok {
+		return nil, errors.New("export needs the SQLite metadata store; back up Postgres with pg_dump")
+	}
+
+	tmp, err := os.MkdirTemp(s.dataDir, "export-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	snapPath := filepath.Join(tmp, snapshotName)
+	if err := sq.Backup(ctx, snapPath); err != nil {
+		return nil, err
+	}
+	snap, err := OpenSQLite(snapPath)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+	shares, err := snap.AllShares(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := snap.Migrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		Format:    archiveFormat,
+		CreatedAt: time.Now().UTC(),
+		Shares:    len(shares),
+		Files:     []ManifestFile{},
+	}
+	for _, m := range migrations {
+		if m.Applied() {
+			manifest.SchemaVersion = max(manifest.SchemaVersion, m.Version)
+		}
+	}
+
+	out := w
+	var enc *crypto.Encryptor
+	if passphrase != "" {
+		key, salt, err := crypto.DeriveKey(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, archiveMagic); err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(salt); err != nil {
+			return nil, err
+		}
+		if enc, err = crypto.NewEncryptor(w, key); err != nil {
+			return nil, err
+		}
+		out = enc
+	}
+	tw := tar.NewWriter(out)
+
+	f, err := os.Open(snapPath)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err == nil {
+		err = addArchiveFile(tw, manifest, snapshotName, f, fi.Size())
+	}
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sh := range shares {
+		info, err := s.blobs.Stat(ctx, sh.BlobKey)
+		if errors.Is(err, blob.ErrNotFound) {
+			manifest.Missing = append(manifest.Missing, sh.ID)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("share %s: %w", sh.ID, err)
+		}
+		src, err := s.blobs.Get(ctx, sh.BlobKey)
+		if err != nil {
+			return nil, fmt.Errorf("share %s: %w", sh.ID, err)
+		}
+		err = addArchiveFile(tw, manifest, archiveFilesDir+blob.Key(sh.ID), src, info.Size)
+		src.Close()
+		if err != nil {
+			return nil, fmt.Errorf("share %s: %w", sh.ID, err)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarEntry(tw, manifestName, strings.NewReader(string(data)), int64(len(data))); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if enc != nil {
+		if err := enc.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// addArchiveFile writes an entry and records its checksum in manifest.
+func addArchiveFile(tw *tar.Writer, manifest *Manifest, name string, src io.Reader, size int64) error {
+	h := sha256.New()
+	if err := writeTarEntry(tw, name, io.TeeReader(src, h), size); err != nil {
+		return err
+	}
+	manifest.Files = append(manifest.Files, ManifestFile{Name: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+func writeTarEntry(tw *tar.Writer, name string, src io.Reader, size int64) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: time.Now(),
+		Format:  tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, src); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// ExtractArchive unpacks a backup archive into dir and checks every entry
+// against the manifest. passphrase is only called for encrypted archives.
+func ExtractArchive(r io.Reader, passphrase func() (string, error), dir string) (*Manifest, error) {
+	br := bufio.NewReader(r)
+	var in io.Reader = br
+	if magic, _ := br.Peek(len(archiveMagic)); string(magic) == archiveMagic {
+		br.Discard(len(archiveMagic))
+		if passphrase == nil {
+			return nil, ErrArchivePassphrase
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		if pass == "" {
+			return nil, ErrArchivePassphrase
+		}
+		salt := make([]byte, crypto.SaltSize)
+		if _, err := io.ReadFull(br, salt); err != nil {
+			return nil, fmt.Errorf("read archive salt: %w", err)
+		}
+		key, err := crypto.DeriveKeyWithSalt(pass, salt)
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			err := crypto.DecryptStream(pw, br, key)
+			if err != nil {
+				err = fmt.Errorf("wrong passphrase or corrupt archive: %w", err)
+			}
+			pw.CloseWithError(err)
+		}()
+		defer pr.Close()
+		in = pr
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, archiveFilesDir), 0700); err != nil {
+		return nil, err
+	}
+	got := map[string]ManifestFile{}
+	var manifest *Manifest
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+		if hdr.Name == manifestName {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(io.LimitReader(tr, 64<<20)).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("read manifest: %w", err)
+			}
+			continue
+		}
+		if !validArchiveName(hdr.Name) {
+			return nil, fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+		f, err := os.OpenFile(filepath.Join(dir, filepath.FromSlash(hdr.Name)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(f, h), tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", hdr.Name, err)
+		}
+		got[hdr.Name] = ManifestFile{Name: hdr.Name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return nil, errors.New("archive has no manifest (truncated?)")
+	}
+	if manifest.Format > archiveFormat {
+		return nil, fmt.Errorf("archive format %d is newer than this binary supports", manifest.Format)
+	}
+	for _, want := range manifest.Files {
+		have, ok := got[want.Name]
+		if !ok {
+			return nil, fmt.Errorf("archive is missing %s", want.Name)
+		}
+		if have != want {
+			return nil, fmt.Errorf("checksum mismatch for %s", want.Name)
+		}
+		delete(got, want.Name)
+	}
+	for name := range got {
+		return nil, fmt.Errorf("archive entry %s is not in the manifest", name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotName)); err != nil {
+		return nil, errors.New("archive has no database snapshot")
+	}
+	return manifest, nil
+}
+
+// validArchiveName accepts the snapshot and flat names under files/.
+func validArchiveName(name string) bool {
+	if name == snapshotName {
+		return true
+	}
+	base, ok := strings.CutPrefix(name, archiveFilesDir)
+	return ok && base != "" && !strings.ContainsAny(base, `/\`) && base != "." && base != ".."
+}
+
+// ImportResult counts what Import did.
+type ImportResult struct {
+	Imported int // shares added
+	Skipped  int // shares already in the store
+	Missing  int // shares whose file is not in the archive
+	Events   int // download log entries copied
+	Changes  int // share changes copied
+}
+
+// Import merges the shares in an archive extracted to dir into the store,
+// with their files, download logs and change history. Shares whose ID is
+// already present are left alone, so importing into a populated store, or
+// the same archive twice, is safe. Share keys are unwrapped with srcKEK
+// (nil: the store's own KEK) and wrapped again under the store's KEK.
+// Files are moved out of dir.
+func (s *Store) Import(ctx context.Context, dir string, srcKEK []byte) (*ImportResult, error) {
+	meta, err := NewSQLite(filepath.Join(dir, snapshotName))
+	if err != nil {
+		return nil, fmt.Errorf("open snapshot: %w", err)
+	}
+	src, err := New(meta, dir)
+	if err != nil {
+		meta.Close()
+		return nil, err
+	}
+	defer src.Close()
+
+	if srcKEK == nil {
+		srcKEK = s.kek
+	}
+	configured, err := src.KEKConfigured(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !configured:
+		src.kek = srcKEK
+	case srcKEK == nil:
+		return nil, ErrNoKEK
+	default:
+		if err := src.SetKEK(ctx, srcKEK); err != nil {
+			if errors.Is(err, ErrWrongKEK) {
+				return nil, ErrArchiveKEK
+			}
+			return nil, err
+		}
+	}
+
+	// Keep imported IP hashes comparable unless this store already has its
+	// own key.
+	if have, err := s.Setting(ctx, ipHashKeySetting); err != nil {
+		return nil, err
+	} else if have == "" {
+		key, err := src.Setting(ctx, ipHashKeySetting)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.SetSetting(ctx, ipHashKeySetting, key); err != nil {
+			return nil, err
+		}
+	}
+
+	shares, err := src.meta.AllShares(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &ImportResult{}
+	for _, sh := range shares {
+		if _, err := s.meta.GetShare(ctx, sh.ID); err == nil {
+			res.Skipped++
+			continue
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		path := filepath.Join(dir, archiveFilesDir, blob.Key(sh.ID))
+		if _, err := os.Stat(path); err != nil {
+			res.Missing++
+			continue
+		}
+		if err := src.openKey(sh); err != nil {
+			return nil, err
+		}
+		if err := s.importShare(ctx, src, sh, path, res); err != nil {
+			return nil, fmt.Errorf("share %s: %w", sh.ID, err)
+		}
+		res.Imported++
+	}
+	return res, nil
+}
+
+// importShare stores one share's file, row and history.
+func (s *Store) importShare(ctx context.Context, src *Store, sh *Share, path string, res *ImportResult) error {
+	sh.BlobKey = blob.Key(sh.ID)
+	if err := blob.PutFile(ctx, s.blobs, sh.BlobKey, path); err != nil {
+		return err
+	}
+	if err := s.Create(ctx, sh); err != nil {
+		s.blobs.Delete(ctx, sh.BlobKey)
+		return err
+	}
+
+	events, err := src.meta.DownloadEvents(ctx, sh.ID)
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		if err := s.meta.RecordDownload(ctx, ev); err != nil {
+			return err
+		}
+		res.Events++
+	}
+	changes, err := src.meta.ShareChanges(ctx, sh.ID)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := s.meta.RecordShareChange(ctx, c); err != nil {
+			return err
+		}
+		res.Changes++
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package share
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// ipHashKeySetting names the setting holding the secret that client IPs are
+// hashed with before they are written to the download log.
+const ipHashKeySetting = "ip_hash_key"
+
+// DownloadEvent is one entry in the download audit log: a single transfer of
+// a share's file, finished or not. Events outlive their share so the history
+// of a revoked or burned share can still be read; they are removed by
+// PurgeDownloadEvents.
+type DownloadEvent struct {
+	ID        int64
+	ShareID   string
+	Time      time.Time
+	IPHash    string // keyed hash of the client IP; see HashIP
+	UserAgent string
+	Bytes     int64 // bytes written to the client
+	Completed bool  // false if the transfer was aborted
+}
+
+// RecordDownload appends ev to the download log.
+func (s *Store) RecordDownload(ctx context.Context, ev *DownloadEvent) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	return s.meta.RecordDownload(ctx, ev)
+}
+
+// DownloadEvents returns the logged downloads of a share, oldest first. The
+// share itself need not exist any more.
+func (s *Store) DownloadEvents(ctx context.Context, shareID string) ([]*DownloadEvent, error) {
+	return s.meta.DownloadEvents(ctx, shareID)
+}
+
+// PurgeDownloadEvents removes log entries older than retention.
+func (s *Store) PurgeDownloadEvents(ctx context.Context, retention time.Duration) (int, error) {
+	return s.meta.PurgeDownloadEvents(ctx, time.Now().Add(-retention))
+}
+
+// HashIP returns a short keyed hash of a client IP for the download log, so
+// repeat downloads from one address can be told apart without storing the
+// address. The key is generated on first use and kept in the settings table;
+// it never leaves the database.
+func (s *Store) HashIP(ctx context.Context, ip string) (string, error) {
+	key, err := s.ipHashKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:8]), nil
+}
+
+func (s *Store) ipHashKey(ctx context.Context) ([]byte, error) {
+	s.ipKeyMu.Lock()
+	defer s.ipKeyMu.Unlock()
+	if s.ipKey != nil {
+		return s.ipKey, nil
+	}
+
+	stored, err := s.meta.Setting(ctx, ipHashKeySetting)
+	if err != nil {
+		return nil, err
+	}
+	if stored == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		if err := s.meta.SetSetting(ctx, ipHashKeySetting, hex.EncodeToString(b)); err != nil {
+			return nil, err
+		}
+		stored = hex.EncodeToString(b)
+	}
+	key, err := hex.DecodeString(stored)
+	if err != nil {
+		return nil, fmt.Errorf("%s setting: %w", ipHashKeySetting, err)
+	}
+	s.ipKey = key
+	return key, nil
+}
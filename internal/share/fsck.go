@@ -0,0 +1,245 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/unisoniq/durins-door/internal/blob"
+	"github.com/unisoniq/durins-door/internal/crypto"
+	"github.com/unisoniq/durins-door/internal/webcrypto"
+)
+
+// ProblemKind classifies an inconsistency found by Fsck.
+type ProblemKind string
+
+const (
+	// ProblemOrphanBlob is a file in the blob store that no share refers to.
+	ProblemOrphanBlob ProblemKind = "orphan_blob"
+	// ProblemMissingBlob is a share whose file is gone.
+	ProblemMissingBlob ProblemKind = "missing_blob"
+	// ProblemSizeMismatch is a share whose file is not the size its
+	// recorded file size implies. Repair leaves it alone.
+	ProblemSizeMismatch ProblemKind = "size_mismatch"
+	// ProblemBadKey is a share whose stored key cannot be unwrapped.
+	ProblemBadKey ProblemKind = "bad_key"
+	// ProblemBadHeader is a share whose file does not start with a chunk
+	// that decrypts under its key.
+	ProblemBadHeader ProblemKind = "bad_header"
+)
+
+// QuarantinePrefix is the blob key prefix under which Repair keeps files it
+// takes out of service. Fsck ignores keys under it.
+const QuarantinePrefix = "quarantine/"
+
+// orphanGrace is how old a file must be before Fsck calls it an orphan. An
+// upload writes its file before the share row, so a younger file may belong
+// to an upload that is just finishing.
+const orphanGrace = time.Hour
+
+// Problem is one inconsistency between share rows and the blob store.
+type Problem struct {
+	Kind    ProblemKind `json:"kind"`
+	ShareID string      `json:"share_id,omitempty"` // empty for orphan blobs
+	BlobKey string      `json:"blob_key"`
+	Detail  string      `json:"detail"`
+}
+
+// Repairable reports whether Repair fixes p. A size mismatch is left for the
+// operator: the file may still decrypt, and guessing wrong deletes a share.
+func (p Problem) Repairable() bool {
+	return p.Kind != ProblemSizeMismatch
+}
+
+// FsckReport is the result of a consistency check.
+type FsckReport struct {
+	Shares int `json:"shares"`
+	Blobs  int `json:"blobs"`
+	// HeadersChecked is false when the KEK needed to open share keys was
+	// not configured, so ProblemBadKey and ProblemBadHeader were not looked
+	// for.
+	HeadersChecked bool      `json:"headers_checked"`
+	Problems       []Problem `json:"problems"`
+}
+
+// Fsck compares every share row, trashed ones included, with the blob store.
+// It reports files no share refers to, shares whose file is missing or has
+// the wrong size, and, when the share keys can be opened, files whose first
+// chunk does not decrypt. Client-encrypted files are only size-checked,
+// since the server holds no key for them. Nothing is changed; see Repair.
+func (s *Store) Fsck(ctx context.Context) (*FsckReport, error) {
+	shares, err := s.meta.AllShares(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list shares: %w", err)
+	}
+	configured, err := s.KEKConfigured(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &FsckReport{
+		Shares:         len(shares),
+		HeadersChecked: s.kek != nil || !configured,
+		Problems:       []Problem{},
+	}
+
+	referenced := make(map[string]bool, len(shares))
+	for _, sh := range shares {
+		referenced[sh.BlobKey] = true
+		if filepath.IsAbs(sh.BlobKey) {
+			// Legacy rows hold the absolute path of a file that usually
+			// sits in the local store's root.
+			referenced[filepath.Base(sh.BlobKey)] = true
+		}
+		p, err := s.checkShare(ctx, sh, report.HeadersChecked)
+		if err != nil {
+			return nil, fmt.Errorf("share %s: %w", sh.ID, err)
+		}
+		if p != nil {
+			report.Problems = append(report.Problems, *p)
+		}
+	}
+
+	cutoff := time.Now().Add(-orphanGrace)
+	err = s.blobs.List(ctx, func(key string, info blob.Info) error {
+		if strings.HasPrefix(key, QuarantinePrefix) {
+			return nil
+		}
+		report.Blobs++
+		if referenced[key] || info.ModTime.After(cutoff) {
+			return nil
+		}
+		report.Problems = append(report.Problems, Problem{
+			Kind:    ProblemOrphanBlob,
+			BlobKey: key,
+			Detail:  fmt.Sprintf("%d bytes, modified %s", info.Size, info.ModTime.UTC().Format(time.RFC3339)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+	return report, nil
+}
+
+// checkShare checks one share's file and returns the first problem found.
+// Errors reading the blob store other than a missing file are returned
+// rather than reported, so a flaky store is not mistaken for damage.
+func (s *Store) checkShare(ctx context.Context, sh *Share, checkHeader bool) (*Problem, error) {
+	problem := func(kind ProblemKind, format string, args ...any) (*Problem, error) {
+		return &Problem{Kind: kind, ShareID: sh.ID, BlobKey: sh.BlobKey, Detail: fmt.Sprintf(format, args...)}, nil
+	}
+
+	info, err := s.blobs.Stat(ctx, sh.BlobKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		return problem(ProblemMissingBlob, "file not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sh.ClientEncrypted {
+		// Uploads through the API record the ciphertext size; the web app
+		// and "share" record the size before encryption, which a sealed
+		// blob adds its header to, or a streamed one its chunk framing.
+		plain := sh.Size + webcrypto.IVSize + crypto.TagSize
+		if info.Size != sh.Size && info.Size != plain && info.Size != plain+webcrypto.SealOverhead &&
+			info.Size != webcrypto.StreamSize(sh.Size) {
+			return problem(ProblemSizeMismatch, "file is %d bytes, share records %d", info.Size, sh.Size)
+		}
+		return nil, nil
+	}
+
+	var offset int64
+	if sh.SaltHex != "" {
+		offset = crypto.SaltSize
+	}
+	if want := offset + crypto.EncryptedSize(sh.Size); info.Size != want {
+		// Resumable uploads end a short chunk at every PATCH, so only a
+		// file written in one pass has the exact size. Walk the chunks
+		// before calling it damaged.
+		f, err := s.blobs.GetRange(ctx, sh.BlobKey, offset, info.Size-offset)
+		if err != nil {
+			return nil, err
+		}
+		size, err := crypto.PlaintextSize(f)
+		f.Close()
+		if err != nil {
+			return problem(ProblemSizeMismatch, "file is %d bytes and its chunks do not add up: %v", info.Size, err)
+		}
+		if size != sh.Size {
+			return problem(ProblemSizeMismatch, "file holds %d bytes of data, share records %d", size, sh.Size)
+		}
+	}
+	if !checkHeader {
+		return nil, nil
+	}
+
+	if err := s.openKey(sh); err != nil {
+		return problem(ProblemBadKey, "%v", err)
+	}
+	key, err := crypto.KeyFromHex(sh.KeyHex)
+	if err != nil {
+		return problem(ProblemBadKey, "%v", err)
+	}
+	// Only the nonce and the first chunk are needed.
+	head := int64(crypto.NonceSize + 4 + crypto.NonceSize + crypto.ChunkSize + crypto.TagSize)
+	f, err := s.blobs.GetRange(ctx, sh.BlobKey, offset, min(head, info.Size-offset))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := crypto.CheckHeader(f, key); err != nil {
+		return problem(ProblemBadHeader, "%v", err)
+	}
+	return nil, nil
+}
+
+// Repair fixes a problem reported by Fsck. A file that no share refers to,
+// or whose share is broken, is moved under QuarantinePrefix, or deleted if
+// del is set. The row of a share whose file is missing or broken is deleted,
+// since the share can no longer be downloaded. Problems that are not
+// Repairable are refused.
+func (s *Store) Repair(ctx context.Context, p Problem, del bool) error {
+	if !p.Repairable() {
+		return fmt.Errorf("%s is not repaired automatically", p.Kind)
+	}
+	if p.Kind != ProblemMissingBlob {
+		if err := s.retireBlob(ctx, p.BlobKey, del); err != nil {
+			return err
+		}
+	}
+	if p.ShareID == "" {
+		return nil
+	}
+	if err := s.meta.DeleteShare(ctx, p.ShareID); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("delete share %s: %w", p.ShareID, err)
+	}
+	return nil
+}
+
+// retireBlob deletes a file or moves it under QuarantinePrefix.
+func (s *Store) retireBlob(ctx context.Context, key string, del bool) error {
+	if !del {
+		src, err := s.blobs.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", key, err)
+		}
+		// Legacy rows hold absolute paths; keep only the file name.
+		dst := QuarantinePrefix + key
+		if filepath.IsAbs(key) {
+			dst = QuarantinePrefix + filepath.Base(key)
+		}
+		err = s.blobs.Put(ctx, dst, src, -1)
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("quarantine %s: %w", key, err)
+		}
+	}
+	if err := s.blobs.Delete(ctx, key); err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package share
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/unisoniq/durins-door/internal/crypto"
+)
+
+// Share keys are stored wrapped (envelope-encrypted) under a server master
+// key, the KEK, which never touches the data dir. A wrapped key is stored as
+// "kek1:" + base64(nonce || AES-GCM(key)), with the share ID as associated
+// data. Rows without the prefix are legacy plaintext keys; they are still
+// readable and get wrapped by RotateKEK.
+
+const wrappedKeyPrefix = "kek1:"
+
+// kekCheckAAD is the associated data of the kek_check setting, a wrapped
+// all-zero key used to tell whether a configured KEK is the right one.
+const kekCheckAAD = "durins-door kek check"
+
+// ErrNoKEK is returned when a share key must be wrapped or unwrapped but no
+// key-encryption key has been configured.
+var ErrNoKEK = errors.New("no key-encryption key configured")
+
+// ErrWrongKEK is returned by SetKEK when the key does not match the one the
+// data dir was set up with.
+var ErrWrongKEK = errors.New("key-encryption key does not match this data dir")
+
+// SetKEK installs the key-encryption key used to wrap and unwrap share keys.
+// The first KEK used with a data dir is remembered (as a check value, not the
+// key itself); any other key is rejected with ErrWrongKEK.
+func (s *Store) SetKEK(ctx context.Context, kek []byte) error {
+	check, err := s.Setting(ctx, "kek_check")
+	if err != nil {
+		return err
+	}
+	if check == "" {
+		check, err = newKEKCheck(kek)
+		if err != nil {
+			return err
+		}
+		if err := s.SetSetting(ctx, "kek_check", check); err != nil {
+			return err
+		}
+	} else if !kekMatches(kek, check) {
+		return ErrWrongKEK
+	}
+	s.kek = kek
+	return nil
+}
+
+// KEKConfigured reports whether a KEK has ever been set up for this data dir.
+func (s *Store) KEKConfigured(ctx context.Context) (bool, error) {
+	check, err := s.Setting(ctx, "kek_check")
+	return check != "", err
+}
+
+// WrapKey wraps a hex share key for storage. Empty keys (client-encrypted
+// shares) are returned unchanged.
+func (s *Store) WrapKey(id, keyHex string) (string, error) {
+	return wrapKeyWith(s.kek, id, keyHex)
+}
+
+// UnwrapKey returns the hex share key for a stored (possibly wrapped) value.
+// Legacy plaintext keys are returned unchanged.
+func (s *Store) UnwrapKey(id, stored string) (string, error) {
+	return unwrapKeyWith(s.kek, id, stored)
+}
+
+// RotateKEK re-wraps every stored share key under newKEK and wraps any
+// legacy plaintext keys. settings are written in the same transaction (for
+// example a new passphrase salt); an empty value deletes the setting. It
+// returns the number of keys re-wrapped.
+func (s *Store) RotateKEK(ctx context.Context, newKEK []byte, settings map[string]string) (int, error) {
+	check, err := newKEKCheck(newKEK)
+	if err != nil {
+		return 0, err
+	}
+
+	all := map[string]string{"kek_check": check}
+	for name, value := range settings {
+		all[name] = value
+	}
+	n, err := s.meta.RewrapKeys(ctx, func(id, stored string) (string, error) {
+		keyHex, err := unwrapKeyWith(s.kek, id, stored)
+		if err != nil {
+			return "", err
+		}
+		return wrapKeyWith(newKEK, id, keyHex)
+	}, all)
+	if err != nil {
+		return 0, err
+	}
+	s.kek = newKEK
+	return n, nil
+}
+
+// Setting returns a stored server setting, or "" if unset.
+func (s *Store) Setting(ctx context.Context, name string) (string, error) {
+	return s.meta.Setting(ctx, name)
+}
+
+// SetSetting stores a server setting; "" deletes it.
+func (s *Store) SetSetting(ctx context.Context, name, value string) error {
+	return s.meta.SetSetting(ctx, name, value)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type storedKey struct{ id, key string }
+
+// queryStoredKeys reads (id, key) pairs for RewrapKeys.
+func queryStoredKeys(ctx context.Context, db querier, query string) ([]storedKey, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	defer rows.Close()
+	var keys []storedKey
+	for rows.Next() {
+		var k storedKey
+		if err := rows.Scan(&k.id, &k.key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func wrapKeyWith(kek []byte, id, keyHex string) (string, error) {
+	if keyHex == "" {
+		return "", nil
+	}
+	if kek == nil {
+		return "", ErrNoKEK
+	}
+	key, err := crypto.KeyFromHex(keyHex)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := crypto.WrapKey(kek, key, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return wrappedKeyPrefix + base64.RawStdEncoding.EncodeToString(wrapped), nil
+}
+
+func unwrapKeyWith(kek []byte, id, stored string) (string, error) {
+	if !strings.HasPrefix(stored, wrappedKeyPrefix) {
+		return stored, nil
+	}
+	if kek == nil {
+		return "", ErrNoKEK
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, wrappedKeyPrefix))
+	if err != nil {
+		return "", fmt.Errorf("decode wrapped key: %w", err)
+	}
+	key, err := crypto.UnwrapKey(kek, wrapped, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return crypto.KeyToHex(key), nil
+}
+
+func newKEKCheck(kek []byte) (string, error) {
+	wrapped, err := crypto.WrapKey(kek, make([]byte, crypto.KeySize), []byte(kekCheckAAD))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(wrapped), nil
+}
+
+func kekMatches(kek []byte, check string) bool {
+	wrapped, err := base64.RawStdEncoding.DecodeString(check)
+	if err != nil {
+		return false
+	}
+	_, err = crypto.UnwrapKey(kek, wrapped, []byte(kekCheckAAD))
+	return err == nil
+}
@@ -0,0 +1,96 @@
+package share
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidLabel is returned (wrapped with the reason) for a tag or note
+// that cannot be stored.
+var ErrInvalidLabel = errors.New("invalid label")
+
+const (
+	// MaxTags is the most tags a share can carry.
+	MaxTags = 10
+	// MaxNoteLength is the longest note, in characters.
+	MaxNoteLength = 500
+)
+
+// tagPattern is what a normalized tag looks like. Commas are excluded, so
+// the SQLite store can keep a share's tags in one column.
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// NormalizeTags lowercases, trims, de-duplicates and sorts tags, dropping
+// empty ones. Tags are 1-32 characters of a-z, 0-9, '.', '_' and '-',
+// starting with a letter or digit.
+func NormalizeTags(tags []string) ([]string, error) {
+	var out []string
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if !tagPattern.MatchString(t) {
+			return nil, fmt.Errorf("%w: tag %q must be 1-32 characters of a-z, 0-9, '.', '_' or '-'", ErrInvalidLabel, t)
+		}
+		if !slices.Contains(out, t) {
+			out = append(out, t)
+		}
+	}
+	if len(out) > MaxTags {
+		return nil, fmt.Errorf("%w: at most %d tags", ErrInvalidLabel, MaxTags)
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// NormalizeNote trims a note and checks its length.
+func NormalizeNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > MaxNoteLength {
+		return "", fmt.Errorf("%w: note is longer than %d characters", ErrInvalidLabel, MaxNoteLength)
+	}
+	return note, nil
+}
+
+// Share statuses, as reported by Share.Status and matched by ListFilter.
+const (
+	StatusActive    = "active"
+	StatusEmbargoed = "embargoed"
+	StatusExpired   = "expired"
+	StatusExhausted = "exhausted"
+	StatusTrashed   = "trashed"
+)
+
+// Status returns the share's state: trashed, expired, exhausted, embargoed
+// or active, the first that applies.
+func (s *Share) Status() string {
+	switch {
+	case s.IsTrashed():
+		return StatusTrashed
+	case s.IsExpired():
+		return StatusExpired
+	case s.IsExhausted():
+		return StatusExhausted
+	case s.IsEmbargoed():
+		return StatusEmbargoed
+	default:
+		return StatusActive
+	}
+}
+
+// joinTags and splitTags convert between Share.Tags and the SQLite column.
+func joinTags(tags []string) string {
+	return strings.Join(tags, ",")
+}
+
+func splitTags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
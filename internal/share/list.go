@@ -0,0 +1,271 @@
+package share
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned by List for a cursor that is malformed or
+// was issued for a different sort order.
+var ErrInvalidCursor = errors.New("invalid list cursor")
+
+// Sort keys for ListQuery.Sort.
+const (
+	SortCreated   = "created"
+	SortExpires   = "expires"
+	SortSize      = "size"
+	SortName      = "name"
+	SortDownloads = "downloads"
+)
+
+// DefaultSort lists the newest shares first.
+const DefaultSort = "-" + SortCreated
+
+// ListFilter narrows a share listing. Zero fields match everything.
+type ListFilter struct {
+	Tags   []string // shares must carry all of these
+	Search string   // case-insensitive substring of the filename, note or a tag, or an ID prefix
+	Status string   // one of the Status* constants except StatusTrashed
+
+	MinSize, MaxSize int64 // bytes, inclusive; 0 = no bound
+	// The time ranges are half-open: After is inclusive, Before is not.
+	CreatedAfter, CreatedBefore time.Time
+	ExpiresAfter, ExpiresBefore time.Time
+}
+
+// ParseListFilter builds a filter from user input, normalizing the tags
+// and checking the status. The size and time bounds are set by the caller.
+func ParseListFilter(tags []string, search, status string) (ListFilter, error) {
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return ListFilter{}, err
+	}
+	status = strings.ToLower(strings.TrimSpace(status))
+	switch status {
+	case "", StatusActive, StatusEmbargoed, StatusExpired, StatusExhausted:
+	default:
+		return ListFilter{}, fmt.Errorf("unknown status %q (want active, embargoed, expired or exhausted)", status)
+	}
+	return ListFilter{Tags: tags, Search: strings.TrimSpace(search), Status: status}, nil
+}
+
+// IsZero reports whether the filter matches every share.
+func (f ListFilter) IsZero() bool {
+	return len(f.Tags) == 0 && f.Search == "" && f.Status == "" &&
+		f.MinSize == 0 && f.MaxSize == 0 &&
+		f.CreatedAfter.IsZero() && f.CreatedBefore.IsZero() &&
+		f.ExpiresAfter.IsZero() && f.ExpiresBefore.IsZero()
+}
+
+// ListQuery selects, orders and pages the shares returned by List. The zero
+// value lists every share not in the trash, newest first.
+type ListQuery struct {
+	ListFilter
+	// Sort is one of the Sort* keys, prefixed with "-" for descending
+	// order; empty means DefaultSort. Ties are broken by ID.
+	Sort string
+	// Limit is the page size; 0 returns every match.
+	Limit int
+	// Cursor continues a listing after the page that returned it.
+	Cursor string
+}
+
+// ParseSort checks a sort order as accepted by ListQuery.Sort.
+func ParseSort(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return DefaultSort, nil
+	}
+	switch strings.TrimPrefix(s, "-") {
+	case SortCreated, SortExpires, SortSize, SortName, SortDownloads:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown sort %q (want created, expires, size, name or downloads, with - for descending)", s)
+}
+
+// order returns the sort key and direction.
+func (q ListQuery) order() (key string, desc bool) {
+	s := q.Sort
+	if s == "" {
+		s = DefaultSort
+	}
+	return strings.TrimPrefix(s, "-"), strings.HasPrefix(s, "-")
+}
+
+// SharePage is one page of a share listing.
+type SharePage struct {
+	Shares []*Share
+	// Next is the cursor for the following page, or empty on the last one.
+	Next string
+}
+
+// List returns the shares not in the trash that match q, in q's order, a
+// page at a time. Statuses are evaluated at the time of the call.
+func (s *Store) List(ctx context.Context, q ListQuery) (*SharePage, error) {
+	sort, err := ParseSort(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	q.Sort = sort
+	if q.Limit < 0 {
+		return nil, fmt.Errorf("negative limit %d", q.Limit)
+	}
+	if q.Cursor != "" {
+		if _, err := q.cursor(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fetch one row more than asked for to learn whether there is a next
+	// page.
+	fetch := q
+	if q.Limit > 0 {
+		fetch.Limit = q.Limit + 1
+	}
+	shares, err := s.meta.ListShares(ctx, fetch, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	page := &SharePage{Shares: shares}
+	if q.Limit > 0 && len(shares) > q.Limit {
+		page.Shares = shares[:q.Limit]
+		page.Next = q.nextCursor(page.Shares[q.Limit-1])
+	}
+	for _, share := range page.Shares {
+		if err := s.openKey(share); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// A cursor is the sort order, the last share's ID and its sort value,
+// base64url-encoded so it can be passed around as an opaque token. Keeping
+// the value rather than only the ID means a page can follow even if that
+// share has been deleted since.
+//
+// Times are encoded in RFC 3339 with nanoseconds; the zero time, which
+// stands for "never" in ExpiresAt, is encoded as "".
+
+// listCursor is a decoded cursor.
+type listCursor struct {
+	ID    string
+	Value any // time.Time, int64 or string, depending on the sort key
+}
+
+func (q ListQuery) nextCursor(last *Share) string {
+	key, _ := q.order()
+	var value string
+	switch key {
+	case SortCreated:
+		value = formatCursorTime(last.CreatedAt)
+	case SortExpires:
+		value = formatCursorTime(last.ExpiresAt)
+	case SortSize:
+		value = strconv.FormatInt(last.Size, 10)
+	case SortDownloads:
+		value = strconv.Itoa(last.Downloads)
+	case SortName:
+		value = last.Filename
+	}
+	raw := q.Sort + "\n" + last.ID + "\n" + value
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// cursor decodes q.Cursor, checking that it was issued for q's sort order.
+// It returns nil if there is no cursor.
+func (q ListQuery) cursor() (*listCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), "\n", 3)
+	if len(parts) != 3 || parts[1] == "" {
+		return nil, ErrInvalidCursor
+	}
+	sort := q.Sort
+	if sort == "" {
+		sort = DefaultSort
+	}
+	if parts[0] != sort {
+		return nil, fmt.Errorf("%w: it belongs to sort %q", ErrInvalidCursor, parts[0])
+	}
+
+	c := &listCursor{ID: parts[1]}
+	key, _ := q.order()
+	switch key {
+	case SortCreated, SortExpires:
+		var t time.Time
+		if parts[2] != "" {
+			if t, err = time.Parse(time.RFC3339Nano, parts[2]); err != nil {
+				return nil, ErrInvalidCursor
+			}
+		}
+		c.Value = t
+	case SortSize, SortDownloads:
+		n, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		c.Value = n
+	default:
+		c.Value = parts[2]
+	}
+	return c, nil
+}
+
+func formatCursorTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// sqlWhere collects the conditions of a query. Conditions are written with
+// "?" placeholders, which are numbered ($1, $2, ...) for Postgres.
+type sqlWhere struct {
+	numbered bool
+	conds    []string
+	args     []any
+}
+
+func (w *sqlWhere) add(cond string, args ...any) {
+	if w.numbered {
+		var b strings.Builder
+		n := len(w.args)
+		for _, r := range cond {
+			if r == '?' {
+				n++
+				fmt.Fprintf(&b, "$%d", n)
+				continue
+			}
+			b.WriteRune(r)
+		}
+		cond = b.String()
+	}
+	w.conds = append(w.conds, cond)
+	w.args = append(w.args, args...)
+}
+
+func (w *sqlWhere) String() string {
+	if len(w.conds) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(w.conds, " AND ")
+}
+
+// limitClause returns the LIMIT clause for q, if any.
+func (q ListQuery) limitClause() string {
+	if q.Limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d", q.Limit)
+}
@@ -0,0 +1,283 @@
+package share
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLite schema changes are numbered migrations embedded from migrations/:
+// NNNN_name.up.sql applies a change and NNNN_name.down.sql reverts it. The
+// applied versions are recorded in schema_migrations; each migration runs in
+// its own transaction together with that bookkeeping. The Postgres schema is
+// managed by supabase/migrations instead.
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this binary does not know about, i.e. it was written by a newer version.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// Migration describes one schema migration.
+type Migration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time // zero while pending
+	Unknown   bool      // applied by a newer binary; no SQL available here
+
+	up, down string
+}
+
+// Applied reports whether the migration has been applied.
+func (m Migration) Applied() bool {
+	return !m.AppliedAt.IsZero()
+}
+
+// loadMigrations parses the embedded migration files, ordered by version.
+func loadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		var dir string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			dir = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			dir = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(name, "."+dir+".sql")
+		num, label, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("bad migration file name %q", name)
+		}
+		body, err := fs.ReadFile(migrationFS, "migrations/"+name)
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if dir == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrations returns every known migration plus any applied ones this binary
+// does not know, ordered by version.
+func (m *SQLite) Migrations(ctx context.Context) ([]Migration, error) {
+	known, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []Migration
+	for _, mig := range known {
+		if a, ok := applied[mig.Version]; ok {
+			mig.AppliedAt = a.AppliedAt
+			delete(applied, mig.Version)
+		}
+		all = append(all, mig)
+	}
+	for _, a := range applied {
+		a.Unknown = true
+		all = append(all, a)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all, nil
+}
+
+// MigrateUp applies all pending migrations in order and returns them. It
+// fails with ErrSchemaTooNew if the database is ahead of this binary.
+func (m *SQLite) MigrateUp(ctx context.Context) ([]Migration, error) {
+	all, err := m.Migrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnown(all); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range all {
+		if mig.Applied() {
+			continue
+		}
+		if mig.Version == 1 {
+			if err := m.adoptLegacy(ctx); err != nil {
+				return done, err
+			}
+		}
+		mig.AppliedAt = time.Now()
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, mig.up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				mig.Version, mig.Name, mig.AppliedAt.Unix())
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the most recently applied migration and returns it,
+// or nil if none is applied.
+func (m *SQLite) MigrateDown(ctx context.Context) (*Migration, error) {
+	all, err := m.Migrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKnown(all); err != nil {
+		return nil, err
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		mig := all[i]
+		if !mig.Applied() {
+			continue
+		}
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, mig.down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, mig.Version)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("revert %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		mig.AppliedAt = time.Time{}
+		return &mig, nil
+	}
+	return nil, nil
+}
+
+func (m *SQLite) appliedMigrations(ctx context.Context) (map[int]Migration, error) {
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)`)
+	if err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	rows, err := m.db.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]Migration{}
+	for rows.Next() {
+		var mig Migration
+		var appliedAt int64
+		if err := rows.Scan(&mig.Version, &mig.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		mig.AppliedAt = time.Unix(appliedAt, 0)
+		applied[mig.Version] = mig
+	}
+	return applied, rows.Err()
+}
+
+// adoptLegacy brings a database created before migrations were tracked up
+// to the shape of migration 1, which then applies as a no-op. Those
+// databases gained columns through unchecked ALTER TABLEs, so any of them
+// may be missing.
+func (m *SQLite) adoptLegacy(ctx context.Context) error {
+	cols, err := m.columns(ctx, "shares")
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+	for _, c := range []struct{ name, ddl string }{
+		{"salt_hex", `ALTER TABLE shares ADD COLUMN salt_hex TEXT NOT NULL DEFAULT ''`},
+		{"client_encrypted", `ALTER TABLE shares ADD COLUMN client_encrypted INTEGER NOT NULL DEFAULT 0`},
+	} {
+		if cols[c.name] {
+			continue
+		}
+		if _, err := m.db.ExecContext(ctx, c.ddl); err != nil {
+			return fmt.Errorf("upgrade legacy schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// columns returns the column names of table, or nil if it does not exist.
+func (m *SQLite) columns(ctx context.Context, table string) (map[string]bool, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+func (m *SQLite) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func checkKnown(all []Migration) error {
+	for _, mig := range all {
+		if mig.Unknown {
+			return fmt.Errorf("%w (migration %04d_%s); upgrade durins-door", ErrSchemaTooNew, mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
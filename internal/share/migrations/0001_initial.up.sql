@@ -0,0 +1,36 @@
+-- Schema as of the first tracked version. Databases created before
+-- migrations were tracked already have these tables; see adoptLegacy.
+
+CREATE TABLE IF NOT EXISTS shares (
+	id               TEXT PRIMARY KEY,
+	filename         TEXT NOT NULL,
+	encrypted_path   TEXT NOT NULL,
+	key_hex          TEXT NOT NULL,
+	salt_hex         TEXT NOT NULL DEFAULT '',
+	created_at       INTEGER NOT NULL,
+	expires_at       INTEGER NOT NULL,
+	max_downloads    INTEGER NOT NULL DEFAULT 0,
+	downloads        INTEGER NOT NULL DEFAULT 0,
+	password_hash    TEXT NOT NULL DEFAULT '',
+	admin_token      TEXT NOT NULL DEFAULT '',
+	size             INTEGER NOT NULL DEFAULT 0,
+	client_encrypted INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_shares_expires ON shares(expires_at);
+
+CREATE TABLE IF NOT EXISTS handshakes (
+	id                  TEXT PRIMARY KEY,
+	code                TEXT UNIQUE NOT NULL,
+	receiver_public_key TEXT NOT NULL,
+	sender_public_key   TEXT NOT NULL DEFAULT '',
+	share_id            TEXT NOT NULL DEFAULT '',
+	created_at          INTEGER NOT NULL,
+	expires_at          INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_handshakes_code ON handshakes(code);
+CREATE INDEX IF NOT EXISTS idx_handshakes_expires ON handshakes(expires_at);
+
+CREATE TABLE IF NOT EXISTS settings (
+	name  TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
@@ -0,0 +1,8 @@
+-- Downloads in progress. A claim holds one of a share's download slots
+-- until the transfer finishes (counted) or fails (released).
+CREATE TABLE download_claims (
+	id         TEXT PRIMARY KEY,
+	share_id   TEXT NOT NULL,
+	started_at INTEGER NOT NULL
+);
+CREATE INDEX idx_download_claims_share ON download_claims(share_id, started_at);
@@ -0,0 +1,2 @@
+-- Burn-after-reading shares: deleted with their file after one download.
+ALTER TABLE shares ADD COLUMN burn INTEGER NOT NULL DEFAULT 0;
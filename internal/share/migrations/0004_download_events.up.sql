@@ -0,0 +1,13 @@
+-- Download audit log. Rows are kept after their share is deleted and
+-- removed once older than the server's event retention.
+CREATE TABLE download_events (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	share_id   TEXT NOT NULL,
+	at         INTEGER NOT NULL,
+	ip_hash    TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	bytes      INTEGER NOT NULL DEFAULT 0,
+	completed  INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_download_events_share ON download_events(share_id, at);
+CREATE INDEX idx_download_events_at ON download_events(at);
@@ -0,0 +1,10 @@
+-- Audit trail of share updates (expiry, download limit, password). Rows
+-- are kept after their share is deleted.
+CREATE TABLE share_changes (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	share_id TEXT NOT NULL,
+	at       INTEGER NOT NULL,
+	actor    TEXT NOT NULL DEFAULT '',
+	detail   TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX idx_share_changes_share ON share_changes(share_id, at);
@@ -0,0 +1 @@
+ALTER TABLE shares DROP COLUMN available_from;
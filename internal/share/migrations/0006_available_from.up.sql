@@ -0,0 +1,3 @@
+-- Embargoed shares: not downloadable before available_from (Unix seconds;
+-- 0 = available immediately).
+ALTER TABLE shares ADD COLUMN available_from INTEGER NOT NULL DEFAULT 0;
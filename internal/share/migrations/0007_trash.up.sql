@@ -0,0 +1,3 @@
+-- Soft delete: revoked shares keep their row and file until the trash is
+-- emptied (Unix seconds; 0 = not trashed).
+ALTER TABLE shares ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE shares DROP COLUMN note;
+ALTER TABLE shares DROP COLUMN tags;
@@ -0,0 +1,4 @@
+-- Labels: comma-separated tags (normalized, so they never contain commas)
+-- and a free-text note.
+ALTER TABLE shares ADD COLUMN tags TEXT NOT NULL DEFAULT '';
+ALTER TABLE shares ADD COLUMN note TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+-- Named API tokens. Only a SHA-256 of each token is kept. Scopes are
+-- comma-separated; times are Unix seconds, 0 = never.
+CREATE TABLE api_tokens (
+	id           TEXT PRIMARY KEY,
+	name         TEXT UNIQUE NOT NULL,
+	token_hash   TEXT UNIQUE NOT NULL,
+	scopes       TEXT NOT NULL,
+	created_at   INTEGER NOT NULL,
+	expires_at   INTEGER NOT NULL DEFAULT 0,
+	last_used_at INTEGER NOT NULL DEFAULT 0
+);
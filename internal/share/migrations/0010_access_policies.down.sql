@@ -0,0 +1,2 @@
+ALTER TABLE shares DROP COLUMN allowed_ips;
+ALTER TABLE shares DROP COLUMN totp_secret;
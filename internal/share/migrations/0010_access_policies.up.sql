@@ -0,0 +1,4 @@
+-- Access policies: a base32 TOTP secret ('' = no second factor) and
+-- comma-separated CIDR prefixes clients must connect from ('' = any).
+ALTER TABLE shares ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+ALTER TABLE shares ADD COLUMN allowed_ips TEXT NOT NULL DEFAULT '';
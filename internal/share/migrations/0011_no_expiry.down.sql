@@ -0,0 +1 @@
+UPDATE shares SET expires_at = -62135596800 WHERE expires_at = 0;
@@ -0,0 +1,4 @@
+-- Shares without an expiry store expires_at = 0 (never expires), like
+-- available_from and deleted_at. Earlier releases stored the Unix time of
+-- Go's zero time instead.
+UPDATE shares SET expires_at = 0 WHERE expires_at < 0;
@@ -0,0 +1,897 @@
+package share
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Postgres stores share metadata in the web app's Supabase database, so the
+// Go server and the Next.js app see the same shares and handshakes. It uses
+// the tables from supabase/migrations as-is: shares and handshakes keep their
+// web column names (size_bytes, download_count, status, ...) and the fields
+// only the Go server needs live in share_secrets, which has RLS enabled and
+// no policies so only the service role can read it. Shares without a
+// share_secrets row were created by the web app and are client-encrypted.
+//
+// IDs are uuid columns. Go share IDs are 32 hex digits, which Postgres
+// accepts as uuid input; they are read back in the same undashed form so
+// links and wrapped keys (which bind the ID) stay stable.
+type Postgres struct {
+	db *sql.DB
+}
+
+// NewPostgres connects to the database at url and checks that the
+// migrations in supabase/migrations have been applied.
+func NewPostgres(ctx context.Context, url string) (*Postgres, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres: %w", err)
+	}
+	m := &Postgres{db: db}
+	if err := m.check(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// check fails with a pointer to the migrations when the schema is missing.
+func (m *Postgres) check(ctx context.Context) error {
+	if err := m.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("connect postgres: %w", err)
+	}
+	for _, table := range []string{"shares", "handshakes", "share_secrets", "server_settings", "download_claims", "download_events", "share_changes", "api_tokens"} {
+		var found sql.NullString
+		if err := m.db.QueryRowContext(ctx, `SELECT to_regclass($1)::text`, table).Scan(&found); err != nil {
+			return fmt.Errorf("check schema: %w", err)
+		}
+		if !found.Valid {
+			return fmt.Errorf("postgres: table %s not found; apply supabase/migrations first", table)
+		}
+	}
+	return nil
+}
+
+const postgresShareColumns = `
+	replace(s.id::text, '-', ''), s.filename, s.storage_path,
+	coalesce(k.key_hex, ''), coalesce(k.salt_hex, ''),
+	coalesce(s.created_at, now()), s.expires_at,
+	coalesce(s.max_downloads, 0), coalesce(s.download_count, 0),
+	coalesce(s.password_hash, ''), coalesce(k.admin_token, ''),
+	s.size_bytes, coalesce(k.client_encrypted, true), s.burn,
+	s.available_from, s.deleted_at,
+	array_to_string(s.tags, ','), coalesce(s.note, ''),
+	coalesce(k.totp_secret, ''), array_to_string(s.allowed_ips, ',')`
+
+const postgresShareFrom = `
+	FROM shares s LEFT JOIN share_secrets k ON k.share_id = s.id`
+
+// CreateShare inserts a share row and its secrets in one transaction.
+func (m *Postgres) CreateShare(ctx context.Context, share *Share) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO shares (id, filename, size_bytes, content_type, storage_path,
+		                    password_hash, max_downloads, download_count, expires_at, created_at, burn,
+		                    available_from, tags, note, allowed_ips)
+		VALUES ($1, $2, $3, 'application/octet-stream', $4, $5, $6, $7, $8, $9, $10, $11,
+		        string_to_array($12, ','), $13, string_to_array($14, ','))`,
+		share.ID,
+		share.Filename,
+		share.Size,
+		share.BlobKey,
+		nullString(share.PasswordHash),
+		nullInt(share.MaxDownloads),
+		share.Downloads,
+		nullTime(share.ExpiresAt),
+		share.CreatedAt,
+		share.Burn,
+		nullTime(share.AvailableFrom),
+		joinTags(share.Tags),
+		nullString(share.Note),
+		joinTags(share.AllowedIPs),
+	)
+	if err != nil {
+		return fmt.Errorf("insert share: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO share_secrets (share_id, key_hex, salt_hex, admin_token, client_encrypted, totp_secret)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		share.ID, share.KeyHex, share.SaltHex, share.AdminToken, share.ClientEncrypted, share.TOTPSecret,
+	)
+	if err != nil {
+		return fmt.Errorf("insert share secrets: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// GetShare returns the share row with the given ID, trashed or not.
+func (m *Postgres) GetShare(ctx context.Context, id string) (*Share, error) {
+	if !isUUID(id) {
+		return nil, ErrNotFound
+	}
+	row := m.db.QueryRowContext(ctx, `
+		SELECT `+postgresShareColumns+postgresShareFrom+`
+		WHERE s.id = $1`, id)
+	share, err := scanPostgresShare(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get share: %w", err)
+	}
+	return share, nil
+}
+
+// postgresSortColumns maps sort keys to the expressions ordered by. NULLs
+// are mapped to values so rows can be compared with a cursor.
+var postgresSortColumns = map[string]string{
+	SortCreated:   "coalesce(s.created_at, 'epoch'::timestamptz)",
+	SortExpires:   "coalesce(s.expires_at, 'infinity'::timestamptz)",
+	SortSize:      "s.size_bytes",
+	SortDownloads: "coalesce(s.download_count, 0)",
+	SortName:      "lower(s.filename)",
+}
+
+// ListShares returns the share rows not in the trash that match q at now,
+// in q's order.
+func (m *Postgres) ListShares(ctx context.Context, q ListQuery, now time.Time) ([]*Share, error) {
+	const (
+		id         = "replace(s.id::text, '-', '')"
+		notExpired = "(s.expires_at IS NULL OR s.expires_at > ?)"
+		exhausted  = "(coalesce(s.max_downloads, 0) > 0 AND coalesce(s.download_count, 0) >= s.max_downloads)"
+	)
+	w := &sqlWhere{numbered: true}
+	w.add("s.deleted_at IS NULL")
+	switch q.Status {
+	case StatusExpired:
+		w.add("s.expires_at <= ?", now)
+	case StatusExhausted:
+		w.add(notExpired+" AND "+exhausted, now)
+	case StatusEmbargoed:
+		w.add(notExpired+" AND NOT "+exhausted+" AND s.available_from > ?", now, now)
+	case StatusActive:
+		w.add(notExpired+" AND NOT "+exhausted+" AND (s.available_from IS NULL OR s.available_from <= ?)", now, now)
+	}
+	if len(q.Tags) > 0 {
+		w.add("s.tags @> string_to_array(?, ',')", joinTags(q.Tags))
+	}
+	if q.Search != "" {
+		s := strings.ToLower(q.Search)
+		w.add("(strpos("+id+", ?) = 1 OR strpos(lower(s.filename), ?) > 0 OR strpos(lower(coalesce(s.note, '')), ?) > 0 OR strpos(array_to_string(s.tags, ','), ?) > 0)", s, s, s, s)
+	}
+	if q.MinSize > 0 {
+		w.add("s.size_bytes >= ?", q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		w.add("s.size_bytes <= ?", q.MaxSize)
+	}
+	if !q.CreatedAfter.IsZero() {
+		w.add("s.created_at >= ?", q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		w.add("s.created_at < ?", q.CreatedBefore)
+	}
+	if !q.ExpiresAfter.IsZero() {
+		w.add("(s.expires_at IS NULL OR s.expires_at >= ?)", q.ExpiresAfter)
+	}
+	if !q.ExpiresBefore.IsZero() {
+		w.add("s.expires_at < ?", q.ExpiresBefore)
+	}
+
+	key, desc := q.order()
+	col := postgresSortColumns[key]
+	dir, cmp := "ASC", ">"
+	if desc {
+		dir, cmp = "DESC", "<"
+	}
+	c, err := q.cursor()
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		switch v := c.Value.(type) {
+		case time.Time:
+			// The zero time is a share that never expires.
+			var value any = v
+			if v.IsZero() {
+				value = "infinity"
+			}
+			w.add("("+col+", "+id+") "+cmp+" (?::timestamptz, ?)", value, c.ID)
+		case string:
+			w.add("("+col+", "+id+") "+cmp+" (lower(?), ?)", v, c.ID)
+		default:
+			w.add("("+col+", "+id+") "+cmp+" (?, ?)", v, c.ID)
+		}
+	}
+
+	return m.queryShares(ctx, `
+		SELECT `+postgresShareColumns+postgresShareFrom+`
+		WHERE `+w.String()+`
+		ORDER BY `+col+` `+dir+`, `+id+` `+dir+q.limitClause(), w.args...)
+}
+
+// AllShares returns every share row, including trashed, expired and
+// exhausted ones, oldest first.
+func (m *Postgres) AllShares(ctx context.Context) ([]*Share, error) {
+	return m.queryShares(ctx, `
+		SELECT `+postgresShareColumns+postgresShareFrom+`
+		ORDER BY s.created_at`)
+}
+
+// TrashedShares returns the shares in the trash, most recently trashed first.
+func (m *Postgres) TrashedShares(ctx context.Context) ([]*Share, error) {
+	return m.queryShares(ctx, `
+		SELECT `+postgresShareColumns+postgresShareFrom+`
+		WHERE s.deleted_at IS NOT NULL
+		ORDER BY s.deleted_at DESC`)
+}
+
+// TrashShare marks an active share as trashed at at.
+func (m *Postgres) TrashShare(ctx context.Context, id string, at time.Time) error {
+	if !isUUID(id) {
+		return ErrNotFound
+	}
+	result, err := m.db.ExecContext(ctx,
+		`UPDATE shares SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL`, id, at)
+	if err != nil {
+		return fmt.Errorf("trash share: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RestoreShare takes a share out of the trash.
+func (m *Postgres) RestoreShare(ctx context.Context, id string) error {
+	if !isUUID(id) {
+		return ErrNotFound
+	}
+	result, err := m.db.ExecContext(ctx,
+		`UPDATE shares SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return fmt.Errorf("restore share: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ClaimDownload records claim if the share is still available at now. The
+// share row is locked first so concurrent claims are counted one at a time;
+// the conditions match the increment_download_count RPC the web app uses.
+func (m *Postgres) ClaimDownload(ctx context.Context, claim *DownloadClaim, now, staleBefore time.Time) (bool, error) {
+	if !isUUID(claim.ShareID) {
+		return false, nil
+	}
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM shares WHERE id = $1 FOR UPDATE`, claim.ShareID); err != nil {
+		return false, fmt.Errorf("claim download: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO download_claims (id, share_id, started_at)
+		SELECT $1, s.id, $2 FROM shares s
+		WHERE s.id = $3 AND s.deleted_at IS NULL
+		  AND (s.expires_at IS NULL OR s.expires_at > $2)
+		  AND (s.available_from IS NULL OR s.available_from <= $2)
+		  AND (s.max_downloads IS NULL OR s.max_downloads <= 0 OR coalesce(s.download_count, 0) + (
+		       SELECT COUNT(*) FROM download_claims c
+		       WHERE c.share_id = s.id AND c.started_at > $4) < s.max_downloads)`,
+		claim.ID, now, claim.ShareID, staleBefore)
+	if err != nil {
+		return false, fmt.Errorf("claim download: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit: %w", err)
+	}
+	return n == 1, nil
+}
+
+// RefreshDownload moves the claim's start to now.
+func (m *Postgres) RefreshDownload(ctx context.Context, claim *DownloadClaim, now time.Time) error {
+	if _, err := m.db.ExecContext(ctx,
+		`UPDATE download_claims SET started_at = $1 WHERE id = $2`, now, claim.ID); err != nil {
+		return fmt.Errorf("refresh download: %w", err)
+	}
+	return nil
+}
+
+// CommitDownload drops the claim and counts the download if the share is
+// under its limit.
+func (m *Postgres) CommitDownload(ctx context.Context, claim *DownloadClaim) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM download_claims WHERE id = $1`, claim.ID); err != nil {
+		return fmt.Errorf("commit download: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE shares SET download_count = coalesce(download_count, 0) + 1
+		 WHERE id = $1 AND (max_downloads IS NULL OR max_downloads <= 0 OR coalesce(download_count, 0) < max_downloads)`, claim.ShareID); err != nil {
+		return fmt.Errorf("commit download: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ReleaseDownload drops the claim.
+func (m *Postgres) ReleaseDownload(ctx context.Context, claim *DownloadClaim) error {
+	if _, err := m.db.ExecContext(ctx, `DELETE FROM download_claims WHERE id = $1`, claim.ID); err != nil {
+		return fmt.Errorf("release download: %w", err)
+	}
+	return nil
+}
+
+// PurgeDownloadClaims removes claims started before before.
+func (m *Postgres) PurgeDownloadClaims(ctx context.Context, before time.Time) (int, error) {
+	result, err := m.db.ExecContext(ctx,
+		`DELETE FROM download_claims WHERE started_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// DeleteShare removes a share row; its secrets go with it (on delete
+// cascade).
+func (m *Postgres) DeleteShare(ctx context.Context, id string) error {
+	if !isUUID(id) {
+		return nil
+	}
+	if _, err := m.db.ExecContext(ctx, `DELETE FROM shares WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("delete share: %w", err)
+	}
+	return nil
+}
+
+// PurgeableShares returns the shares that expired before now or have no
+// downloads left. Shares without an expiry (possible for web uploads) never
+// expire.
+func (m *Postgres) PurgeableShares(ctx context.Context, now time.Time) ([]*Share, error) {
+	return m.queryShares(ctx, `
+		SELECT `+postgresShareColumns+postgresShareFrom+`
+		WHERE s.deleted_at IS NULL
+		  AND (s.expires_at < $1
+		       OR (s.max_downloads > 0 AND coalesce(s.download_count, 0) >= s.max_downloads))`, now)
+}
+
+// ActiveCount returns the number of shares that have not expired by now.
+func (m *Postgres) ActiveCount(ctx context.Context, now time.Time) (int, error) {
+	var count int
+	err := m.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM shares WHERE deleted_at IS NULL AND (expires_at IS NULL OR expires_at > $1)`, now).Scan(&count)
+	return count, err
+}
+
+// UpdateShare applies u and records change in one transaction.
+func (m *Postgres) UpdateShare(ctx context.Context, id string, u ShareUpdate, change *ShareChange) error {
+	if !isUUID(id) {
+		return ErrNotFound
+	}
+	var sets []string
+	args := []any{id}
+	if u.ExpiresAt != nil {
+		args = append(args, nullTime(*u.ExpiresAt))
+		sets = append(sets, fmt.Sprintf("expires_at = $%d", len(args)))
+	}
+	if u.MaxDownloads != nil {
+		args = append(args, nullInt(*u.MaxDownloads))
+		sets = append(sets, fmt.Sprintf("max_downloads = $%d", len(args)))
+	}
+	if u.PasswordHash != nil {
+		args = append(args, nullString(*u.PasswordHash))
+		sets = append(sets, fmt.Sprintf("password_hash = $%d", len(args)))
+	}
+	if u.Tags != nil {
+		args = append(args, joinTags(*u.Tags))
+		sets = append(sets, fmt.Sprintf("tags = string_to_array($%d, ',')", len(args)))
+	}
+	if u.Note != nil {
+		args = append(args, nullString(*u.Note))
+		sets = append(sets, fmt.Sprintf("note = $%d", len(args)))
+	}
+	if u.AllowedIPs != nil {
+		args = append(args, joinTags(*u.AllowedIPs))
+		sets = append(sets, fmt.Sprintf("allowed_ips = string_to_array($%d, ',')", len(args)))
+	}
+	if len(sets) == 0 {
+		// Only the TOTP secret changes; still match the row so a missing
+		// or trashed share is reported.
+		sets = append(sets, "id = id")
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE shares SET `+strings.Join(sets, ", ")+` WHERE id = $1 AND deleted_at IS NULL`, args...)
+	if err != nil {
+		return fmt.Errorf("update share: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if u.TOTPSecret != nil {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE share_secrets SET totp_secret = $2 WHERE share_id = $1`, id, *u.TOTPSecret); err != nil {
+			return fmt.Errorf("update share secrets: %w", err)
+		}
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO share_changes (share_id, at, actor, detail) VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		id, change.Time, change.Actor, change.Detail).Scan(&change.ID)
+	if err != nil {
+		return fmt.Errorf("record share change: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// ShareChanges returns a share's audit trail, oldest first.
+func (m *Postgres) ShareChanges(ctx context.Context, shareID string) ([]*ShareChange, error) {
+	if !isUUID(shareID) {
+		return nil, nil
+	}
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, replace(share_id::text, '-', ''), at, actor, detail
+		FROM share_changes WHERE share_id = $1 ORDER BY at, id`, shareID)
+	if err != nil {
+		return nil, fmt.Errorf("list share changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*ShareChange
+	for rows.Next() {
+		var c ShareChange
+		if err := rows.Scan(&c.ID, &c.ShareID, &c.Time, &c.Actor, &c.Detail); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
+
+// RecordShareChange appends change to the share_changes table.
+func (m *Postgres) RecordShareChange(ctx context.Context, change *ShareChange) error {
+	if !isUUID(change.ShareID) {
+		return ErrNotFound
+	}
+	err := m.db.QueryRowContext(ctx, `
+		INSERT INTO share_changes (share_id, at, actor, detail) VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		change.ShareID, change.Time, change.Actor, change.Detail).Scan(&change.ID)
+	if err != nil {
+		return fmt.Errorf("record share change: %w", err)
+	}
+	return nil
+}
+
+// RecordDownload appends ev to the download_events table.
+func (m *Postgres) RecordDownload(ctx context.Context, ev *DownloadEvent) error {
+	if !isUUID(ev.ShareID) {
+		return ErrNotFound
+	}
+	err := m.db.QueryRowContext(ctx, `
+		INSERT INTO download_events (share_id, at, ip_hash, user_agent, bytes, completed)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		ev.ShareID, ev.Time, ev.IPHash, ev.UserAgent, ev.Bytes, ev.Completed).Scan(&ev.ID)
+	if err != nil {
+		return fmt.Errorf("record download: %w", err)
+	}
+	return nil
+}
+
+// DownloadEvents returns a share's download events, oldest first.
+func (m *Postgres) DownloadEvents(ctx context.Context, shareID string) ([]*DownloadEvent, error) {
+	if !isUUID(shareID) {
+		return nil, nil
+	}
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, replace(share_id::text, '-', ''), at, ip_hash, user_agent, bytes, completed
+		FROM download_events WHERE share_id = $1 ORDER BY at, id`, shareID)
+	if err != nil {
+		return nil, fmt.Errorf("list download events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*DownloadEvent
+	for rows.Next() {
+		var ev DownloadEvent
+		if err := rows.Scan(&ev.ID, &ev.ShareID, &ev.Time, &ev.IPHash, &ev.UserAgent, &ev.Bytes, &ev.Completed); err != nil {
+			return nil, err
+		}
+		events = append(events, &ev)
+	}
+	return events, rows.Err()
+}
+
+// PurgeDownloadEvents removes events logged before before.
+func (m *Postgres) PurgeDownloadEvents(ctx context.Context, before time.Time) (int, error) {
+	result, err := m.db.ExecContext(ctx,
+		`DELETE FROM download_events WHERE at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// CreateHandshake inserts a new handshake row.
+func (m *Postgres) CreateHandshake(ctx context.Context, h *Handshake) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO handshakes (id, code, receiver_public_key, sender_public_key, share_id,
+		                        status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		h.ID, h.Code, h.ReceiverPublicKey, nullString(h.SenderPublicKey), nullString(h.ShareID),
+		handshakeStatus(h), h.CreatedAt, h.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert handshake: %w", err)
+	}
+	return nil
+}
+
+const postgresHandshakeColumns = `
+	replace(id::text, '-', ''), code, receiver_public_key,
+	coalesce(sender_public_key, ''), coalesce(replace(share_id::text, '-', ''), ''),
+	coalesce(created_at, now()), expires_at`
+
+// GetHandshake retrieves a handshake by ID.
+func (m *Postgres) GetHandshake(ctx context.Context, id string) (*Handshake, error) {
+	if !isUUID(id) {
+		return nil, ErrNotFound
+	}
+	row := m.db.QueryRowContext(ctx, `
+		SELECT `+postgresHandshakeColumns+`
+		FROM handshakes WHERE id = $1`, id)
+	return scanPostgresHandshake(row)
+}
+
+// GetHandshakeByCode retrieves a handshake by its pairing code.
+func (m *Postgres) GetHandshakeByCode(ctx context.Context, code string) (*Handshake, error) {
+	row := m.db.QueryRowContext(ctx, `
+		SELECT `+postgresHandshakeColumns+`
+		FROM handshakes WHERE code = $1`, code)
+	return scanPostgresHandshake(row)
+}
+
+// SetSenderPublicKey records the sender's public key and marks the
+// handshake paired, as the web app does.
+func (m *Postgres) SetSenderPublicKey(ctx context.Context, id, senderPubKey string) error {
+	if !isUUID(id) {
+		return ErrNotFound
+	}
+	result, err := m.db.ExecContext(ctx,
+		`UPDATE handshakes SET sender_public_key = $1, status = 'paired' WHERE id = $2`, senderPubKey, id)
+	if err != nil {
+		return fmt.Errorf("set sender public key: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetHandshakeShareID links a share to a handshake and marks it completed.
+func (m *Postgres) SetHandshakeShareID(ctx context.Context, id, shareID string) error {
+	if !isUUID(id) {
+		return ErrNotFound
+	}
+	result, err := m.db.ExecContext(ctx,
+		`UPDATE handshakes SET share_id = $1, status = 'completed' WHERE id = $2`, shareID, id)
+	if err != nil {
+		return fmt.Errorf("set handshake share_id: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeHandshakes removes handshakes that expired before now.
+func (m *Postgres) PurgeHandshakes(ctx context.Context, now time.Time) (int, error) {
+	result, err := m.db.ExecContext(ctx,
+		`DELETE FROM handshakes WHERE expires_at < $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+const postgresTokenColumns = `id, name, token_hash, array_to_string(scopes, ','), created_at, expires_at, last_used_at`
+
+// CreateAPIToken inserts a new api_tokens row.
+func (m *Postgres) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO api_tokens (id, name, token_hash, scopes, created_at, expires_at, last_used_at)
+		VALUES ($1, $2, $3, string_to_array($4, ','), $5, $6, $7)`,
+		t.ID, t.Name, t.Hash, strings.Join(t.Scopes, ","),
+		t.CreatedAt, nullTime(t.ExpiresAt), nullTime(t.LastUsedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("insert API token: %w", err)
+	}
+	return nil
+}
+
+// GetAPIToken retrieves a token by ID or name.
+func (m *Postgres) GetAPIToken(ctx context.Context, idOrName string) (*APIToken, error) {
+	row := m.db.QueryRowContext(ctx, `
+		SELECT `+postgresTokenColumns+` FROM api_tokens
+		WHERE id = $1 OR name = $1 ORDER BY id = $1 DESC LIMIT 1`, idOrName)
+	return scanPostgresToken(row)
+}
+
+// GetAPITokenByHash retrieves a token by the hash of its secret.
+func (m *Postgres) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	row := m.db.QueryRowContext(ctx, `
+		SELECT `+postgresTokenColumns+` FROM api_tokens WHERE token_hash = $1`, hash)
+	return scanPostgresToken(row)
+}
+
+// APITokens returns every token, oldest first.
+func (m *Postgres) APITokens(ctx context.Context) ([]*APIToken, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT `+postgresTokenColumns+` FROM api_tokens ORDER BY created_at, name`)
+	if err != nil {
+		return nil, fmt.Errorf("list API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		t, err := scanPostgresToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken removes a token.
+func (m *Postgres) DeleteAPIToken(ctx context.Context, id string) error {
+	result, err := m.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete API token: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchAPIToken sets a token's last-used time.
+func (m *Postgres) TouchAPIToken(ctx context.Context, id string, at time.Time) error {
+	if _, err := m.db.ExecContext(ctx,
+		`UPDATE api_tokens SET last_used_at = $1 WHERE id = $2`, at, id); err != nil {
+		return fmt.Errorf("touch API token: %w", err)
+	}
+	return nil
+}
+
+// Setting returns a value from the server_settings table, or "" if unset.
+func (m *Postgres) Setting(ctx context.Context, name string) (string, error) {
+	var value string
+	err := m.db.QueryRowContext(ctx, `SELECT value FROM server_settings WHERE name = $1`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get setting %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// SetSetting stores a value in the server_settings table; "" deletes it.
+func (m *Postgres) SetSetting(ctx context.Context, name, value string) error {
+	return setPostgresSetting(ctx, m.db, name, value)
+}
+
+// RewrapKeys replaces every stored share key with rewrap(id, stored) and
+// writes settings, in one transaction.
+func (m *Postgres) RewrapKeys(ctx context.Context, rewrap func(id, stored string) (string, error), settings map[string]string) (int, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	keys, err := queryStoredKeys(ctx, tx, `
+		SELECT replace(share_id::text, '-', ''), key_hex
+		FROM share_secrets WHERE key_hex != '' FOR UPDATE`)
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		wrapped, err := rewrap(k.id, k.key)
+		if err != nil {
+			return 0, fmt.Errorf("share %s: %w", k.id, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE share_secrets SET key_hex = $1 WHERE share_id = $2`, wrapped, k.id); err != nil {
+			return 0, fmt.Errorf("update share %s: %w", k.id, err)
+		}
+	}
+
+	for name, value := range settings {
+		if err := setPostgresSetting(ctx, tx, name, value); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return len(keys), nil
+}
+
+// Close closes the connection pool.
+func (m *Postgres) Close() error {
+	return m.db.Close()
+}
+
+func (m *Postgres) queryShares(ctx context.Context, query string, args ...any) ([]*Share, error) {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list shares: %w", err)
+	}
+	defer rows.Close()
+
+	var shares []*Share
+	for rows.Next() {
+		share, err := scanPostgresShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+func setPostgresSetting(ctx context.Context, db execer, name, value string) error {
+	var err error
+	if value == "" {
+		_, err = db.ExecContext(ctx, `DELETE FROM server_settings WHERE name = $1`, name)
+	} else {
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO server_settings (name, value) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET value = excluded.value`, name, value)
+	}
+	if err != nil {
+		return fmt.Errorf("set setting %s: %w", name, err)
+	}
+	return nil
+}
+
+func scanPostgresShare(row scanner) (*Share, error) {
+	var s Share
+	var expiresAt, availableFrom, deletedAt sql.NullTime
+	var tags, allowedIPs string
+	err := row.Scan(
+		&s.ID, &s.Filename, &s.BlobKey, &s.KeyHex, &s.SaltHex,
+		&s.CreatedAt, &expiresAt,
+		&s.MaxDownloads, &s.Downloads,
+		&s.PasswordHash, &s.AdminToken, &s.Size, &s.ClientEncrypted, &s.Burn,
+		&availableFrom, &deletedAt, &tags, &s.Note, &s.TOTPSecret, &allowedIPs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.ExpiresAt = expiresAt.Time // zero = never expires
+	s.AvailableFrom = availableFrom.Time
+	s.DeletedAt = deletedAt.Time
+	s.Tags = splitTags(tags)
+	s.AllowedIPs = splitTags(allowedIPs)
+	return &s, nil
+}
+
+func scanPostgresHandshake(row scanner) (*Handshake, error) {
+	var h Handshake
+	err := row.Scan(
+		&h.ID, &h.Code, &h.ReceiverPublicKey, &h.SenderPublicKey, &h.ShareID,
+		&h.CreatedAt, &h.ExpiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("scan handshake: %w", err)
+	}
+	return &h, nil
+}
+
+func scanPostgresToken(row scanner) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.Name, &t.Hash, &scopes, &t.CreatedAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("scan API token: %w", err)
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	t.ExpiresAt = expiresAt.Time
+	t.LastUsedAt = lastUsedAt.Time
+	return &t, nil
+}
+
+// handshakeStatus maps a handshake to the web app's status column.
+func handshakeStatus(h *Handshake) string {
+	switch {
+	case h.HasShare():
+		return "completed"
+	case h.HasSender():
+		return "paired"
+	default:
+		return "waiting"
+	}
+}
+
+// isUUID reports whether id is valid uuid input, dashed or not. Anything
+// else cannot match a row, and Postgres would reject it with an error.
+func isUUID(id string) bool {
+	if len(id) == 36 {
+		if id[8] != '-' || id[13] != '-' || id[18] != '-' || id[23] != '-' {
+			return false
+		}
+		id = strings.ReplaceAll(id, "-", "")
+	}
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime stores a zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nullInt stores 0 ("unlimited") as NULL, matching the web app.
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
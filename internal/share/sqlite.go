@@ -0,0 +1,780 @@
+package share
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLite is the default metadata store: a single database file in the data
+// dir, with Unix-second timestamps.
+type SQLite struct {
+	db *sql.DB
+}
+
+// NewSQLite opens (or creates) the SQLite database at path and applies any
+// pending migrations. It refuses databases written by a newer binary.
+func NewSQLite(path string) (*SQLite, error) {
+	m, err := OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.MigrateUp(context.Background()); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return m, nil
+}
+
+// OpenSQLite opens the SQLite database at path without migrating it, for
+// inspecting or changing the schema version.
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	db.SetMaxOpenConns(1) // SQLite is single-writer
+	return &SQLite{db: db}, nil
+}
+
+// SQLitePath returns the database file used for dataDir.
+func SQLitePath(dataDir string) string {
+	return filepath.Join(dataDir, "shares.db")
+}
+
+const sqliteShareColumns = `
+	id, filename, encrypted_path, key_hex, salt_hex, created_at, expires_at,
+	max_downloads, downloads, password_hash, admin_token, size, client_encrypted, burn,
+	available_from, deleted_at, tags, note, totp_secret, allowed_ips`
+
+// CreateShare inserts a share row.
+func (m *SQLite) CreateShare(ctx context.Context, share *Share) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO shares (`+sqliteShareColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		share.ID,
+		share.Filename,
+		share.BlobKey,
+		share.KeyHex,
+		share.SaltHex,
+		share.CreatedAt.Unix(),
+		unixOrZero(share.ExpiresAt),
+		share.MaxDownloads,
+		share.Downloads,
+		share.PasswordHash,
+		share.AdminToken,
+		share.Size,
+		share.ClientEncrypted,
+		share.Burn,
+		unixOrZero(share.AvailableFrom),
+		unixOrZero(share.DeletedAt),
+		joinTags(share.Tags),
+		share.Note,
+		share.TOTPSecret,
+		joinTags(share.AllowedIPs),
+	)
+	if err != nil {
+		return fmt.Errorf("insert share: %w", err)
+	}
+	return nil
+}
+
+// GetShare returns the share row with the given ID, trashed or not.
+func (m *SQLite) GetShare(ctx context.Context, id string) (*Share, error) {
+	row := m.db.QueryRowContext(ctx, `
+		SELECT `+sqliteShareColumns+`
+		FROM shares WHERE id = ?`, id)
+	share, err := scanSQLiteShare(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get share: %w", err)
+	}
+	return share, nil
+}
+
+// sqliteSortColumns maps sort keys to the expressions ordered by. Shares
+// without an expiry sort after every share that has one.
+var sqliteSortColumns = map[string]string{
+	SortCreated:   "created_at",
+	SortExpires:   "(CASE expires_at WHEN 0 THEN 9223372036854775807 ELSE expires_at END)",
+	SortSize:      "size",
+	SortDownloads: "downloads",
+	SortName:      "lower(filename)",
+}
+
+// ListShares returns the share rows not in the trash that match q at now,
+// in q's order.
+func (m *SQLite) ListShares(ctx context.Context, q ListQuery, now time.Time) ([]*Share, error) {
+	const (
+		notExpired = "(expires_at = 0 OR expires_at > ?)"
+		exhausted  = "(max_downloads > 0 AND downloads >= max_downloads)"
+	)
+	w := &sqlWhere{}
+	w.add("deleted_at = 0")
+	n := now.Unix()
+	switch q.Status {
+	case StatusExpired:
+		w.add("expires_at != 0 AND expires_at <= ?", n)
+	case StatusExhausted:
+		w.add(notExpired+" AND "+exhausted, n)
+	case StatusEmbargoed:
+		w.add(notExpired+" AND NOT "+exhausted+" AND available_from > ?", n, n)
+	case StatusActive:
+		w.add(notExpired+" AND NOT "+exhausted+" AND available_from <= ?", n, n)
+	}
+	for _, tag := range q.Tags {
+		w.add("instr(',' || tags || ',', ?) > 0", ","+tag+",")
+	}
+	if q.Search != "" {
+		s := strings.ToLower(q.Search)
+		w.add("(instr(id, ?) = 1 OR instr(lower(filename), ?) > 0 OR instr(lower(note), ?) > 0 OR instr(tags, ?) > 0)", s, s, s, s)
+	}
+	if q.MinSize > 0 {
+		w.add("size >= ?", q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		w.add("size <= ?", q.MaxSize)
+	}
+	if !q.CreatedAfter.IsZero() {
+		w.add("created_at >= ?", q.CreatedAfter.Unix())
+	}
+	if !q.CreatedBefore.IsZero() {
+		w.add("created_at < ?", q.CreatedBefore.Unix())
+	}
+	if !q.ExpiresAfter.IsZero() {
+		w.add("(expires_at = 0 OR expires_at >= ?)", q.ExpiresAfter.Unix())
+	}
+	if !q.ExpiresBefore.IsZero() {
+		w.add("expires_at != 0 AND expires_at < ?", q.ExpiresBefore.Unix())
+	}
+
+	key, desc := q.order()
+	col := sqliteSortColumns[key]
+	dir, cmp := "ASC", ">"
+	if desc {
+		dir, cmp = "DESC", "<"
+	}
+	c, err := q.cursor()
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		value := c.Value
+		if t, ok := value.(time.Time); ok {
+			value = t.Unix()
+			// The zero time is a share that never expires.
+			if key == SortExpires && t.IsZero() {
+				value = int64(math.MaxInt64)
+			}
+		}
+		if key == SortName {
+			w.add("("+col+", id) "+cmp+" (lower(?), ?)", value, c.ID)
+		} else {
+			w.add("("+col+", id) "+cmp+" (?, ?)", value, c.ID)
+		}
+	}
+
+	return m.queryShares(ctx, `
+		SELECT `+sqliteShareColumns+`
+		FROM shares WHERE `+w.String()+`
+		ORDER BY `+col+` `+dir+`, id `+dir+q.limitClause(), w.args...)
+}
+
+// AllShares returns every share row, including trashed, expired and
+// exhausted ones, oldest first.
+func (m *SQLite) AllShares(ctx context.Context) ([]*Share, error) {
+	return m.queryShares(ctx, `
+		SELECT `+sqliteShareColumns+`
+		FROM shares
+		ORDER BY created_at`)
+}
+
+// TrashedShares returns the shares in the trash, most recently trashed first.
+func (m *SQLite) TrashedShares(ctx context.Context) ([]*Share, error) {
+	return m.queryShares(ctx, `
+		SELECT `+sqliteShareColumns+`
+		FROM shares WHERE deleted_at > 0
+		ORDER BY deleted_at DESC`)
+}
+
+// TrashShare marks an active share as trashed at at.
+func (m *SQLite) TrashShare(ctx context.Context, id string, at time.Time) error {
+	result, err := m.db.ExecContext(ctx,
+		`UPDATE shares SET deleted_at = ? WHERE id = ? AND deleted_at = 0`, at.Unix(), id)
+	if err != nil {
+		return fmt.Errorf("trash share: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RestoreShare takes a share out of the trash.
+func (m *SQLite) RestoreShare(ctx context.Context, id string) error {
+	result, err := m.db.ExecContext(ctx,
+		`UPDATE shares SET deleted_at = 0 WHERE id = ? AND deleted_at > 0`, id)
+	if err != nil {
+		return fmt.Errorf("restore share: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *SQLite) queryShares(ctx context.Context, query string, args ...any) ([]*Share, error) {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list shares: %w", err)
+	}
+	defer rows.Close()
+
+	var shares []*Share
+	for rows.Next() {
+		share, err := scanSQLiteShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// ClaimDownload records claim if the share is still available at now. The
+// INSERT ... SELECT checks and claims in one statement.
+func (m *SQLite) ClaimDownload(ctx context.Context, claim *DownloadClaim, now, staleBefore time.Time) (bool, error) {
+	result, err := m.db.ExecContext(ctx, `
+		INSERT INTO download_claims (id, share_id, started_at)
+		SELECT ?, id, ? FROM shares
+		WHERE id = ? AND deleted_at = 0 AND (expires_at = 0 OR expires_at > ?) AND available_from <= ?
+		  AND (max_downloads = 0 OR downloads + (
+		       SELECT COUNT(*) FROM download_claims c
+		       WHERE c.share_id = shares.id AND c.started_at > ?) < max_downloads)`,
+		claim.ID, now.Unix(), claim.ShareID, now.Unix(), now.Unix(), staleBefore.Unix())
+	if err != nil {
+		return false, fmt.Errorf("claim download: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n == 1, nil
+}
+
+// RefreshDownload moves the claim's start to now.
+func (m *SQLite) RefreshDownload(ctx context.Context, claim *DownloadClaim, now time.Time) error {
+	if _, err := m.db.ExecContext(ctx,
+		`UPDATE download_claims SET started_at = ? WHERE id = ?`, now.Unix(), claim.ID); err != nil {
+		return fmt.Errorf("refresh download: %w", err)
+	}
+	return nil
+}
+
+// CommitDownload drops the claim and counts the download if the share is
+// under its limit.
+func (m *SQLite) CommitDownload(ctx context.Context, claim *DownloadClaim) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM download_claims WHERE id = ?`, claim.ID); err != nil {
+		return fmt.Errorf("commit download: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE shares SET downloads = downloads + 1
+		 WHERE id = ? AND (max_downloads = 0 OR downloads < max_downloads)`, claim.ShareID); err != nil {
+		return fmt.Errorf("commit download: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ReleaseDownload drops the claim.
+func (m *SQLite) ReleaseDownload(ctx context.Context, claim *DownloadClaim) error {
+	if _, err := m.db.ExecContext(ctx, `DELETE FROM download_claims WHERE id = ?`, claim.ID); err != nil {
+		return fmt.Errorf("release download: %w", err)
+	}
+	return nil
+}
+
+// PurgeDownloadClaims removes claims started before before.
+func (m *SQLite) PurgeDownloadClaims(ctx context.Context, before time.Time) (int, error) {
+	result, err := m.db.ExecContext(ctx,
+		`DELETE FROM download_claims WHERE started_at < ?`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// DeleteShare removes a share row and its download claims.
+func (m *SQLite) DeleteShare(ctx context.Context, id string) error {
+	if _, err := m.db.ExecContext(ctx, `DELETE FROM shares WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete share: %w", err)
+	}
+	_, _ = m.db.ExecContext(ctx, `DELETE FROM download_claims WHERE share_id = ?`, id)
+	return nil
+}
+
+// PurgeableShares returns the shares that expired before now or have no
+// downloads left. Shares without an expiry never expire.
+func (m *SQLite) PurgeableShares(ctx context.Context, now time.Time) ([]*Share, error) {
+	return m.queryShares(ctx, `
+		SELECT `+sqliteShareColumns+`
+		FROM shares
+		WHERE deleted_at = 0
+		  AND ((expires_at != 0 AND expires_at < ?) OR (max_downloads > 0 AND downloads >= max_downloads))`, now.Unix())
+}
+
+// ActiveCount returns the number of shares that have not expired by now.
+func (m *SQLite) ActiveCount(ctx context.Context, now time.Time) (int, error) {
+	var count int
+	err := m.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM shares WHERE deleted_at = 0 AND (expires_at = 0 OR expires_at > ?)`, now.Unix()).Scan(&count)
+	return count, err
+}
+
+// UpdateShare applies u and records change in one transaction.
+func (m *SQLite) UpdateShare(ctx context.Context, id string, u ShareUpdate, change *ShareChange) error {
+	var sets []string
+	var args []any
+	if u.ExpiresAt != nil {
+		sets = append(sets, "expires_at = ?")
+		args = append(args, unixOrZero(*u.ExpiresAt))
+	}
+	if u.MaxDownloads != nil {
+		sets = append(sets, "max_downloads = ?")
+		args = append(args, *u.MaxDownloads)
+	}
+	if u.PasswordHash != nil {
+		sets = append(sets, "password_hash = ?")
+		args = append(args, *u.PasswordHash)
+	}
+	if u.Tags != nil {
+		sets = append(sets, "tags = ?")
+		args = append(args, joinTags(*u.Tags))
+	}
+	if u.Note != nil {
+		sets = append(sets, "note = ?")
+		args = append(args, *u.Note)
+	}
+	if u.TOTPSecret != nil {
+		sets = append(sets, "totp_secret = ?")
+		args = append(args, *u.TOTPSecret)
+	}
+	if u.AllowedIPs != nil {
+		sets = append(sets, "allowed_ips = ?")
+		args = append(args, joinTags(*u.AllowedIPs))
+	}
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE shares SET `+strings.Join(sets, ", ")+` WHERE id = ? AND deleted_at = 0`, append(args, id)...)
+		if err != nil {
+			return fmt.Errorf("update share: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		result, err = tx.ExecContext(ctx, `
+			INSERT INTO share_changes (share_id, at, actor, detail) VALUES (?, ?, ?, ?)`,
+			id, change.Time.Unix(), change.Actor, change.Detail)
+		if err != nil {
+			return fmt.Errorf("record share change: %w", err)
+		}
+		change.ID, _ = result.LastInsertId()
+		return nil
+	})
+}
+
+// ShareChanges returns a share's audit trail, oldest first.
+func (m *SQLite) ShareChanges(ctx context.Context, shareID string) ([]*ShareChange, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, share_id, at, actor, detail
+		FROM share_changes WHERE share_id = ? ORDER BY at, id`, shareID)
+	if err != nil {
+		return nil, fmt.Errorf("list share changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []*ShareChange
+	for rows.Next() {
+		var c ShareChange
+		var at int64
+		if err := rows.Scan(&c.ID, &c.ShareID, &at, &c.Actor, &c.Detail); err != nil {
+			return nil, err
+		}
+		c.Time = time.Unix(at, 0)
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
+
+// RecordShareChange appends change to the share_changes table.
+func (m *SQLite) RecordShareChange(ctx context.Context, change *ShareChange) error {
+	result, err := m.db.ExecContext(ctx, `
+		INSERT INTO share_changes (share_id, at, actor, detail) VALUES (?, ?, ?, ?)`,
+		change.ShareID, change.Time.Unix(), change.Actor, change.Detail)
+	if err != nil {
+		return fmt.Errorf("record share change: %w", err)
+	}
+	change.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist, while other connections keep using it.
+func (m *SQLite) Backup(ctx context.Context, path string) error {
+	if _, err := m.db.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("backup database: %w", err)
+	}
+	return nil
+}
+
+// RecordDownload appends ev to the download_events table.
+func (m *SQLite) RecordDownload(ctx context.Context, ev *DownloadEvent) error {
+	result, err := m.db.ExecContext(ctx, `
+		INSERT INTO download_events (share_id, at, ip_hash, user_agent, bytes, completed)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		ev.ShareID, ev.Time.Unix(), ev.IPHash, ev.UserAgent, ev.Bytes, ev.Completed)
+	if err != nil {
+		return fmt.Errorf("record download: %w", err)
+	}
+	ev.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// DownloadEvents returns a share's download events, oldest first.
+func (m *SQLite) DownloadEvents(ctx context.Context, shareID string) ([]*DownloadEvent, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, share_id, at, ip_hash, user_agent, bytes, completed
+		FROM download_events WHERE share_id = ? ORDER BY at, id`, shareID)
+	if err != nil {
+		return nil, fmt.Errorf("list download events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*DownloadEvent
+	for rows.Next() {
+		var ev DownloadEvent
+		var at int64
+		if err := rows.Scan(&ev.ID, &ev.ShareID, &at, &ev.IPHash, &ev.UserAgent, &ev.Bytes, &ev.Completed); err != nil {
+			return nil, err
+		}
+		ev.Time = time.Unix(at, 0)
+		events = append(events, &ev)
+	}
+	return events, rows.Err()
+}
+
+// PurgeDownloadEvents removes events logged before before.
+func (m *SQLite) PurgeDownloadEvents(ctx context.Context, before time.Time) (int, error) {
+	result, err := m.db.ExecContext(ctx,
+		`DELETE FROM download_events WHERE at < ?`, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// CreateHandshake inserts a new handshake row.
+func (m *SQLite) CreateHandshake(ctx context.Context, h *Handshake) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO handshakes (id, code, receiver_public_key, sender_public_key, share_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		h.ID, h.Code, h.ReceiverPublicKey, h.SenderPublicKey, h.ShareID,
+		h.CreatedAt.Unix(), h.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("insert handshake: %w", err)
+	}
+	return nil
+}
+
+// GetHandshake retrieves a handshake by ID.
+func (m *SQLite) GetHandshake(ctx context.Context, id string) (*Handshake, error) {
+	row := m.db.QueryRowContext(ctx, `
+		SELECT id, code, receiver_public_key, sender_public_key, share_id,
+		       created_at, expires_at
+		FROM handshakes WHERE id = ?`, id)
+	return scanSQLiteHandshake(row)
+}
+
+// GetHandshakeByCode retrieves a handshake by its pairing code.
+func (m *SQLite) GetHandshakeByCode(ctx context.Context, code string) (*Handshake, error) {
+	row := m.db.QueryRowContext(ctx, `
+		SELECT id, code, receiver_public_key, sender_public_key, share_id,
+		       created_at, expires_at
+		FROM handshakes WHERE code = ?`, code)
+	return scanSQLiteHandshake(row)
+}
+
+// SetSenderPublicKey updates the sender's public key on a handshake.
+func (m *SQLite) SetSenderPublicKey(ctx context.Context, id, senderPubKey string) error {
+	result, err := m.db.ExecContext(ctx,
+		`UPDATE handshakes SET sender_public_key = ? WHERE id = ?`, senderPubKey, id)
+	if err != nil {
+		return fmt.Errorf("set sender public key: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetHandshakeShareID links a share to a handshake.
+func (m *SQLite) SetHandshakeShareID(ctx context.Context, id, shareID string) error {
+	result, err := m.db.ExecContext(ctx,
+		`UPDATE handshakes SET share_id = ? WHERE id = ?`, shareID, id)
+	if err != nil {
+		return fmt.Errorf("set handshake share_id: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PurgeHandshakes removes handshakes that expired before now.
+func (m *SQLite) PurgeHandshakes(ctx context.Context, now time.Time) (int, error) {
+	result, err := m.db.ExecContext(ctx,
+		`DELETE FROM handshakes WHERE expires_at < ?`, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+const sqliteTokenColumns = `id, name, token_hash, scopes, created_at, expires_at, last_used_at`
+
+// CreateAPIToken inserts a new api_tokens row.
+func (m *SQLite) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	_, err := m.db.ExecContext(ctx, `
+		INSERT INTO api_tokens (`+sqliteTokenColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.Name, t.Hash, strings.Join(t.Scopes, ","),
+		t.CreatedAt.Unix(), unixOrZero(t.ExpiresAt), unixOrZero(t.LastUsedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("insert API token: %w", err)
+	}
+	return nil
+}
+
+// GetAPIToken retrieves a token by ID or name.
+func (m *SQLite) GetAPIToken(ctx context.Context, idOrName string) (*APIToken, error) {
+	row := m.db.QueryRowContext(ctx, `
+		SELECT `+sqliteTokenColumns+` FROM api_tokens
+		WHERE id = ? OR name = ? ORDER BY id = ? DESC LIMIT 1`, idOrName, idOrName, idOrName)
+	return scanSQLiteToken(row)
+}
+
+// GetAPITokenByHash retrieves a token by the hash of its secret.
+func (m *SQLite) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	row := m.db.QueryRowContext(ctx, `
+		SELECT `+sqliteTokenColumns+` FROM api_tokens WHERE token_hash = ?`, hash)
+	return scanSQLiteToken(row)
+}
+
+// APITokens returns every token, oldest first.
+func (m *SQLite) APITokens(ctx context.Context) ([]*APIToken, error) {
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT `+sqliteTokenColumns+` FROM api_tokens ORDER BY created_at, name`)
+	if err != nil {
+		return nil, fmt.Errorf("list API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		t, err := scanSQLiteToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken removes a token.
+func (m *SQLite) DeleteAPIToken(ctx context.Context, id string) error {
+	result, err := m.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete API token: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchAPIToken sets a token's last-used time.
+func (m *SQLite) TouchAPIToken(ctx context.Context, id string, at time.Time) error {
+	if _, err := m.db.ExecContext(ctx,
+		`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, at.Unix(), id); err != nil {
+		return fmt.Errorf("touch API token: %w", err)
+	}
+	return nil
+}
+
+// Setting returns a value from the settings table, or "" if unset.
+func (m *SQLite) Setting(ctx context.Context, name string) (string, error) {
+	var value string
+	err := m.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE name = ?`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get setting %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// SetSetting stores a value in the settings table; "" deletes it.
+func (m *SQLite) SetSetting(ctx context.Context, name, value string) error {
+	return setSQLiteSetting(ctx, m.db, name, value)
+}
+
+// RewrapKeys replaces every stored share key with rewrap(id, stored) and
+// writes settings, in one transaction.
+func (m *SQLite) RewrapKeys(ctx context.Context, rewrap func(id, stored string) (string, error), settings map[string]string) (int, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	keys, err := queryStoredKeys(ctx, tx, `SELECT id, key_hex FROM shares WHERE key_hex != ''`)
+	if err != nil {
+		return 0, err
+	}
+	for _, k := range keys {
+		wrapped, err := rewrap(k.id, k.key)
+		if err != nil {
+			return 0, fmt.Errorf("share %s: %w", k.id, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE shares SET key_hex = ? WHERE id = ?`, wrapped, k.id); err != nil {
+			return 0, fmt.Errorf("update share %s: %w", k.id, err)
+		}
+	}
+
+	for name, value := range settings {
+		if err := setSQLiteSetting(ctx, tx, name, value); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return len(keys), nil
+}
+
+// Close closes the database connection.
+func (m *SQLite) Close() error {
+	return m.db.Close()
+}
+
+func setSQLiteSetting(ctx context.Context, db execer, name, value string) error {
+	var err error
+	if value == "" {
+		_, err = db.ExecContext(ctx, `DELETE FROM settings WHERE name = ?`, name)
+	} else {
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO settings (name, value) VALUES (?, ?)
+			ON CONFLICT(name) DO UPDATE SET value = excluded.value`, name, value)
+	}
+	if err != nil {
+		return fmt.Errorf("set setting %s: %w", name, err)
+	}
+	return nil
+}
+
+func scanSQLiteShare(row scanner) (*Share, error) {
+	var s Share
+	var createdAt, expiresAt, availableFrom, deletedAt int64
+	var tags, allowedIPs string
+	err := row.Scan(
+		&s.ID, &s.Filename, &s.BlobKey, &s.KeyHex, &s.SaltHex,
+		&createdAt, &expiresAt,
+		&s.MaxDownloads, &s.Downloads,
+		&s.PasswordHash, &s.AdminToken, &s.Size, &s.ClientEncrypted, &s.Burn,
+		&availableFrom, &deletedAt, &tags, &s.Note, &s.TOTPSecret, &allowedIPs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.CreatedAt = time.Unix(createdAt, 0)
+	if expiresAt != 0 {
+		s.ExpiresAt = time.Unix(expiresAt, 0)
+	}
+	if availableFrom != 0 {
+		s.AvailableFrom = time.Unix(availableFrom, 0)
+	}
+	if deletedAt != 0 {
+		s.DeletedAt = time.Unix(deletedAt, 0)
+	}
+	s.Tags = splitTags(tags)
+	s.AllowedIPs = splitTags(allowedIPs)
+	return &s, nil
+}
+
+func scanSQLiteHandshake(row scanner) (*Handshake, error) {
+	var h Handshake
+	var createdAt, expiresAt int64
+	err := row.Scan(
+		&h.ID, &h.Code, &h.ReceiverPublicKey, &h.SenderPublicKey, &h.ShareID,
+		&createdAt, &expiresAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("scan handshake: %w", err)
+	}
+	h.CreatedAt = time.Unix(createdAt, 0)
+	h.ExpiresAt = time.Unix(expiresAt, 0)
+	return &h, nil
+}
+
+func scanSQLiteToken(row scanner) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var createdAt, expiresAt, lastUsedAt int64
+	err := row.Scan(&t.ID, &t.Name, &t.Hash, &scopes, &createdAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("scan API token: %w", err)
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	t.CreatedAt = time.Unix(createdAt, 0)
+	if expiresAt != 0 {
+		t.ExpiresAt = time.Unix(expiresAt, 0)
+	}
+	if lastUsedAt != 0 {
+		t.LastUsedAt = time.Unix(lastUsedAt, 0)
+	}
+	return &t, nil
+}
+
+// unixOrZero stores a zero time as 0 rather than its (negative) Unix time.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
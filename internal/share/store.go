@@ -1,16 +1,21 @@
-// Package share manages file share metadata using SQLite.
+// Package share manages file share metadata. Records live in a Metadata
+// store (SQLite by default, or the web app's Postgres database); Store adds
+// key wrapping and the blob store holding the encrypted files.
 package share
 
 import (
 	"context"
-	"database/sql"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
-	_ "modernc.org/sqlite"
+	"github.com/unisoniq/durins-door/internal/blob"
 )
 
 // ErrNotFound is returned when a share is not found.
@@ -22,11 +27,14 @@ var ErrExpired = errors.New("share expired")
 // ErrExhausted is returned when a share has reached its download limit.
 var ErrExhausted = errors.New("share download limit reached")
 
+// ErrNotYetAvailable is returned when a share's embargo has not lifted yet.
+var ErrNotYetAvailable = errors.New("share not yet available")
+
 // Share represents a single file share entry.
 type Share struct {
 	ID            string
 	Filename      string    // original filename
-	EncryptedPath string    // path to encrypted file on disk
+	BlobKey       string    // blob store key of the encrypted file (legacy rows: absolute path)
 	KeyHex        string    // AES-256 key as hex
 	SaltHex       string    // Argon2id salt as hex (non-empty when key was passphrase-derived)
 	CreatedAt     time.Time
@@ -34,13 +42,48 @@ type Share struct {
 	MaxDownloads  int       // 0 = unlimited
 	Downloads     int
 	PasswordHash  string    // bcrypt hash of password, empty = no password
-	AdminToken    string    // token for admin operations
+	AdminToken    string    // owner token: lets its holder manage this share only
 	Size          int64     // original file size in bytes
+	// ClientEncrypted is true when the uploader encrypted the file before
+	// sending it. The blob is stored as-is and the server holds no key.
+	ClientEncrypted bool
+	// Burn marks a burn-after-reading share: one download (MaxDownloads is
+	// 1), after which the share and its file are deleted.
+	Burn bool
+	// AvailableFrom embargoes the share: it cannot be downloaded before
+	// this time. Zero means available immediately.
+	AvailableFrom time.Time
+	// DeletedAt is when the share was revoked into the trash; zero for
+	// active shares.
+	DeletedAt time.Time
+	// Tags label the share for filtering; see NormalizeTags.
+	Tags []string
+	// Note is a free-text description for the owner; never shown to
+	// downloaders.
+	Note string
+	// TOTPSecret, if set, is the base32 secret whose current code must be
+	// given with every download; see CheckTOTP.
+	TOTPSecret string
+	// AllowedIPs restricts downloads to clients inside these CIDR
+	// prefixes; empty allows any address. See NormalizeAllowedIPs.
+	AllowedIPs []string
 }
 
-// IsExpired returns true if the share has expired.
+// IsExpired returns true if the share has expired. A zero ExpiresAt never
+// expires; both metadata backends store it as "no expiry" and their queries
+// treat it the same way.
 func (s *Share) IsExpired() bool {
-	return time.Now().After(s.ExpiresAt)
+	return !s.ExpiresAt.IsZero() && time.Now().After(s.ExpiresAt)
+}
+
+// IsTrashed returns true if the share has been revoked into the trash.
+func (s *Share) IsTrashed() bool {
+	return !s.DeletedAt.IsZero()
+}
+
+// IsEmbargoed returns true if the share's AvailableFrom is still ahead.
+func (s *Share) IsEmbargoed() bool {
+	return !s.AvailableFrom.IsZero() && time.Now().Before(s.AvailableFrom)
 }
 
 // IsExhausted returns true if the share has hit its download limit.
@@ -60,10 +103,92 @@ func (s *Share) DownloadsRemaining() int {
 	return rem
 }
 
-// Store manages share persistence in SQLite.
+// Metadata persists share, handshake and settings records. Share rows hold
+// keys exactly as given (already wrapped); Store does the wrapping.
+// Implementations return ErrNotFound for missing rows. Trashed shares are
+// returned only by GetShare, TrashedShares and AllShares; every other query
+// skips them.
+type Metadata interface {
+	CreateShare(ctx context.Context, share *Share) error
+	GetShare(ctx context.Context, id string) (*Share, error)
+	// ListShares returns the rows that match q, with statuses evaluated at
+	// now, in q's order and up to q.Limit of them.
+	ListShares(ctx context.Context, q ListQuery, now time.Time) ([]*Share, error)
+	// AllShares returns every row, trashed or not, for consistency checks.
+	AllShares(ctx context.Context) ([]*Share, error)
+	// ClaimDownload records claim if, at now, the share exists, is out of
+	// its embargo, has not expired and its downloads plus claims started
+	// after staleBefore are under the limit, atomically. It reports whether
+	// the claim was made.
+	ClaimDownload(ctx context.Context, claim *DownloadClaim, now, staleBefore time.Time) (bool, error)
+	// RefreshDownload moves the claim's start to now, keeping it from going
+	// stale while its transfer is still running.
+	RefreshDownload(ctx context.Context, claim *DownloadClaim, now time.Time) error
+	// CommitDownload drops the claim and counts the download, unless the
+	// share has reached its limit: a claim that went stale may have had its
+	// slot taken by another download.
+	CommitDownload(ctx context.Context, claim *DownloadClaim) error
+	// ReleaseDownload drops the claim without counting it.
+	ReleaseDownload(ctx context.Context, claim *DownloadClaim) error
+	PurgeDownloadClaims(ctx context.Context, before time.Time) (int, error)
+	DeleteShare(ctx context.Context, id string) error
+	// TrashShare moves an active share to the trash; RestoreShare takes a
+	// trashed one out. Both return ErrNotFound if the share is not in the
+	// expected state.
+	TrashShare(ctx context.Context, id string, at time.Time) error
+	RestoreShare(ctx context.Context, id string) error
+	TrashedShares(ctx context.Context) ([]*Share, error)
+	// PurgeableShares returns the shares that have expired by now or used
+	// up their downloads.
+	PurgeableShares(ctx context.Context, now time.Time) ([]*Share, error)
+	ActiveCount(ctx context.Context, now time.Time) (int, error)
+
+	// UpdateShare applies the non-nil fields of u and appends change to the
+	// share's audit trail, atomically.
+	UpdateShare(ctx context.Context, id string, u ShareUpdate, change *ShareChange) error
+	ShareChanges(ctx context.Context, shareID string) ([]*ShareChange, error)
+	// RecordShareChange appends an already made change, as when importing
+	// a backup, and sets change.ID.
+	RecordShareChange(ctx context.Context, change *ShareChange) error
+
+	// RecordDownload appends ev to the download log and sets ev.ID.
+	RecordDownload(ctx context.Context, ev *DownloadEvent) error
+	DownloadEvents(ctx context.Context, shareID string) ([]*DownloadEvent, error)
+	PurgeDownloadEvents(ctx context.Context, before time.Time) (int, error)
+
+	CreateHandshake(ctx context.Context, h *Handshake) error
+	GetHandshake(ctx context.Context, id string) (*Handshake, error)
+	GetHandshakeByCode(ctx context.Context, code string) (*Handshake, error)
+	SetSenderPublicKey(ctx context.Context, id, senderPubKey string) error
+	SetHandshakeShareID(ctx context.Context, id, shareID string) error
+	PurgeHandshakes(ctx context.Context, now time.Time) (int, error)
+
+	CreateAPIToken(ctx context.Context, t *APIToken) error
+	// GetAPIToken looks a token up by ID or, failing that, by name.
+	GetAPIToken(ctx context.Context, idOrName string) (*APIToken, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error)
+	APITokens(ctx context.Context) ([]*APIToken, error)
+	DeleteAPIToken(ctx context.Context, id string) error
+	TouchAPIToken(ctx context.Context, id string, at time.Time) error
+
+	Setting(ctx context.Context, name string) (string, error)
+	SetSetting(ctx context.Context, name, value string) error
+	// RewrapKeys replaces every non-empty stored share key with
+	// rewrap(id, stored) and writes settings ("" deletes), atomically.
+	RewrapKeys(ctx context.Context, rewrap func(id, stored string) (string, error), settings map[string]string) (int, error)
+
+	Close() error
+}
+
+// Store manages share persistence.
 type Store struct {
-	db      *sql.DB
+	meta    Metadata
 	dataDir string
+	kek     []byte     // wraps share keys at rest; see SetKEK
+	blobs   blob.Store // encrypted files; local dataDir/files by default
+
+	ipKeyMu sync.Mutex
+	ipKey   []byte // see HashIP
 }
 
 // NewStore opens (or creates) a SQLite database at dataDir/shares.db.
@@ -71,185 +196,242 @@ func NewStore(dataDir string) (*Store, error) {
 	if err := os.MkdirAll(dataDir, 0700); err != nil {
 		return nil, fmt.Errorf("create data dir: %w", err)
 	}
-	dbPath := filepath.Join(dataDir, "shares.db")
-	db, err := sql.Open("sqlite", dbPath)
+	meta, err := NewSQLite(SQLitePath(dataDir))
 	if err != nil {
-		return nil, fmt.Errorf("open sqlite: %w", err)
+		return nil, err
 	}
-	db.SetMaxOpenConns(1) // SQLite is single-writer
-
-	s := &Store{db: db, dataDir: dataDir}
-	if err := s.migrate(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("migrate: %w", err)
+	s, err := New(meta, dataDir)
+	if err != nil {
+		meta.Close()
+		return nil, err
 	}
-	// Best-effort: add salt_hex to existing databases that pre-date this column.
-	_, _ = db.Exec(`ALTER TABLE shares ADD COLUMN salt_hex TEXT NOT NULL DEFAULT ''`)
 	return s, nil
 }
 
-func (s *Store) migrate() error {
-	_, err := s.db.Exec(`
-		CREATE TABLE IF NOT EXISTS shares (
-			id            TEXT PRIMARY KEY,
-			filename      TEXT NOT NULL,
-			encrypted_path TEXT NOT NULL,
-			key_hex       TEXT NOT NULL,
-			salt_hex      TEXT NOT NULL DEFAULT '',
-			created_at    INTEGER NOT NULL,
-			expires_at    INTEGER NOT NULL,
-			max_downloads INTEGER NOT NULL DEFAULT 0,
-			downloads     INTEGER NOT NULL DEFAULT 0,
-			password_hash TEXT NOT NULL DEFAULT '',
-			admin_token   TEXT NOT NULL DEFAULT '',
-			size          INTEGER NOT NULL DEFAULT 0
-		);
-		CREATE INDEX IF NOT EXISTS idx_shares_expires ON shares(expires_at);
-
-		CREATE TABLE IF NOT EXISTS handshakes (
-			id                  TEXT PRIMARY KEY,
-			code                TEXT UNIQUE NOT NULL,
-			receiver_public_key TEXT NOT NULL,
-			sender_public_key   TEXT NOT NULL DEFAULT '',
-			share_id            TEXT NOT NULL DEFAULT '',
-			created_at          INTEGER NOT NULL,
-			expires_at          INTEGER NOT NULL
-		);
-		CREATE INDEX IF NOT EXISTS idx_handshakes_code ON handshakes(code);
-		CREATE INDEX IF NOT EXISTS idx_handshakes_expires ON handshakes(expires_at);
-	`)
-	return err
+// New returns a Store keeping records in meta. dataDir still holds the local
+// blob store and upload staging.
+func New(meta Metadata, dataDir string) (*Store, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	blobs, err := blob.NewLocal(filepath.Join(dataDir, "files"))
+	if err != nil {
+		return nil, err
+	}
+	return &Store{meta: meta, dataDir: dataDir, blobs: blobs}, nil
 }
 
 // Create inserts a new share record.
+// The share key is wrapped under the KEK before it is written.
 func (s *Store) Create(ctx context.Context, share *Share) error {
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO shares (id, filename, encrypted_path, key_hex, salt_hex, created_at, expires_at,
-		                    max_downloads, downloads, password_hash, admin_token, size)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		share.ID,
-		share.Filename,
-		share.EncryptedPath,
-		share.KeyHex,
-		share.SaltHex,
-		share.CreatedAt.Unix(),
-		share.ExpiresAt.Unix(),
-		share.MaxDownloads,
-		share.Downloads,
-		share.PasswordHash,
-		share.AdminToken,
-		share.Size,
-	)
+	if (share.TOTPSecret != "" || len(share.AllowedIPs) > 0) && !s.SupportsAccessPolicies() {
+		return ErrPoliciesUnsupported
+	}
+	keyHex, err := s.WrapKey(share.ID, share.KeyHex)
 	if err != nil {
-		return fmt.Errorf("insert share: %w", err)
+		return fmt.Errorf("wrap key: %w", err)
 	}
-	return nil
+	row := *share
+	row.KeyHex = keyHex
+	return s.meta.CreateShare(ctx, &row)
 }
 
-// Get retrieves a share by ID, validating expiry and download limits.
+// Get retrieves an active share by ID. Trashed shares are not found.
 func (s *Store) Get(ctx context.Context, id string) (*Share, error) {
-	row := s.db.QueryRowContext(ctx, `
-		SELECT id, filename, encrypted_path, key_hex, salt_hex, created_at, expires_at,
-		       max_downloads, downloads, password_hash, admin_token, size
-		FROM shares WHERE id = ?`, id)
-	share, err := scanShare(row)
-	if errors.Is(err, sql.ErrNoRows) {
+	share, err := s.meta.GetShare(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if share.IsTrashed() {
 		return nil, ErrNotFound
 	}
-	if err != nil {
-		return nil, fmt.Errorf("get share: %w", err)
+	if err := s.openKey(share); err != nil {
+		return nil, err
 	}
 	return share, nil
 }
 
-// IncrementDownloads atomically increments the download counter.
-func (s *Store) IncrementDownloads(ctx context.Context, id string) error {
-	result, err := s.db.ExecContext(ctx,
-		`UPDATE shares SET downloads = downloads + 1 WHERE id = ?`, id)
+// CheckOwnerToken reports whether token is the owner token of the share
+// with the given ID. Shares without an owner token, such as those created
+// by the web app, have no owner.
+func (s *Store) CheckOwnerToken(ctx context.Context, id, token string) (bool, error) {
+	share, err := s.meta.GetShare(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("increment downloads: %w", err)
+		return false, err
 	}
-	n, _ := result.RowsAffected()
-	if n == 0 {
-		return ErrNotFound
+	if share.AdminToken == "" || token == "" {
+		return false, nil
 	}
-	return nil
+	return subtle.ConstantTimeCompare([]byte(share.AdminToken), []byte(token)) == 1, nil
 }
 
-// List returns all non-expired shares.
-func (s *Store) List(ctx context.Context) ([]*Share, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, filename, encrypted_path, key_hex, salt_hex, created_at, expires_at,
-		       max_downloads, downloads, password_hash, admin_token, size
-		FROM shares
-		ORDER BY created_at DESC`)
+// claimTTL bounds how long an unfinished download holds one of a share's
+// download slots. Failed transfers release their claim straight away, and
+// running ones refresh it every claimRefresh (see HoldDownload); this only
+// matters for claims left behind by a crash.
+const (
+	claimTTL     = time.Hour
+	claimRefresh = claimTTL / 4
+)
+
+// DownloadClaim is a download slot held while a share's file is being sent.
+type DownloadClaim struct {
+	ID      string
+	ShareID string
+}
+
+// ClaimDownload atomically checks that a share can still be downloaded and
+// reserves one download for the transfer about to start. In-flight claims
+// count against the limit, so concurrent requests cannot overrun it; the
+// caller must CommitDownload once the whole file has been sent or
+// ReleaseDownload if it was not, and HoldDownload for as long as the transfer
+// runs. It returns ErrNotFound, ErrNotYetAvailable,
+// ErrExpired or ErrExhausted when the share is unavailable.
+func (s *Store) ClaimDownload(ctx context.Context, id string) (*DownloadClaim, error) {
+	claim := &DownloadClaim{ID: newClaimID(), ShareID: id}
+	now := time.Now()
+	ok, err := s.meta.ClaimDownload(ctx, claim, now, now.Add(-claimTTL))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return claim, nil
+	}
+	share, err := s.meta.GetShare(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("list shares: %w", err)
+		return nil, err
+	}
+	if share.IsTrashed() {
+		return nil, ErrNotFound
+	}
+	if share.IsEmbargoed() {
+		return nil, ErrNotYetAvailable
+	}
+	if share.IsExpired() {
+		return nil, ErrExpired
 	}
-	defer rows.Close()
+	return nil, ErrExhausted
+}
 
-	var shares []*Share
-	for rows.Next() {
-		share, err := scanShareRow(rows)
-		if err != nil {
-			return nil, err
+// HoldDownload keeps claim from going stale while its transfer runs, however
+// long that takes, by refreshing it in the background until stop is called.
+// A transfer outliving claimTTL would otherwise lose its slot to another
+// download.
+func (s *Store) HoldDownload(ctx context.Context, claim *DownloadClaim) (stop func()) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(claimRefresh)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				// A failed refresh is retried at the next tick; should the
+				// claim go stale regardless, CommitDownload still keeps
+				// the count within the limit.
+				_ = s.meta.RefreshDownload(ctx, claim, now)
+			}
 		}
-		shares = append(shares, share)
+	}()
+	return func() {
+		cancel()
+		<-done
 	}
-	return shares, rows.Err()
 }
 
-// Revoke deletes a share and its encrypted file.
-func (s *Store) Revoke(ctx context.Context, id string) error {
-	share, err := s.Get(ctx, id)
+// CommitDownload counts a claimed download that completed. If that was the
+// share's last allowed download (always the case for burn shares), the
+// share and its file are deleted straight away rather than at expiry. When
+// the file cannot be deleted the error is returned and the row is kept, so
+// Purge retries.
+func (s *Store) CommitDownload(ctx context.Context, claim *DownloadClaim) error {
+	if err := s.meta.CommitDownload(ctx, claim); err != nil {
+		return err
+	}
+	share, err := s.meta.GetShare(ctx, claim.ShareID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	// Remove encrypted file
-	_ = os.Remove(share.EncryptedPath)
+	if !share.IsExhausted() {
+		return nil
+	}
+	return s.remove(ctx, share)
+}
+
+// ReleaseDownload gives a claimed download slot back after a failed transfer.
+func (s *Store) ReleaseDownload(ctx context.Context, claim *DownloadClaim) error {
+	return s.meta.ReleaseDownload(ctx, claim)
+}
+
+// PurgeDownloadClaims removes claims older than the claim TTL.
+func (s *Store) PurgeDownloadClaims(ctx context.Context) (int, error) {
+	return s.meta.PurgeDownloadClaims(ctx, time.Now().Add(-claimTTL))
+}
+
+// Revoke moves a share to the trash. It can no longer be downloaded, but
+// Restore brings it back until EmptyTrash deletes it and its file.
+func (s *Store) Revoke(ctx context.Context, id string) error {
+	return s.meta.TrashShare(ctx, id, time.Now())
+}
 
-	_, err = s.db.ExecContext(ctx, `DELETE FROM shares WHERE id = ?`, id)
+// Delete removes a share, active or trashed, and its encrypted file for
+// good.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	share, err := s.meta.GetShare(ctx, id)
 	if err != nil {
-		return fmt.Errorf("delete share: %w", err)
+		return err
 	}
-	return nil
+	return s.remove(ctx, share)
 }
 
-// Purge removes all expired shares and their files.
+// Purge removes all expired and exhausted shares and their files. A share
+// whose file cannot be deleted is kept for the next run.
 func (s *Store) Purge(ctx context.Context) (int, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, encrypted_path FROM shares WHERE expires_at < ?`, time.Now().Unix())
+	shares, err := s.meta.PurgeableShares(ctx, time.Now())
 	if err != nil {
 		return 0, err
 	}
-	defer rows.Close()
-
-	type expired struct{ id, path string }
-	var toDelete []expired
-	for rows.Next() {
-		var e expired
-		if err := rows.Scan(&e.id, &e.path); err != nil {
-			return 0, err
-		}
-		toDelete = append(toDelete, e)
-	}
-	rows.Close()
 
 	count := 0
-	for _, e := range toDelete {
-		_ = os.Remove(e.path)
-		s.db.ExecContext(ctx, `DELETE FROM shares WHERE id = ?`, e.id)
+	for _, sh := range shares {
+		if err := s.remove(ctx, sh); err != nil {
+			continue
+		}
 		count++
 	}
 	return count, nil
 }
 
+// remove deletes a share's file and then its row.
+func (s *Store) remove(ctx context.Context, sh *Share) error {
+	if err := s.blobs.Delete(ctx, sh.BlobKey); err != nil {
+		return fmt.Errorf("delete file of share %s: %w", sh.ID, err)
+	}
+	return s.meta.DeleteShare(ctx, sh.ID)
+}
+
 // ActiveCount returns the number of active (non-expired) shares.
 func (s *Store) ActiveCount(ctx context.Context) (int, error) {
-	var count int
-	err := s.db.QueryRowContext(ctx,
-		`SELECT COUNT(*) FROM shares WHERE expires_at > ?`, time.Now().Unix()).Scan(&count)
-	return count, err
+	return s.meta.ActiveCount(ctx, time.Now())
+}
+
+// SetBlobStore replaces the default local blob store.
+func (s *Store) SetBlobStore(b blob.Store) {
+	s.blobs = b
+}
+
+// Blobs returns the store holding the encrypted files.
+func (s *Store) Blobs() blob.Store {
+	return s.blobs
 }
 
 // DataDir returns the data directory path.
@@ -259,43 +441,31 @@ func (s *Store) DataDir() string {
 
 // Close closes the database connection.
 func (s *Store) Close() error {
-	return s.db.Close()
+	return s.meta.Close()
 }
 
-type scanner interface {
-	Scan(dest ...any) error
-}
-
-func scanShare(row *sql.Row) (*Share, error) {
-	var s Share
-	var createdAt, expiresAt int64
-	err := row.Scan(
-		&s.ID, &s.Filename, &s.EncryptedPath, &s.KeyHex, &s.SaltHex,
-		&createdAt, &expiresAt,
-		&s.MaxDownloads, &s.Downloads,
-		&s.PasswordHash, &s.AdminToken, &s.Size,
-	)
+// openKey unwraps a scanned share's key in place. Without a KEK the wrapped
+// value is left as-is, so metadata-only commands (list, revoke) keep working.
+func (s *Store) openKey(sh *Share) error {
+	if s.kek == nil {
+		return nil
+	}
+	keyHex, err := s.UnwrapKey(sh.ID, sh.KeyHex)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("share %s: %w", sh.ID, err)
 	}
-	s.CreatedAt = time.Unix(createdAt, 0)
-	s.ExpiresAt = time.Unix(expiresAt, 0)
-	return &s, nil
+	sh.KeyHex = keyHex
+	return nil
 }
 
-func scanShareRow(rows *sql.Rows) (*Share, error) {
-	var s Share
-	var createdAt, expiresAt int64
-	err := rows.Scan(
-		&s.ID, &s.Filename, &s.EncryptedPath, &s.KeyHex, &s.SaltHex,
-		&createdAt, &expiresAt,
-		&s.MaxDownloads, &s.Downloads,
-		&s.PasswordHash, &s.AdminToken, &s.Size,
-	)
-	if err != nil {
-		return nil, err
+func newClaimID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failure: %v", err))
 	}
-	s.CreatedAt = time.Unix(createdAt, 0)
-	s.ExpiresAt = time.Unix(expiresAt, 0)
-	return &s, nil
+	return hex.EncodeToString(b)
+}
+
+type scanner interface {
+	Scan(dest ...any) error
 }
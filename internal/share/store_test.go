@@ -0,0 +1,138 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLimitedShare creates a client-encrypted share allowing max downloads.
+func newLimitedShare(t *testing.T, st *Store, id string, max int) {
+	t.Helper()
+	sh := &Share{
+		ID:              id,
+		Filename:        "ring.bin",
+		Size:            1,
+		BlobKey:         id,
+		CreatedAt:       time.Now(),
+		ExpiresAt:       time.Now().Add(time.Hour),
+		MaxDownloads:    max,
+		ClientEncrypted: true,
+	}
+	if err := st.Create(context.Background(), sh); err != nil {
+		t.Fatalf("create share: %v", err)
+	}
+}
+
+// claimConcurrently makes n simultaneous claims on the share and returns
+// the ones that were granted.
+func claimConcurrently(t *testing.T, st *Store, id string, n int) []*DownloadClaim {
+	t.Helper()
+	var (
+		mu      sync.Mutex
+		granted []*DownloadClaim
+		wg      sync.WaitGroup
+	)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claim, err := st.ClaimDownload(context.Background(), id)
+			if errors.Is(err, ErrExhausted) {
+				return
+			}
+			if err != nil {
+				t.Errorf("claim download: %v", err)
+				return
+			}
+			mu.Lock()
+			granted = append(granted, claim)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return granted
+}
+
+// ageClaims makes every claim on the share older than claimTTL, as if its
+// transfer had been running that long without refreshing it.
+func ageClaims(t *testing.T, st *Store, id string) {
+	t.Helper()
+	_, err := st.meta.(*SQLite).db.Exec(`UPDATE download_claims SET started_at = started_at - ? WHERE share_id = ?`,
+		int64((2 * claimTTL).Seconds()), id)
+	if err != nil {
+		t.Fatalf("age claims: %v", err)
+	}
+}
+
+func TestDownloadLimitHoldsWhenClaimsGoStale(t *testing.T) {
+	st, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	const id, limit = "stale", 3
+	newLimitedShare(t, st, id, limit)
+
+	first := claimConcurrently(t, st, id, 20)
+	if len(first) != limit {
+		t.Fatalf("%d claims granted, want %d", len(first), limit)
+	}
+
+	// The first transfers run past claimTTL, so their slots are handed out
+	// again. All of them still finish.
+	ageClaims(t, st, id)
+	second := claimConcurrently(t, st, id, 20)
+	if len(second) != limit {
+		t.Fatalf("%d claims granted after the first went stale, want %d", len(second), limit)
+	}
+
+	var wg sync.WaitGroup
+	for _, claim := range append(first, second...) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := st.meta.CommitDownload(ctx, claim); err != nil {
+				t.Errorf("commit download: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sh, err := st.meta.GetShare(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sh.Downloads != limit {
+		t.Errorf("share counts %d downloads, limit is %d", sh.Downloads, limit)
+	}
+}
+
+func TestRefreshedClaimKeepsItsSlot(t *testing.T) {
+	st, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	const id = "held"
+	newLimitedShare(t, st, id, 1)
+
+	claim, err := st.ClaimDownload(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := st.HoldDownload(ctx, claim)
+	defer stop()
+
+	ageClaims(t, st, id)
+	if err := st.meta.RefreshDownload(ctx, claim, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.ClaimDownload(ctx, id); !errors.Is(err, ErrExhausted) {
+		t.Errorf("second claim while the first is held: got %v, want ErrExhausted", err)
+	}
+}
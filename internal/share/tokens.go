@@ -0,0 +1,192 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrTokenNotFound is returned for an API token name or ID that does not
+// exist.
+var ErrTokenNotFound = errors.New("API token not found")
+
+// ErrInvalidToken is returned by AuthenticateToken for a token that is
+// unknown, revoked or expired.
+var ErrInvalidToken = errors.New("invalid or expired API token")
+
+// API token scopes. Each guards one group of server routes; ScopeAdmin
+// grants every other scope as well.
+const (
+	ScopeUpload    = "upload"    // create shares
+	ScopeDownload  = "download"  // fetch share metadata and files
+	ScopeHandshake = "handshake" // run key exchanges
+	ScopeList      = "list"      // list shares and read their history
+	ScopeAdmin     = "admin"     // the dashboard, updates and revocation
+)
+
+// Scopes lists every scope, in the order they are displayed.
+var Scopes = []string{ScopeUpload, ScopeDownload, ScopeHandshake, ScopeList, ScopeAdmin}
+
+// apiTokenPrefix starts every API token, so a leaked one is easy to spot.
+const apiTokenPrefix = "dd_"
+
+// tokenTouchInterval is how stale LastUsedAt may get before
+// AuthenticateToken writes it again, so busy clients do not write to the
+// database on every request.
+const tokenTouchInterval = time.Minute
+
+// tokenNamePattern is what a token name looks like: the same alphabet as
+// tags.
+var tokenNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// APIToken is a named credential for the server API. Only a hash of the
+// token is stored; the token itself is shown once, when it is created.
+type APIToken struct {
+	ID         string
+	Name       string
+	Hash       string // hex SHA-256 of the token
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time // zero = never
+	LastUsedAt time.Time // zero = never used
+}
+
+// IsExpired reports whether the token has passed its expiry.
+func (t *APIToken) IsExpired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)
+}
+
+// HasScope reports whether the token grants scope, directly or through
+// ScopeAdmin.
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// ParseScopes lowercases, checks, de-duplicates and orders scopes as in
+// Scopes. At least one is required.
+func ParseScopes(scopes []string) ([]string, error) {
+	var out []string
+	for _, s := range scopes {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if !slices.Contains(Scopes, s) {
+			return nil, fmt.Errorf("unknown scope %q (want %s)", s, strings.Join(Scopes, ", "))
+		}
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("at least one scope is required (%s)", strings.Join(Scopes, ", "))
+	}
+	slices.SortFunc(out, func(a, b string) int {
+		return slices.Index(Scopes, a) - slices.Index(Scopes, b)
+	})
+	return out, nil
+}
+
+// hashAPIToken returns the hash stored for token. Tokens are random and
+// long, so a plain SHA-256 is enough to keep them out of the database.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken adds a token with the given name, scopes and expiry (zero
+// for none). It returns the token's record and the token itself, which
+// cannot be recovered later.
+func (s *Store) CreateAPIToken(ctx context.Context, name string, scopes []string, expiresAt time.Time) (*APIToken, string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !tokenNamePattern.MatchString(name) {
+		return nil, "", fmt.Errorf("token name %q must be 1-32 characters of a-z, 0-9, '.', '_' or '-'", name)
+	}
+	scopes, err := ParseScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("token expiry %s is in the past", expiresAt.Format(time.RFC3339))
+	}
+	if _, err := s.meta.GetAPIToken(ctx, name); err == nil {
+		return nil, "", fmt.Errorf("a token named %q already exists", name)
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, "", err
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	token := apiTokenPrefix + hex.EncodeToString(secret)
+	t := &APIToken{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hashAPIToken(token),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.meta.CreateAPIToken(ctx, t); err != nil {
+		return nil, "", err
+	}
+	return t, token, nil
+}
+
+// APITokens returns every API token, oldest first, expired ones included.
+func (s *Store) APITokens(ctx context.Context) ([]*APIToken, error) {
+	return s.meta.APITokens(ctx)
+}
+
+// RevokeAPIToken deletes the token with the given ID or name and returns
+// it. Requests made with it fail from then on.
+func (s *Store) RevokeAPIToken(ctx context.Context, idOrName string) (*APIToken, error) {
+	t, err := s.meta.GetAPIToken(ctx, strings.ToLower(strings.TrimSpace(idOrName)))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.meta.DeleteAPIToken(ctx, t.ID); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// AuthenticateToken returns the API token matching token, recording its
+// use. It returns ErrInvalidToken if there is none or it has expired.
+func (s *Store) AuthenticateToken(ctx context.Context, token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	t, err := s.meta.GetAPITokenByHash(ctx, hashAPIToken(token))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.IsExpired() {
+		return nil, ErrInvalidToken
+	}
+	if now := time.Now(); now.Sub(t.LastUsedAt) >= tokenTouchInterval {
+		if err := s.meta.TouchAPIToken(ctx, t.ID, now); err != nil {
+			return nil, err
+		}
+		t.LastUsedAt = now
+	}
+	return t, nil
+}
@@ -0,0 +1,53 @@
+package share
+
+import (
+	"context"
+	"time"
+)
+
+// Trash returns the revoked shares still in the trash, most recently
+// revoked first.
+func (s *Store) Trash(ctx context.Context) ([]*Share, error) {
+	shares, err := s.meta.TrashedShares(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		if err := s.openKey(share); err != nil {
+			return nil, err
+		}
+	}
+	return shares, nil
+}
+
+// Restore takes a share out of the trash. It returns ErrNotFound if the
+// share is not in the trash. A share that expired meanwhile is restored as
+// is; extend it with Update before the next Purge removes it.
+func (s *Store) Restore(ctx context.Context, id string) (*Share, error) {
+	if err := s.meta.RestoreShare(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// EmptyTrash deletes the shares that were revoked more than olderThan ago,
+// files included; 0 empties the whole trash. A share whose file cannot be
+// deleted stays in the trash for the next run.
+func (s *Store) EmptyTrash(ctx context.Context, olderThan time.Duration) (int, error) {
+	shares, err := s.meta.TrashedShares(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	count := 0
+	for _, sh := range shares {
+		if sh.DeletedAt.After(cutoff) {
+			continue
+		}
+		if err := s.remove(ctx, sh); err != nil {
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
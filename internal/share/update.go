@@ -0,0 +1,213 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidUpdate is returned (wrapped with the reason) when a share update
+// is not allowed.
+var ErrInvalidUpdate = errors.New("invalid share update")
+
+// MaxExpirySetting names the setting in which "durins-door server" records
+// its --max-expiry, so that updates made to the database directly are held
+// to the same limit as those made through the API.
+const MaxExpirySetting = "max_expiry"
+
+// CheckMaxExpiry returns an error if t lies further ahead than limit, the
+// longest expiry a share may be given; 0 means no limit.
+func CheckMaxExpiry(t time.Time, limit time.Duration) error {
+	if limit > 0 && t.After(time.Now().Add(limit)) {
+		return fmt.Errorf("expiry exceeds this server's maximum of %s", durationString(limit))
+	}
+	return nil
+}
+
+// durationString formats an expiry limit: whole days as "7d", otherwise as
+// time.Duration does, without trailing zero units ("90m" is "1h30m").
+func durationString(d time.Duration) string {
+	const day = 24 * time.Hour
+	if d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	s := strings.TrimSuffix(d.String(), "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// ShareUpdate lists the share settings to change; nil fields are left as
+// they are.
+type ShareUpdate struct {
+	ExpiresAt    *time.Time
+	MaxDownloads *int      // 0 = unlimited
+	PasswordHash *string   // bcrypt hash; "" removes the password
+	Tags         *[]string // replaces all tags; empty removes them
+	Note         *string   // "" removes the note
+	TOTPSecret   *string   // base32 secret; "" removes the TOTP requirement
+	AllowedIPs   *[]string // replaces the IP allowlist; empty allows any address
+}
+
+// IsEmpty reports whether the update changes nothing.
+func (u ShareUpdate) IsEmpty() bool {
+	return u.ExpiresAt == nil && u.MaxDownloads == nil && u.PasswordHash == nil &&
+		u.Tags == nil && u.Note == nil && u.TOTPSecret == nil && u.AllowedIPs == nil
+}
+
+// ShareChange is an entry in a share's audit trail: one update of its
+// settings. Like download events, changes are kept after the share is gone.
+type ShareChange struct {
+	ID      int64
+	ShareID string
+	Time    time.Time
+	Actor   string // who made the change, e.g. "cli" or "api"
+	Detail  string // human-readable summary of what changed
+}
+
+// Update changes a share's expiry, download limit, password, labels or
+// access policies and records the change in its audit trail. The new
+// expiry must be in the future, a burn share keeps its single download, a
+// limit must stay above the downloads already made, and tags, note and
+// allowed IPs must be valid (see NormalizeTags and NormalizeAllowedIPs),
+// and TOTP and IP allowlists need a store that supports them (see
+// SupportsAccessPolicies); violations return ErrInvalidUpdate.
+func (s *Store) Update(ctx context.Context, id string, u ShareUpdate, actor string) (*Share, error) {
+	sh, err := s.meta.GetShare(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.IsEmpty() {
+		return nil, fmt.Errorf("%w: nothing to change", ErrInvalidUpdate)
+	}
+	if u.ExpiresAt != nil && !u.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiry must be in the future", ErrInvalidUpdate)
+	}
+	if u.MaxDownloads != nil {
+		switch n := *u.MaxDownloads; {
+		case n < 0:
+			return nil, fmt.Errorf("%w: max downloads cannot be negative", ErrInvalidUpdate)
+		case sh.Burn && n != 1:
+			return nil, fmt.Errorf("%w: a burn share allows exactly one download", ErrInvalidUpdate)
+		case n > 0 && n <= sh.Downloads:
+			return nil, fmt.Errorf("%w: share has already been downloaded %d time(s)", ErrInvalidUpdate, sh.Downloads)
+		}
+	}
+
+	if ((u.TOTPSecret != nil && *u.TOTPSecret != "") || (u.AllowedIPs != nil && len(*u.AllowedIPs) > 0)) && !s.SupportsAccessPolicies() {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidUpdate, ErrPoliciesUnsupported)
+	}
+	if u.Tags != nil {
+		tags, err := NormalizeTags(*u.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidUpdate, err)
+		}
+		u.Tags = &tags
+	}
+	if u.Note != nil {
+		note, err := NormalizeNote(*u.Note)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidUpdate, err)
+		}
+		u.Note = &note
+	}
+	if u.AllowedIPs != nil {
+		ips, err := NormalizeAllowedIPs(*u.AllowedIPs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidUpdate, err)
+		}
+		u.AllowedIPs = &ips
+	}
+
+	change := &ShareChange{
+		ShareID: sh.ID,
+		Time:    time.Now(),
+		Actor:   actor,
+		Detail:  describeUpdate(sh, u),
+	}
+	if err := s.meta.UpdateShare(ctx, sh.ID, u, change); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, sh.ID)
+}
+
+// ShareChanges returns a share's audit trail, oldest first.
+func (s *Store) ShareChanges(ctx context.Context, shareID string) ([]*ShareChange, error) {
+	return s.meta.ShareChanges(ctx, shareID)
+}
+
+// describeUpdate summarises u against the share's current settings.
+func describeUpdate(sh *Share, u ShareUpdate) string {
+	var parts []string
+	if u.ExpiresAt != nil {
+		from := "never"
+		if !sh.ExpiresAt.IsZero() {
+			from = sh.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		parts = append(parts, fmt.Sprintf("expiry %s → %s", from, u.ExpiresAt.UTC().Format(time.RFC3339)))
+	}
+	if u.MaxDownloads != nil {
+		parts = append(parts, fmt.Sprintf("max downloads %s → %s", limitString(sh.MaxDownloads), limitString(*u.MaxDownloads)))
+	}
+	if u.PasswordHash != nil {
+		switch {
+		case *u.PasswordHash == "":
+			parts = append(parts, "password removed")
+		case sh.PasswordHash == "":
+			parts = append(parts, "password set")
+		default:
+			parts = append(parts, "password changed")
+		}
+	}
+	if u.Tags != nil {
+		parts = append(parts, fmt.Sprintf("tags %s → %s", tagsString(sh.Tags), tagsString(*u.Tags)))
+	}
+	if u.Note != nil {
+		switch {
+		case *u.Note == "":
+			parts = append(parts, "note removed")
+		case sh.Note == "":
+			parts = append(parts, "note set")
+		default:
+			parts = append(parts, "note changed")
+		}
+	}
+	if u.TOTPSecret != nil {
+		switch {
+		case *u.TOTPSecret == "":
+			parts = append(parts, "TOTP removed")
+		case sh.TOTPSecret == "":
+			parts = append(parts, "TOTP set")
+		default:
+			parts = append(parts, "TOTP secret replaced")
+		}
+	}
+	if u.AllowedIPs != nil {
+		parts = append(parts, fmt.Sprintf("allowed IPs %s → %s", ipsString(sh.AllowedIPs), ipsString(*u.AllowedIPs)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func ipsString(ips []string) string {
+	if len(ips) == 0 {
+		return "any"
+	}
+	return strings.Join(ips, ",")
+}
+
+func tagsString(tags []string) string {
+	if len(tags) == 0 {
+		return "none"
+	}
+	return strings.Join(tags, ", ")
+}
+
+func limitString(n int) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(n)
+}
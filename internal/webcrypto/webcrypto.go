@@ -4,6 +4,15 @@
 // Wire format: IV (12 bytes) || GCM ciphertext+tag
 // This is a simpler single-block format (NOT the chunked streaming format
 // used by the server's internal/crypto package).
+//
+// A sealed blob is encrypted under a key that also depends on a password
+// (see Seal) and is prefixed with a marker and the Argon2id salt:
+// "DDSEALv1" || salt (16 bytes) || IV || GCM ciphertext+tag.
+// Browsers cannot run Argon2id with Web Crypto, so only the CLI opens them.
+//
+// A streamed blob is made from a file too large to encrypt in one block
+// (see NewStreamReader): "DDSTRMv1" || the chunked stream of internal/crypto
+// under the same key. Browsers decrypt it chunk by chunk.
 package webcrypto
 
 import (
@@ -13,6 +22,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+
+	ddcrypto "github.com/unisoniq/durins-door/internal/crypto"
 )
 
 const (
@@ -20,7 +31,22 @@ const (
 	IVSize  = 12
 )
 
-// EncryptResult holds the encrypted payload and the base64-encoded key.
+// sealMagic starts every sealed blob. Plain blobs start with a random IV,
+// so one is mistaken for a sealed blob with probability 2^-64.
+const sealMagic = "DDSEALv1"
+
+// SealOverhead is how much longer a sealed blob is than a plain one.
+const SealOverhead = 8 + ddcrypto.SaltSize // len(sealMagic) + salt
+
+// streamMagic starts every streamed blob, like sealMagic sealed ones.
+const streamMagic = "DDSTRMv1"
+
+// SealFragment is added to the "#key=" fragment of links to sealed blobs,
+// so download pages can say a password is needed before fetching the file.
+const SealFragment = "sealed=1"
+
+// EncryptResult holds the encrypted payload and the key, encoded as unpadded
+// base64url exactly as the browser client puts it in the "#key=" fragment.
 type EncryptResult struct {
 	Blob   []byte
 	KeyB64 string
@@ -41,7 +67,7 @@ func Encrypt(plaintext []byte) (*EncryptResult, error) {
 
 	return &EncryptResult{
 		Blob:   blob,
-		KeyB64: base64.StdEncoding.EncodeToString(key),
+		KeyB64: EncodeKey(key),
 	}, nil
 }
 
@@ -70,12 +96,9 @@ func encryptWithKey(plaintext, key []byte) ([]byte, error) {
 
 // Decrypt decrypts a blob (IV || ciphertext+tag) using the given base64-encoded key.
 func Decrypt(blob []byte, keyB64 string) ([]byte, error) {
-	key, err := decodeBase64Key(keyB64)
+	key, err := DecodeKey(keyB64)
 	if err != nil {
-		return nil, fmt.Errorf("decoding key: %w", err)
-	}
-	if len(key) != KeySize {
-		return nil, fmt.Errorf("invalid key length %d, expected %d", len(key), KeySize)
+		return nil, err
 	}
 	return decryptRaw(blob, key)
 }
@@ -121,6 +144,145 @@ func decryptRaw(blob, key []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
+// Seal encrypts plaintext under key mixed with password (crypto.MixPassword)
+// and a fresh salt. The blob cannot be decrypted with key alone.
+func Seal(plaintext, key []byte, password string) ([]byte, error) {
+	if password == "" {
+		return nil, fmt.Errorf("sealing needs a password")
+	}
+	salt := make([]byte, ddcrypto.SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+	sealKey, err := ddcrypto.MixPassword(key, password, salt)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := encryptWithKey(plaintext, sealKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(sealMagic), salt...), blob...), nil
+}
+
+// IsSealed reports whether blob was made by Seal.
+func IsSealed(blob []byte) bool {
+	return len(blob) >= SealOverhead && string(blob[:len(sealMagic)]) == sealMagic
+}
+
+// Unseal decrypts a blob made by Seal with the same key and password.
+func Unseal(blob, key []byte, password string) ([]byte, error) {
+	if !IsSealed(blob) {
+		return nil, fmt.Errorf("blob is not sealed with a password")
+	}
+	salt := blob[len(sealMagic) : len(sealMagic)+ddcrypto.SaltSize]
+	sealKey, err := ddcrypto.MixPassword(key, password, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decryptRaw(blob[SealOverhead:], sealKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrong password, key or corrupted data: %w", err)
+	}
+	return plaintext, nil
+}
+
+// StreamReader reads a streamed blob, encrypting its source as it goes. It
+// can seek, so resumable uploads can rewind it.
+type StreamReader struct {
+	enc *ddcrypto.EncryptReader
+	pos int64
+}
+
+// NewStreamReader returns a StreamReader over the first size bytes of src,
+// encrypted under key.
+func NewStreamReader(src io.ReadSeeker, size int64, key []byte) (*StreamReader, error) {
+	enc, err := ddcrypto.NewEncryptReader(src, size, key)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamReader{enc: enc}, nil
+}
+
+// Size returns the length of the streamed blob.
+func (r *StreamReader) Size() int64 {
+	return int64(len(streamMagic)) + r.enc.Size()
+}
+
+// StreamSize returns the length of the streamed blob of size bytes.
+func StreamSize(size int64) int64 {
+	return int64(len(streamMagic)) + ddcrypto.EncryptedSize(size)
+}
+
+// Read reads the blob at the current position.
+func (r *StreamReader) Read(p []byte) (int, error) {
+	magic := int64(len(streamMagic))
+	if r.pos < magic {
+		n := copy(p, streamMagic[r.pos:])
+		r.pos += int64(n)
+		return n, nil
+	}
+	if _, err := r.enc.Seek(r.pos-magic, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := r.enc.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+// Seek sets the position in the blob.
+func (r *StreamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// IsStream reports whether blob was made by a StreamReader.
+func IsStream(blob []byte) bool {
+	return len(blob) >= len(streamMagic)+ddcrypto.NonceSize && string(blob[:len(streamMagic)]) == streamMagic
+}
+
+// DecryptStream decrypts a streamed blob from src with key, writing the
+// plaintext to dst.
+func DecryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	magic := make([]byte, len(streamMagic))
+	if _, err := io.ReadFull(src, magic); err != nil || string(magic) != streamMagic {
+		return fmt.Errorf("blob is not streamed")
+	}
+	if err := ddcrypto.DecryptStream(dst, src, key); err != nil {
+		return fmt.Errorf("decrypting: %w (wrong key or corrupted data)", err)
+	}
+	return nil
+}
+
+// DecodeKey decodes a base64 key in any of the encodings Decrypt accepts.
+func DecodeKey(keyB64 string) ([]byte, error) {
+	key, err := decodeBase64Key(keyB64)
+	if err != nil {
+		return nil, fmt.Errorf("decoding key: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key length %d, expected %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// EncodeKey encodes a raw key as unpadded base64url for use in a URL fragment.
+func EncodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
 func decodeBase64Key(s string) ([]byte, error) {
 	for _, enc := range []*base64.Encoding{
 		base64.StdEncoding,
@@ -0,0 +1,29 @@
+-- Durin's Door — Tables for the Go server
+-- Lets `durins-door server --database-url postgres://...` keep its share
+-- metadata in this database alongside the web app.
+--
+-- The Go server reads and writes the existing shares and handshakes tables.
+-- Fields only it needs (wrapped encryption keys, admin tokens) live in
+-- share_secrets so they never show up in web queries on shares. Both new
+-- tables have RLS enabled and no policies: only the service role (which the
+-- Go server connects as) can see them.
+
+create table if not exists share_secrets (
+  share_id uuid primary key references shares(id) on delete cascade,
+  key_hex text not null default '',
+  salt_hex text not null default '',
+  admin_token text not null default '',
+  client_encrypted boolean not null default false
+);
+
+alter table share_secrets enable row level security;
+
+create table if not exists server_settings (
+  name text primary key,
+  value text not null
+);
+
+alter table server_settings enable row level security;
+
+-- The Go server purges expired shares on a timer
+create index if not exists idx_shares_expires_at on shares(expires_at);
@@ -0,0 +1,13 @@
+-- Durin's Door — In-flight downloads for the Go server
+-- A claim holds one of a share's download slots while the file is being
+-- sent; it is counted when the transfer completes and dropped if it fails.
+
+create table if not exists download_claims (
+  id text primary key,
+  share_id uuid not null references shares(id) on delete cascade,
+  started_at timestamptz not null default now()
+);
+
+create index if not exists idx_download_claims_share on download_claims(share_id, started_at);
+
+alter table download_claims enable row level security;
@@ -0,0 +1,5 @@
+-- Durin's Door — Burn-after-reading shares
+-- A burn share is deleted, file included, as soon as its one download
+-- completes.
+
+alter table shares add column if not exists burn boolean not null default false;
@@ -0,0 +1,20 @@
+-- Durin's Door — Download audit log for the Go server
+-- One row per transfer of a share's file. Rows are not tied to shares by a
+-- foreign key so the history of a deleted share stays readable; the server
+-- removes rows older than its event retention. The IP is stored only as a
+-- keyed hash.
+
+create table if not exists download_events (
+  id bigserial primary key,
+  share_id uuid not null,
+  at timestamptz not null default now(),
+  ip_hash text not null default '',
+  user_agent text not null default '',
+  bytes bigint not null default 0,
+  completed boolean not null default false
+);
+
+create index if not exists idx_download_events_share on download_events(share_id, at);
+create index if not exists idx_download_events_at on download_events(at);
+
+alter table download_events enable row level security;
@@ -0,0 +1,16 @@
+-- Durin's Door — Audit trail of share updates for the Go server
+-- One row per change to a share's expiry, download limit or password. Like
+-- download_events, rows are not tied to shares by a foreign key so they
+-- outlive the share.
+
+create table if not exists share_changes (
+  id bigserial primary key,
+  share_id uuid not null,
+  at timestamptz not null default now(),
+  actor text not null default '',
+  detail text not null default ''
+);
+
+create index if not exists idx_share_changes_share on share_changes(share_id, at);
+
+alter table share_changes enable row level security;
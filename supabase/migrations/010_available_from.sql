@@ -0,0 +1,5 @@
+-- Durin's Door — Embargoed shares
+-- The Go server refuses downloads of a share before available_from
+-- (null = available immediately). The web app does not check it yet.
+
+alter table shares add column if not exists available_from timestamptz;
@@ -0,0 +1,54 @@
+-- Durin's Door — Trash for revoked shares
+-- The Go server revokes a share by setting deleted_at; it keeps the row and
+-- the file until the trash is emptied, so the share can be restored. Rows
+-- with deleted_at set must not be served: the web app's API routes and
+-- download page skip them, signed-in users cannot read them, and they no
+-- longer count downloads.
+
+alter table shares add column if not exists deleted_at timestamptz;
+
+create index if not exists idx_shares_deleted_at on shares(deleted_at) where deleted_at is not null;
+
+drop policy if exists "Authenticated users can view shares" on shares;
+create policy "Authenticated users can view shares" on shares
+  for select to authenticated
+  using (deleted_at is null);
+
+-- As in 003_security_hardening, plus: a trashed share is not found.
+create or replace function increment_download_count(share_id uuid)
+returns boolean
+language plpgsql
+security definer
+as $$
+declare
+  v_expires_at timestamptz;
+  v_max_downloads int;
+  v_download_count int;
+begin
+  select expires_at, max_downloads, download_count
+    into v_expires_at, v_max_downloads, v_download_count
+    from shares
+    where id = share_id and deleted_at is null
+    for update;
+
+  if not found then
+    return false;
+  end if;
+
+  -- Check expiry
+  if v_expires_at is not null and v_expires_at < now() then
+    return false;
+  end if;
+
+  -- Check download limit
+  if v_max_downloads is not null and v_max_downloads > 0 and v_download_count >= v_max_downloads then
+    return false;
+  end if;
+
+  update shares
+    set download_count = download_count + 1
+    where id = share_id;
+
+  return true;
+end;
+$$;
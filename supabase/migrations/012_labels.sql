@@ -0,0 +1,8 @@
+-- Durin's Door — Share labels
+-- Tags and a free-text note set by the uploader to find shares again. The
+-- Go server filters on them; the web app ignores both.
+
+alter table shares add column if not exists tags text[] not null default '{}';
+alter table shares add column if not exists note text;
+
+create index if not exists idx_shares_tags on shares using gin (tags);
@@ -0,0 +1,16 @@
+-- Durin's Door — Scoped API tokens for the Go server
+-- Named bearer tokens with scopes (upload, download, handshake, list, admin)
+-- and an optional expiry, managed with `durins-door admin token`. Only a
+-- SHA-256 of each token is stored. The web app never reads this table.
+
+create table if not exists api_tokens (
+  id text primary key,
+  name text not null unique,
+  token_hash text not null unique,
+  scopes text[] not null,
+  created_at timestamptz not null default now(),
+  expires_at timestamptz,
+  last_used_at timestamptz
+);
+
+alter table api_tokens enable row level security;
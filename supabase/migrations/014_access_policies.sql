@@ -0,0 +1,9 @@
+-- Durin's Door — Per-share access policies for the Go server
+-- A TOTP secret whose current code recipients must enter, and CIDR
+-- prefixes they must connect from. The secret lives in share_secrets with
+-- the other fields only the Go server may read. The web app serves the same
+-- shares without enforcing either, so the Go server refuses to set them on
+-- this database; the columns stay empty and exist so the schemas match.
+
+alter table share_secrets add column if not exists totp_secret text not null default '';
+alter table shares add column if not exists allowed_ips text[] not null default '{}';
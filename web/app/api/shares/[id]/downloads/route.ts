@@ -20,6 +20,7 @@ export async function POST(request: NextRequest, { params }: Params) {
       .from('shares')
       .select('id')
       .eq('id', id)
+      .is('deleted_at', null)
       .single()
 
     if (fetchErr || !share) {
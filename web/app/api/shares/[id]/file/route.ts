@@ -19,6 +19,7 @@ export async function GET(request: NextRequest, { params }: Params) {
       .from('shares')
       .select('id, storage_path, filename, expires_at, max_downloads, download_count')
       .eq('id', id)
+      .is('deleted_at', null)
       .single()
 
     if (error || !share) {
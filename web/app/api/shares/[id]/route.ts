@@ -20,6 +20,7 @@ export async function GET(request: NextRequest, { params }: Params) {
       .from('shares')
       .select('*')
       .eq('id', id)
+      .is('deleted_at', null)
       .single()
 
     if (error || !data) {
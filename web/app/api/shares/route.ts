@@ -16,6 +16,7 @@ export async function GET(request: NextRequest) {
     const { data, error } = await supabase
       .from('shares')
       .select('*')
+      .is('deleted_at', null)
       .order('created_at', { ascending: false })
 
     if (error) {
@@ -0,0 +1,8 @@
+/**
+ * POST /api/upload/raw — Upload a client-encrypted blob.
+ *
+ * The hosted app always stores uploads as-is (the browser encrypts before
+ * uploading), so this is the same handler as /api/upload. It exists so the
+ * CLI can target one endpoint for both the Go server and the web app.
+ */
+export { POST } from '../route'
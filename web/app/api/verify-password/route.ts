@@ -15,6 +15,7 @@ export async function POST(req: NextRequest) {
       .from('shares')
       .select('id, password_hash, expires_at, max_downloads, download_count')
       .eq('id', shareId)
+      .is('deleted_at', null)
       .single()
 
     if (error || !share) {
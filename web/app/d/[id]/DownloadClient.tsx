@@ -40,6 +40,14 @@ export default function DownloadClient({ share, humanSizeStr, expiresIn, fileIco
       return
     }
 
+    // Sealed files (durins-door upload --seal) mix the password into the key
+    // with Argon2id, which Web Crypto cannot do. Stop before spending a download.
+    if (/[#&]sealed=1(&|$)/.test(hash)) {
+      setErrorMsg(`This file is sealed with its password and can only be opened with the CLI: durins-door download "${window.location.href}"`)
+      setDlState('error')
+      return
+    }
+
     // For password-protected shares, use server-side proxy to prevent bypass
     if (share.password_hash) {
       if (!password) { setPasswordError('Please enter the password.'); return }
@@ -17,6 +17,7 @@ export default async function DownloadPage({ params }: Props) {
     .from('shares')
     .select('*')
     .eq('id', id)
+    .is('deleted_at', null)
     .single()
 
   // Error states
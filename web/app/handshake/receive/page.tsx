@@ -142,6 +142,7 @@ export default function HandshakeReceivePage() {
         .from('shares')
         .select('*')
         .eq('id', shareId)
+        .is('deleted_at', null)
         .single()
 
       if (!share) throw new Error('File record not found.')
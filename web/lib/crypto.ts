@@ -48,12 +48,36 @@ export async function decryptFile(cipherBlob: ArrayBuffer, keyB64: string): Prom
   const key = await crypto.subtle.importKey('raw', keyBytes, 'AES-GCM', false, ['decrypt'])
 
   const cipherArray = new Uint8Array(cipherBlob)
+  if (isStream(cipherArray)) return await decryptStream(cipherArray, key)
   const iv = cipherArray.slice(0, 12)
   const data = cipherArray.slice(12)
 
   return await crypto.subtle.decrypt({ name: 'AES-GCM', iv }, key, data)
 }
 
+/**
+ * Large files uploaded with the CLI are streamed: "DDSTRMv1", a 12-byte file
+ * nonce, then chunks of [4-byte big-endian length][12-byte IV][ciphertext+tag],
+ * each its own GCM message under the same key.
+ */
+function isStream(blob: Uint8Array): boolean {
+  return blob.length >= 20 && new TextDecoder().decode(blob.subarray(0, 8)) === 'DDSTRMv1'
+}
+
+async function decryptStream(blob: Uint8Array, key: CryptoKey): Promise<ArrayBuffer> {
+  const view = new DataView(blob.buffer, blob.byteOffset, blob.byteLength)
+  const parts: ArrayBuffer[] = []
+  for (let pos = 20; pos < blob.length; ) {
+    if (pos + 16 > blob.length) throw new Error('Truncated file')
+    const end = pos + 16 + view.getUint32(pos)
+    if (end > blob.length) throw new Error('Truncated file')
+    const iv = blob.slice(pos + 4, pos + 16)
+    parts.push(await crypto.subtle.decrypt({ name: 'AES-GCM', iv }, key, blob.slice(pos + 16, end)))
+    pos = end
+  }
+  return await new Blob(parts).arrayBuffer()
+}
+
 /** Decrypt a blob using an existing CryptoKey (for handshake mode). */
 export async function decryptFileWithKey(cipherBlob: ArrayBuffer, key: CryptoKey): Promise<ArrayBuffer> {
   const cipherArray = new Uint8Array(cipherBlob)